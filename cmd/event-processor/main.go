@@ -10,8 +10,17 @@ import (
 	"strings"
 	"time"
 
+	"github.com/openfga/go-sdk/client"
+
 	"mapping-engine/internal/config"
+	"mapping-engine/internal/deadletter"
+	"mapping-engine/internal/dedup"
 	"mapping-engine/internal/engine"
+	"mapping-engine/internal/logging"
+	"mapping-engine/internal/model"
+	"mapping-engine/internal/ordering"
+	"mapping-engine/internal/retry"
+	"mapping-engine/internal/store"
 	"mapping-engine/internal/types"
 )
 
@@ -21,6 +30,10 @@ type CLIConfig struct {
 	StoreID           string
 	ModelID           string
 	ModelFile         string
+	WriteModel        bool
+	WriteMode         string
+	MaxTuplesPerWrite int
+	MaxParallelWrites int
 	AuthMethod        string
 	ClientID          string
 	ClientSecret      string
@@ -29,44 +42,88 @@ type CLIConfig struct {
 	Issuer            string
 	Verbose           bool
 	DryRun            bool
-	UserMappings      string
-	OrgMappings       string
-	OrgMemberMappings string
-	OrgRoleMappings   string
+	TuplesFile        string
+	MaxAttempts       int
+	DeadLetterFile    string
+	ListDeadLetters   bool
+	ReplayDeadLetters bool
+	DedupFile         string
+	DedupTTL          time.Duration
+	Mappings          string
+	LogLevel          string
+	LogFormat         string
 }
 
 type EventProcessor struct {
-	engine         *engine.MappingEngine
-	userConfig     *types.MappingConfig
-	orgConfig      *types.MappingConfig
-	orgMemberConfig *types.MappingConfig
-	orgRoleConfig   *types.MappingConfig
-	verbose        bool
-	dryRun         bool
+	engine          *engine.MappingEngine
+	mappingConfigs  []*engine.CompiledMappingConfig
+	verbose         bool
+	dryRun          bool
+	retryPolicy     retry.Policy
+	deadLetters     *deadletter.File
+	processedEvents dedup.Store
+	ordering        *ordering.Tracker
 }
 
 type ProcessingResult struct {
-	EventType     string                 `json:"event_type"`
-	Success       bool                   `json:"success"`
-	Error         string                 `json:"error,omitempty"`
-	TuplesAdded   []types.ProcessedTuple `json:"tuples_added,omitempty"`
-	TuplesDeleted []types.ProcessedTuple `json:"tuples_deleted,omitempty"`
-	Duration      time.Duration          `json:"duration"`
+	EventType     string                  `json:"event_type"`
+	Success       bool                    `json:"success"`
+	Error         string                  `json:"error,omitempty"`
+	TuplesAdded   []types.ProcessedTuple  `json:"tuples_added,omitempty"`
+	TuplesDeleted []types.ProcessedTuple  `json:"tuples_deleted,omitempty"`
+	MappingErrors []string                `json:"mapping_errors,omitempty"`
+	Mappings      []engine.MappingOutcome `json:"mappings,omitempty"`
+	Chunks        int                     `json:"chunks,omitempty"` // OpenFGA write requests
+	Duplicate     bool                    `json:"duplicate,omitempty"`
+	Stale         bool                    `json:"stale,omitempty"`
+	Unmapped      bool                    `json:"unmapped,omitempty"` // No mapping configuration declares the event type
+	Attempts      int                     `json:"attempts"`
+	Duration      time.Duration           `json:"duration"`
+
+	event map[string]interface{}
+	err   error
 }
 
 func main() {
+	// "validate" is a subcommand taking the same flags as event processing
+	validate := len(os.Args) > 1 && os.Args[1] == "validate"
+	if validate {
+		os.Args = append(os.Args[:1], os.Args[2:]...)
+	}
+
 	cfg := parseFlags()
-	
+
+	if validate {
+		if err := validateMappings(cfg); err != nil {
+			log.Fatalf("Mapping validation failed:\n%v", err)
+		}
+		return
+	}
+
+	if cfg.ListDeadLetters {
+		if err := listDeadLetters(cfg); err != nil {
+			log.Fatalf("Failed to list dead-lettered events: %v", err)
+		}
+		return
+	}
+
+	if cfg.ReplayDeadLetters {
+		if err := replayDeadLetters(cfg); err != nil {
+			log.Fatalf("Failed to replay dead-lettered events: %v", err)
+		}
+		return
+	}
+
 	if cfg.EventsFile == "" {
 		log.Fatal("Events file is required. Use -events flag.")
 	}
-	
+
 	// Load events from JSON file
 	events, err := loadEventsFromFile(cfg.EventsFile)
 	if err != nil {
 		log.Fatalf("Failed to load events from file: %v", err)
 	}
-	
+
 	fmt.Printf("🚀 Auth0 to OpenFGA Event Processor\n")
 	fmt.Printf("====================================\n")
 	fmt.Printf("📁 Events file: %s\n", cfg.EventsFile)
@@ -78,28 +135,32 @@ func main() {
 		fmt.Printf("🔍 DRY RUN MODE - No changes will be made\n")
 	}
 	fmt.Printf("\n")
-	
+
 	// Create event processor
 	processor, err := NewEventProcessor(cfg)
 	if err != nil {
 		log.Fatalf("Failed to create event processor: %v", err)
 	}
-	
+
 	// Process all events
 	results := processor.ProcessEvents(context.Background(), events)
-	
+
 	// Print summary
 	printSummary(results)
 }
 
 func parseFlags() *CLIConfig {
 	cfg := &CLIConfig{}
-	
+
 	flag.StringVar(&cfg.EventsFile, "events", "", "Path to JSON file containing Auth0 events")
 	flag.StringVar(&cfg.OpenFGAURL, "openfga-url", getEnvOrDefault("OPENFGA_API_URL", "http://localhost:8080"), "OpenFGA API URL")
 	flag.StringVar(&cfg.StoreID, "store-id", getEnvOrDefault("OPENFGA_STORE_ID", ""), "OpenFGA Store ID")
-	flag.StringVar(&cfg.ModelID, "model-id", getEnvOrDefault("OPENFGA_MODEL_ID", ""), "OpenFGA Authorization Model ID")
+	flag.StringVar(&cfg.ModelID, "model-id", getEnvOrDefault("OPENFGA_MODEL_ID", store.LatestModel), "OpenFGA Authorization Model ID writes are pinned to; \"latest\" picks the newest model matching -model-file")
 	flag.StringVar(&cfg.ModelFile, "model-file", getEnvOrDefault("OPENFGA_MODEL_FILE", "configs/model.json"), "OpenFGA model file")
+	flag.BoolVar(&cfg.WriteModel, "write-model", getEnvOrDefault("OPENFGA_WRITE_MODEL", "") == "true", "Write -model-file to the store when no model in it matches")
+	flag.StringVar(&cfg.WriteMode, "write-mode", getEnvOrDefault("OPENFGA_WRITE_MODE", string(engine.WriteTransactional)), "How changes larger than -max-tuples-per-write are applied: transactional (all-or-nothing) or parallel")
+	flag.IntVar(&cfg.MaxTuplesPerWrite, "max-tuples-per-write", engine.DefaultMaxTuplesPerWrite, "Tuples per OpenFGA write request; must not exceed the server's limit")
+	flag.IntVar(&cfg.MaxParallelWrites, "max-parallel-writes", engine.DefaultMaxParallelRequests, "Write requests sent at once with -write-mode parallel")
 	flag.StringVar(&cfg.AuthMethod, "auth-method", getEnvOrDefault("OPENFGA_AUTH_METHOD", "none"), "Authentication method (none, client_credentials, shared_secret)")
 	flag.StringVar(&cfg.ClientID, "client-id", getEnvOrDefault("OPENFGA_CLIENT_ID", ""), "OAuth2 Client ID")
 	flag.StringVar(&cfg.ClientSecret, "client-secret", getEnvOrDefault("OPENFGA_CLIENT_SECRET", ""), "OAuth2 Client Secret")
@@ -108,13 +169,19 @@ func parseFlags() *CLIConfig {
 	flag.StringVar(&cfg.Issuer, "issuer", getEnvOrDefault("OPENFGA_ISSUER", ""), "OAuth2 token issuer")
 	flag.BoolVar(&cfg.Verbose, "verbose", false, "Enable verbose output")
 	flag.BoolVar(&cfg.DryRun, "dry-run", false, "Show what would be done without making changes")
-	flag.StringVar(&cfg.UserMappings, "user-mappings", "configs/user-mappings.yaml", "User mappings file")
-	flag.StringVar(&cfg.OrgMappings, "org-mappings", "configs/organization-mappings.yaml", "Organization mappings file")
-	flag.StringVar(&cfg.OrgMemberMappings, "org-member-mappings", "configs/organization-member-mappings.yaml", "Organization member mappings file")
-	flag.StringVar(&cfg.OrgRoleMappings, "org-role-mappings", "configs/organization-role-mappings.yaml", "Organization role mappings file")
-	
+	flag.StringVar(&cfg.TuplesFile, "tuples", "", "JSON file of existing tuples to seed the dry-run store with")
+	flag.IntVar(&cfg.MaxAttempts, "max-attempts", 5, "Attempts per event for transient OpenFGA errors, with exponential backoff; negative retries indefinitely")
+	flag.StringVar(&cfg.DeadLetterFile, "dead-letter", "", "Append events that still fail to this dead-letter file")
+	flag.BoolVar(&cfg.ListDeadLetters, "list-dead-letters", false, "List the events in the -dead-letter file and exit")
+	flag.BoolVar(&cfg.ReplayDeadLetters, "replay-dead-letters", false, "Reprocess the events in the -dead-letter file, keeping only those that fail again")
+	flag.StringVar(&cfg.DedupFile, "dedup-file", "", "File of processed event IDs, so events processed by earlier runs are skipped")
+	flag.DurationVar(&cfg.DedupTTL, "dedup-ttl", 24*time.Hour, "How long processed event IDs are remembered; 0 disables deduplication")
+	flag.StringVar(&cfg.Mappings, "mappings", getEnvOrDefault("MAPPINGS_PATHS", "configs/*-mappings.yaml"), "Comma-separated mapping files, directories or globs")
+	flag.StringVar(&cfg.LogLevel, "log-level", getEnvOrDefault("LOG_LEVEL", "warn"), "Level of the logs written to stderr (debug, info, warn, error)")
+	flag.StringVar(&cfg.LogFormat, "log-format", getEnvOrDefault("LOG_FORMAT", logging.FormatText), "Format of the logs written to stderr (text, json)")
+
 	flag.Parse()
-	
+
 	return cfg
 }
 
@@ -130,27 +197,60 @@ func loadEventsFromFile(filename string) ([]map[string]interface{}, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to read file: %w", err)
 	}
-	
+
 	var events []map[string]interface{}
 	if err := json.Unmarshal(data, &events); err != nil {
 		return nil, fmt.Errorf("failed to parse JSON: %w", err)
 	}
-	
+
 	return events, nil
 }
 
 func NewEventProcessor(cfg *CLIConfig) (*EventProcessor, error) {
+	// Logs go to stderr, so they don't mix with the results on stdout
+	logger, err := logging.New(os.Stderr, cfg.LogLevel, cfg.LogFormat)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create mapping engine based on configuration
 	var mappingEngine *engine.MappingEngine
-	var err error
-	
+
 	if cfg.DryRun {
-		// For dry run, we'll create a mock engine that doesn't actually write to OpenFGA
-		mappingEngine = engine.NewMockMappingEngine(cfg.StoreID, cfg.ModelID)
+		// For dry run, we'll create a mock engine that applies changes to an in-memory store instead of OpenFGA
+		var seed []types.ProcessedTuple
+		if cfg.TuplesFile != "" {
+			seed, err = store.LoadTuplesFile(cfg.TuplesFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load tuples: %w", err)
+			}
+		}
+		mappingEngine = engine.NewMockMappingEngineWithStore(cfg.ModelID, store.NewMemoryStore(seed...))
 	} else {
-		// Create real mapping engine
-		mappingEngine = engine.NewMappingEngine(cfg.OpenFGAURL, cfg.StoreID, cfg.ModelID)
-		
+		// Create real mapping engine, pinning writes to the resolved authorization model
+		fgaClient, err := client.NewSdkClient(&client.ClientConfiguration{
+			ApiUrl:  cfg.OpenFGAURL,
+			StoreId: cfg.StoreID,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to create OpenFGA client: %w", err)
+		}
+
+		modelID, err := store.ResolveModelID(context.Background(), fgaClient, cfg.StoreID, store.ModelResolution{
+			ModelID:    cfg.ModelID,
+			ModelFile:  cfg.ModelFile,
+			WriteModel: cfg.WriteModel,
+			Logger:     logger,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve authorization model: %w", err)
+		}
+		if cfg.Verbose {
+			fmt.Printf("🔧 Resolved model ID: %s\n", modelID)
+		}
+
+		mappingEngine = engine.NewMappingEngineWithClient(fgaClient, cfg.StoreID, modelID)
+
 		// Configure authentication if needed
 		if cfg.AuthMethod != "none" {
 			err = configureMappingEngineAuth(mappingEngine, cfg)
@@ -159,39 +259,111 @@ func NewEventProcessor(cfg *CLIConfig) (*EventProcessor, error) {
 			}
 		}
 	}
-	
+
 	// Load mapping configurations
-	userConfig, err := config.LoadMappingConfig(cfg.UserMappings)
+	rawConfigs, err := config.LoadMappingConfigPaths(mappingPaths(cfg))
 	if err != nil {
-		return nil, fmt.Errorf("failed to load user mappings: %w", err)
+		return nil, fmt.Errorf("failed to load mappings: %w", err)
 	}
-	
-	orgConfig, err := config.LoadMappingConfig(cfg.OrgMappings)
+
+	mappingConfigs, err := engine.CompileAll(rawConfigs)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load organization mappings: %w", err)
+		return nil, fmt.Errorf("failed to compile mappings: %w", err)
 	}
-	
-	orgMemberConfig, err := config.LoadMappingConfig(cfg.OrgMemberMappings)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load organization member mappings: %w", err)
+	mappingEngine.SetObjectTypes(engine.ObjectTypes(mappingConfigs))
+	mappingEngine.SetLogger(logger)
+
+	writeMode := engine.WriteMode(cfg.WriteMode)
+	if writeMode != engine.WriteTransactional && writeMode != engine.WriteParallel {
+		return nil, fmt.Errorf("-write-mode must be transactional or parallel, got %q", cfg.WriteMode)
 	}
-	
-	orgRoleConfig, err := config.LoadMappingConfig(cfg.OrgRoleMappings)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load organization role mappings: %w", err)
+	if cfg.MaxTuplesPerWrite < 1 || cfg.MaxParallelWrites < 1 {
+		return nil, fmt.Errorf("-max-tuples-per-write and -max-parallel-writes must be at least 1")
+	}
+	mappingEngine.SetWriteOptions(engine.WriteOptions{
+		Mode:                writeMode,
+		MaxTuplesPerWrite:   cfg.MaxTuplesPerWrite,
+		MaxParallelRequests: cfg.MaxParallelWrites,
+	})
+
+	retryPolicy := retry.DefaultPolicy()
+	retryPolicy.MaxAttempts = cfg.MaxAttempts
+
+	var deadLetters *deadletter.File
+	if cfg.DeadLetterFile != "" {
+		deadLetters = deadletter.NewFile(cfg.DeadLetterFile)
+	}
+
+	// Skip events whose ID was already processed; a dry run does not record the IDs it processes
+	var processedEvents dedup.Store
+	if cfg.DedupTTL > 0 {
+		if cfg.DedupFile != "" && !cfg.DryRun {
+			processedEvents, err = dedup.OpenFileStore(cfg.DedupFile, cfg.DedupTTL)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open dedup file: %w", err)
+			}
+		} else {
+			processedEvents = dedup.NewMemoryStore(cfg.DedupTTL)
+		}
 	}
-	
+
 	return &EventProcessor{
 		engine:          mappingEngine,
-		userConfig:      userConfig,
-		orgConfig:       orgConfig,
-		orgMemberConfig: orgMemberConfig,
-		orgRoleConfig:   orgRoleConfig,
+		mappingConfigs:  mappingConfigs,
 		verbose:         cfg.Verbose,
 		dryRun:          cfg.DryRun,
+		retryPolicy:     retryPolicy,
+		deadLetters:     deadLetters,
+		processedEvents: processedEvents,
+		ordering:        ordering.NewTracker(24 * time.Hour),
 	}, nil
 }
 
+// mappingPaths splits the comma-separated -mappings flag
+func mappingPaths(cfg *CLIConfig) []string {
+	var paths []string
+	for _, path := range strings.Split(cfg.Mappings, ",") {
+		if path = strings.TrimSpace(path); path != "" {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// validateMappings checks the mapping files against the authorization model in the -model-file
+func validateMappings(cfg *CLIConfig) error {
+	if cfg.ModelFile == "" {
+		return fmt.Errorf("model file is required. Use -model-file flag")
+	}
+
+	authModel, err := model.Load(cfg.ModelFile)
+	if err != nil {
+		return err
+	}
+
+	rawConfigs, err := config.LoadMappingConfigPaths(mappingPaths(cfg))
+	if err != nil {
+		return fmt.Errorf("failed to load mappings: %w", err)
+	}
+
+	if _, err := engine.CompileAll(rawConfigs); err != nil {
+		return err
+	}
+
+	if err := authModel.Validate(rawConfigs); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ %d mapping file(s) match the authorization model in %s\n", len(rawConfigs), cfg.ModelFile)
+	if cfg.Verbose {
+		for _, mappingConfig := range rawConfigs {
+			fmt.Printf("   %s (%s): %d mappings\n", mappingConfig.Name, mappingConfig.Source, len(mappingConfig.Mappings))
+		}
+	}
+
+	return nil
+}
+
 func configureMappingEngineAuth(engine *engine.MappingEngine, cfg *CLIConfig) error {
 	// This would configure authentication on the engine
 	// For now, we'll assume the engine handles this internally
@@ -200,156 +372,376 @@ func configureMappingEngineAuth(engine *engine.MappingEngine, cfg *CLIConfig) er
 
 func (ep *EventProcessor) ProcessEvents(ctx context.Context, events []map[string]interface{}) []ProcessingResult {
 	results := make([]ProcessingResult, 0, len(events))
-	
+
 	for i, event := range events {
 		fmt.Printf("[%d/%d] ", i+1, len(events))
 		result := ep.processEvent(ctx, event)
 		results = append(results, result)
-		
+
+		if !result.Success && ep.deadLetters != nil {
+			ep.deadLetterResult(result)
+		}
+
 		if ep.verbose || !result.Success {
 			ep.printEventResult(result)
 		} else {
 			ep.printEventSummary(result)
 		}
-		
+
 		// Small delay to make output readable
 		time.Sleep(100 * time.Millisecond)
 	}
-	
+
 	return results
 }
 
 func (ep *EventProcessor) processEvent(ctx context.Context, event map[string]interface{}) ProcessingResult {
 	start := time.Now()
-	
+
 	eventType, ok := event["type"].(string)
 	if !ok {
+		err := fmt.Errorf("event type not found or not a string")
 		return ProcessingResult{
 			EventType: "unknown",
 			Success:   false,
-			Error:     "event type not found or not a string",
+			Error:     err.Error(),
+			Attempts:  1,
 			Duration:  time.Since(start),
+			event:     event,
+			err:       err,
 		}
 	}
-	
+
 	result := ProcessingResult{
 		EventType: eventType,
 		Duration:  time.Since(start),
+		event:     event,
 	}
-	
-	// Select appropriate mapping configuration
-	var mappingConfig *types.MappingConfig
-	switch {
-	case strings.HasPrefix(eventType, "user."):
-		mappingConfig = ep.userConfig
-	case strings.HasPrefix(eventType, "organization.") && !strings.Contains(eventType, "member"):
-		mappingConfig = ep.orgConfig
-	case strings.Contains(eventType, "organization.member.role"):
-		mappingConfig = ep.orgRoleConfig
-	case strings.Contains(eventType, "organization.member"):
-		mappingConfig = ep.orgMemberConfig
-	default:
-		result.Success = false
-		result.Error = fmt.Sprintf("no mapping configuration found for event type: %s", eventType)
+
+	// Skip events that were already processed
+	eventID, _ := event["id"].(string)
+	if eventID != "" && ep.processedEvents != nil && ep.processedEvents.Seen(eventID) {
+		result.Success = true
+		result.Duplicate = true
+		result.Duration = time.Since(start)
 		return result
 	}
-	
-	// Process the event using the engine
-	processResult, err := ep.engine.ProcessEventWithDetails(ctx, event, mappingConfig)
-	if err != nil {
-		result.Success = false
-		result.Error = err.Error()
-	} else {
+
+	// Select the mapping configurations that declare this event type; other event types are
+	// skipped, as the webhook service does, rather than failed and dead-lettered
+	mappingConfigs := engine.ConfigsForEvent(ep.mappingConfigs, eventType)
+	if len(mappingConfigs) == 0 {
 		result.Success = true
-		result.TuplesAdded = processResult.TuplesAdded
-		result.TuplesDeleted = processResult.TuplesDeleted
+		result.Unmapped = true
+		result.Duration = time.Since(start)
+		return result
 	}
-	
+
+	eventTime, hasTime := ordering.EventTime(event)
+	stale := 0
+	for _, mappingConfig := range mappingConfigs {
+		// Skip configurations for which the event is older than the latest event applied to its entity
+		var orderingKey string
+		if hasTime {
+			if entityKey, err := ep.engine.EntityKey(event, mappingConfig); err == nil {
+				orderingKey = mappingConfig.Name + "/" + entityKey
+				if ep.ordering.IsStale(orderingKey, eventTime) {
+					stale++
+					continue
+				}
+			}
+		}
+
+		// Process the event using the engine, retrying transient failures
+		var processResult *engine.ProcessEventResult
+		attempts, err := retry.Do(ctx, ep.retryPolicy, func(ctx context.Context) error {
+			var err error
+			processResult, err = ep.engine.ProcessEventWithDetails(ctx, event, mappingConfig)
+			return err
+		})
+		if attempts > result.Attempts {
+			result.Attempts = attempts
+		}
+		if err != nil {
+			result.Success = false
+			result.err = fmt.Errorf("%s: %w", mappingConfig.Name, err)
+			result.Error = result.err.Error()
+			result.Duration = time.Since(start)
+			return result
+		}
+
+		result.TuplesAdded = append(result.TuplesAdded, processResult.TuplesAdded...)
+		result.TuplesDeleted = append(result.TuplesDeleted, processResult.TuplesDeleted...)
+		for _, mappingErr := range processResult.MappingErrors {
+			result.MappingErrors = append(result.MappingErrors, mappingErr.Error())
+		}
+		result.Mappings = append(result.Mappings, processResult.Mappings...)
+		result.Chunks += len(processResult.Chunks)
+		if orderingKey != "" {
+			ep.ordering.Record(orderingKey, eventTime)
+		}
+	}
+
+	result.Success = true
+	result.Stale = stale == len(mappingConfigs)
+	if eventID != "" && ep.processedEvents != nil && !result.Stale {
+		if err := ep.processedEvents.Mark(eventID); err != nil {
+			fmt.Printf("   ⚠️ Failed to record processed event %s: %v\n", eventID, err)
+		}
+	}
+
 	result.Duration = time.Since(start)
 	return result
 }
 
+// deadLetterResult appends a failed event to the dead-letter file
+func (ep *EventProcessor) deadLetterResult(result ProcessingResult) {
+	entry, err := deadletter.NewEntry(result.event, result.Attempts, retry.IsRetryable(result.err), result.err)
+	if err == nil {
+		err = ep.deadLetters.Add(entry)
+	}
+	if err != nil {
+		fmt.Printf("   ⚠️ Failed to dead-letter event: %v\n", err)
+	}
+}
+
+// ReplayDeadLetters reprocesses dead-lettered events and rewrites the dead-letter file to
+// contain only the events that failed again; a dry run leaves the file untouched
+func (ep *EventProcessor) ReplayDeadLetters(ctx context.Context) ([]ProcessingResult, error) {
+	entries, err := ep.deadLetters.List()
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ProcessingResult, 0, len(entries))
+	var remaining []deadletter.Entry
+	for i, entry := range entries {
+		fmt.Printf("[%d/%d] ", i+1, len(entries))
+
+		var event map[string]interface{}
+		if err := json.Unmarshal(entry.Event, &event); err != nil {
+			fmt.Printf("❌ %s: unreadable event kept: %v\n", entry.EventType, err)
+			remaining = append(remaining, entry)
+			continue
+		}
+
+		result := ep.processEvent(ctx, event)
+		results = append(results, result)
+		if ep.verbose || !result.Success {
+			ep.printEventResult(result)
+		} else {
+			ep.printEventSummary(result)
+		}
+
+		if !result.Success {
+			entry.FailedAt = time.Now().UTC()
+			entry.Attempts += result.Attempts
+			entry.Retryable = retry.IsRetryable(result.err)
+			entry.Error = result.Error
+			remaining = append(remaining, entry)
+		}
+	}
+
+	// A dry run must not drop entries from the dead-letter file
+	if ep.dryRun {
+		return results, nil
+	}
+
+	if err := ep.deadLetters.Replace(remaining); err != nil {
+		return results, err
+	}
+
+	return results, nil
+}
+
 func (ep *EventProcessor) printEventSummary(result ProcessingResult) {
 	status := "✅"
 	if !result.Success {
 		status = "❌"
+	} else if result.Duplicate {
+		status = "⏭️ duplicate"
+	} else if result.Stale {
+		status = "⏭️ stale"
+	} else if result.Unmapped {
+		status = "⏭️ unmapped"
 	}
-	
+
 	fmt.Printf("%s %s (%v)\n", status, result.EventType, result.Duration)
-	
+
 	if !result.Success && result.Error != "" {
 		fmt.Printf("   Error: %s\n", result.Error)
 	}
+	if result.Attempts > 1 {
+		fmt.Printf("   Attempts: %d\n", result.Attempts)
+	}
 }
 
 func (ep *EventProcessor) printEventResult(result ProcessingResult) {
 	status := "✅ SUCCESS"
 	if !result.Success {
 		status = "❌ FAILED"
+	} else if result.Duplicate {
+		status = "⏭️ DUPLICATE"
+	} else if result.Stale {
+		status = "⏭️ STALE"
+	} else if result.Unmapped {
+		status = "⏭️ UNMAPPED"
 	}
-	
+
 	fmt.Printf("%s - %s (%v)\n", status, result.EventType, result.Duration)
-	
+
 	if result.Error != "" {
 		fmt.Printf("   Error: %s\n", result.Error)
 	}
-	
+	if result.Attempts > 1 {
+		fmt.Printf("   Attempts: %d\n", result.Attempts)
+	}
+
 	if len(result.TuplesAdded) > 0 {
 		fmt.Printf("   📝 Tuples Added:\n")
 		for _, tuple := range result.TuplesAdded {
 			fmt.Printf("      + %s %s %s\n", tuple.User, tuple.Relation, tuple.Object)
 		}
 	}
-	
+
 	if len(result.TuplesDeleted) > 0 {
 		fmt.Printf("   🗑️ Tuples Deleted:\n")
 		for _, tuple := range result.TuplesDeleted {
 			fmt.Printf("      - %s %s %s\n", tuple.User, tuple.Relation, tuple.Object)
 		}
 	}
-	
+
+	if len(result.MappingErrors) > 0 {
+		fmt.Printf("   ⚠️ Mappings Skipped on Error:\n")
+		for _, mappingErr := range result.MappingErrors {
+			fmt.Printf("      ! %s\n", mappingErr)
+		}
+	}
+
+	if ep.verbose && result.Chunks > 1 {
+		fmt.Printf("   📦 Written in %d requests\n", result.Chunks)
+	}
+
+	if ep.verbose && len(result.Mappings) > 0 {
+		fmt.Printf("   🔍 Mapping Outcomes:\n")
+		for _, outcome := range result.Mappings {
+			fmt.Printf("      %-7s %s", outcome.Status, outcome.Mapping)
+			if outcome.Tuple != nil {
+				fmt.Printf(" → %s %s %s", outcome.Tuple.User, outcome.Tuple.Relation, outcome.Tuple.Object)
+			}
+			fmt.Println()
+		}
+	}
+
 	fmt.Println()
 }
 
+// listDeadLetters prints the events in the dead-letter file
+func listDeadLetters(cfg *CLIConfig) error {
+	if cfg.DeadLetterFile == "" {
+		return fmt.Errorf("dead-letter file is required. Use -dead-letter flag")
+	}
+
+	entries, err := deadletter.NewFile(cfg.DeadLetterFile).List()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("📬 Dead-lettered events in %s: %d\n", cfg.DeadLetterFile, len(entries))
+	for i, entry := range entries {
+		kind := "permanent"
+		if entry.Retryable {
+			kind = "retryable"
+		}
+		fmt.Printf("\n[%d] %s %s\n", i+1, entry.EventType, entry.EventID)
+		fmt.Printf("   Failed at: %s after %d attempt(s)\n", entry.FailedAt.Format(time.RFC3339), entry.Attempts)
+		fmt.Printf("   Error (%s): %s\n", kind, entry.Error)
+		if cfg.Verbose {
+			fmt.Printf("   Event: %s\n", entry.Event)
+		}
+	}
+
+	return nil
+}
+
+// replayDeadLetters reprocesses the events in the dead-letter file
+func replayDeadLetters(cfg *CLIConfig) error {
+	if cfg.DeadLetterFile == "" {
+		return fmt.Errorf("dead-letter file is required. Use -dead-letter flag")
+	}
+
+	fmt.Printf("🔁 Replaying dead-lettered events from %s\n", cfg.DeadLetterFile)
+	if cfg.DryRun {
+		fmt.Printf("🔍 DRY RUN MODE - No changes will be made\n")
+	}
+	fmt.Printf("\n")
+
+	processor, err := NewEventProcessor(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to create event processor: %w", err)
+	}
+
+	results, err := processor.ReplayDeadLetters(context.Background())
+	if len(results) > 0 {
+		printSummary(results)
+	}
+	return err
+}
+
 func printSummary(results []ProcessingResult) {
 	fmt.Printf("\n📊 Processing Summary\n")
 	fmt.Printf("====================\n")
-	
+
 	successful := 0
 	failed := 0
+	duplicates := 0
+	stale := 0
+	unmapped := 0
 	totalTuplesAdded := 0
 	totalTuplesDeleted := 0
+	totalMappingErrors := 0
 	totalDuration := time.Duration(0)
-	
+
 	eventTypeCounts := make(map[string]int)
-	
+
 	for _, result := range results {
-		if result.Success {
+		if result.Duplicate {
+			duplicates++
+		} else if result.Stale {
+			stale++
+		} else if result.Unmapped {
+			unmapped++
+		} else if result.Success {
 			successful++
 		} else {
 			failed++
 		}
-		
+
 		totalTuplesAdded += len(result.TuplesAdded)
 		totalTuplesDeleted += len(result.TuplesDeleted)
+		totalMappingErrors += len(result.MappingErrors)
 		totalDuration += result.Duration
-		
+
 		eventTypeCounts[result.EventType]++
 	}
-	
+
 	fmt.Printf("�� Total Events: %d\n", len(results))
 	fmt.Printf("✅ Successful: %d\n", successful)
 	fmt.Printf("❌ Failed: %d\n", failed)
+	fmt.Printf("⏭️ Duplicates Skipped: %d\n", duplicates)
+	fmt.Printf("⏭️ Stale Events Skipped: %d\n", stale)
+	fmt.Printf("⏭️ Unmapped Events Skipped: %d\n", unmapped)
 	fmt.Printf("📝 Total Tuples Added: %d\n", totalTuplesAdded)
 	fmt.Printf("🗑️ Total Tuples Deleted: %d\n", totalTuplesDeleted)
+	fmt.Printf("⚠️ Mappings Skipped on Error: %d\n", totalMappingErrors)
 	fmt.Printf("⏱️ Total Duration: %v\n", totalDuration)
 	fmt.Printf("📊 Average Duration: %v\n", totalDuration/time.Duration(len(results)))
-	
+
 	fmt.Printf("\n📋 Event Types Processed:\n")
 	for eventType, count := range eventTypeCounts {
 		fmt.Printf("   %s: %d events\n", eventType, count)
 	}
-	
+
 	if failed > 0 {
 		fmt.Printf("\n❌ Failed Events:\n")
 		for _, result := range results {
@@ -358,6 +750,6 @@ func printSummary(results []ProcessingResult) {
 			}
 		}
 	}
-	
+
 	fmt.Printf("\n🎉 Processing completed!\n")
 }
@@ -78,10 +78,15 @@ func main() {
 		log.Fatal(err)
 	}
 
+	compiledConfig, err := engine.Compile(mappingConfig)
+	if err != nil {
+		log.Fatal(err)
+	}
+
 	mappingEngine := engine.NewMappingEngine("http://localhost:8080", "store-id", "model-id")
 
 	ctx := context.Background()
-	err := mappingEngine.ProcessEvent(ctx, event, mappingConfig)
+	err = mappingEngine.ProcessEvent(ctx, event, compiledConfig)
 	if err != nil {
 		log.Printf("Error processing event: %v", err)
 	} else {
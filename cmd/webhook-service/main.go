@@ -2,33 +2,69 @@ package main
 
 import (
 	"context"
+	"errors"
+	"flag"
 	"log"
+	"log/slog"
+	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
 	"mapping-engine/internal/config"
+	"mapping-engine/internal/logging"
 	"mapping-engine/internal/service"
+	"mapping-engine/internal/tracing"
 )
 
 func main() {
+	configFile := flag.String("config", "", "Path to the service YAML configuration file (defaults to $CONFIG_FILE)")
+	flag.Parse()
+
 	// Load configuration
-	cfg, err := config.LoadServiceConfig()
+	cfg, err := config.LoadServiceConfig(*configFile)
 	if err != nil {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Log to stderr; the standard library logger, used by dependencies, writes through the same handler
+	logger, err := logging.New(os.Stderr, cfg.Logging.Level, cfg.Logging.Format)
+	if err != nil {
+		log.Fatalf("Failed to set up logging: %v", err)
+	}
+	slog.SetDefault(logger)
+
+	// Export traces of webhook events
+	shutdownTracing, err := tracing.Setup(context.Background(), tracing.Options{
+		Exporter:    cfg.Tracing.Exporter,
+		Endpoint:    cfg.Tracing.Endpoint,
+		ServiceName: cfg.Tracing.ServiceName,
+		SampleRatio: cfg.Tracing.SampleRatio,
+	})
+	if err != nil {
+		fatal(logger, "Failed to set up tracing", err)
+	}
+
 	// Create and start the webhook service
-	svc, err := service.NewWebhookService(cfg)
+	svc, err := service.NewWebhookService(cfg, logger)
 	if err != nil {
-		log.Fatalf("Failed to create webhook service: %v", err)
+		fatal(logger, "Failed to create webhook service", err)
 	}
 
 	// Start the service in a goroutine
 	go func() {
-		if err := svc.Start(); err != nil {
-			log.Fatalf("Failed to start webhook service: %v", err)
+		if err := svc.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			fatal(logger, "Failed to start webhook service", err)
+		}
+	}()
+
+	// Reload the mapping files on SIGHUP; a rejected reload is logged and the active mappings are kept
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			svc.ReloadMappings(context.Background(), service.TriggerSignal)
 		}
 	}()
 
@@ -36,16 +72,26 @@ func main() {
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
 	<-quit
-
-	log.Println("Shutting down webhook service...")
+	signal.Stop(reload)
 
 	// Give the service 30 seconds to shutdown gracefully
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
 	if err := svc.Shutdown(ctx); err != nil {
-		log.Fatalf("Failed to shutdown webhook service: %v", err)
+		fatal(logger, "Failed to shutdown webhook service", err)
 	}
 
-	log.Println("Webhook service stopped")
+	// Flush the spans of the last events
+	if err := shutdownTracing(ctx); err != nil {
+		logger.Error("Failed to flush traces", "error", err)
+	}
+
+	logger.Info("Webhook service stopped")
+}
+
+// fatal logs an error and exits
+func fatal(logger *slog.Logger, message string, err error) {
+	logger.Error(message, "error", err)
+	os.Exit(1)
 }
@@ -20,11 +20,17 @@ func main() {
 		"configs/organization-role-mappings.yaml",
 	}
 
-	configs, err := config.LoadMappingConfigs(configPaths)
+	rawConfigs, err := config.LoadMappingConfigs(configPaths)
 	if err != nil {
 		log.Fatalf("Failed to load configurations: %v", err)
 	}
 
+	// Compile conditions and templates once, before any event is processed
+	configs, err := engine.CompileAll(rawConfigs)
+	if err != nil {
+		log.Fatalf("Failed to compile configurations: %v", err)
+	}
+
 	// Create multi-config processor
 	processor := engine.NewMultiConfigProcessor(
 		"http://localhost:8080", // OpenFGA API URL
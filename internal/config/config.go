@@ -1,18 +1,32 @@
 package config
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"gopkg.in/yaml.v3"
 )
 
 // ServiceConfig holds the configuration for the webhook service
 type ServiceConfig struct {
-	Server   ServerConfig   `yaml:"server"`
-	OpenFGA  OpenFGAConfig  `yaml:"openfga"`
-	Auth0    Auth0Config    `yaml:"auth0"`
-	Mappings MappingsConfig `yaml:"mappings"`
+	Server     ServerConfig     `yaml:"server"`
+	OpenFGA    OpenFGAConfig    `yaml:"openfga"`
+	Auth0      Auth0Config      `yaml:"auth0"`
+	Mappings   MappingsConfig   `yaml:"mappings"`
+	Queue      QueueConfig      `yaml:"queue"`
+	Retry      RetryConfig      `yaml:"retry"`
+	DeadLetter DeadLetterConfig `yaml:"dead_letter"`
+	Dedup      DedupConfig      `yaml:"dedup"`
+	Ordering   OrderingConfig   `yaml:"ordering"`
+	Tracing    TracingConfig    `yaml:"tracing"`
+	Logging    LoggingConfig    `yaml:"logging"`
+	Admin      AdminConfig      `yaml:"admin"`
 }
 
 // ServerConfig holds HTTP server configuration
@@ -26,35 +40,91 @@ type ServerConfig struct {
 
 // OpenFGAConfig holds OpenFGA connection configuration
 type OpenFGAConfig struct {
-	APIUrl      string `yaml:"api_url" env:"OPENFGA_API_URL" envDefault:"http://localhost:8080"`
-	StoreID     string `yaml:"store_id" env:"OPENFGA_STORE_ID"`
-	ModelFile   string `yaml:"model_file" env:"OPENFGA_MODEL_FILE" envDefault:"configs/model.json"`
-	AuthMethod  string `yaml:"auth_method" env:"OPENFGA_AUTH_METHOD" envDefault:"none"` // none, client_credentials, shared_secret
-	ClientID    string `yaml:"client_id" env:"OPENFGA_CLIENT_ID"`
-	ClientSecret string `yaml:"client_secret" env:"OPENFGA_CLIENT_SECRET"`
-	SharedSecret string `yaml:"shared_secret" env:"OPENFGA_SHARED_SECRET"`
-	Audience    string `yaml:"audience" env:"OPENFGA_AUDIENCE"`
-	Issuer      string `yaml:"issuer" env:"OPENFGA_ISSUER"`
+	APIUrl            string `yaml:"api_url" env:"OPENFGA_API_URL" envDefault:"http://localhost:8080"`
+	StoreID           string `yaml:"store_id" env:"OPENFGA_STORE_ID"`
+	ModelFile         string `yaml:"model_file" env:"OPENFGA_MODEL_FILE" envDefault:"configs/model.json"`
+	ModelID           string `yaml:"model_id" env:"OPENFGA_MODEL_ID" envDefault:"latest"`                      // Model writes are pinned to; "latest" resolves the newest model matching model_file at startup
+	WriteModel        bool   `yaml:"write_model" env:"OPENFGA_WRITE_MODEL"`                                    // Write model_file to the store when no model matches it
+	WriteMode         string `yaml:"write_mode" env:"OPENFGA_WRITE_MODE" envDefault:"transactional"`           // How changes larger than max_tuples_per_write are applied: transactional or parallel
+	MaxTuplesPerWrite int    `yaml:"max_tuples_per_write" env:"OPENFGA_MAX_TUPLES_PER_WRITE" envDefault:"100"` // Must not exceed the server's OPENFGA_MAX_TUPLES_PER_WRITE
+	MaxParallelWrites int    `yaml:"max_parallel_writes" env:"OPENFGA_MAX_PARALLEL_WRITES" envDefault:"10"`    // Chunks written at once in parallel mode
+	AuthMethod        string `yaml:"auth_method" env:"OPENFGA_AUTH_METHOD" envDefault:"none"`                  // none, client_credentials, shared_secret
+	ClientID          string `yaml:"client_id" env:"OPENFGA_CLIENT_ID"`
+	ClientSecret      string `yaml:"client_secret" env:"OPENFGA_CLIENT_SECRET"`
+	SharedSecret      string `yaml:"shared_secret" env:"OPENFGA_SHARED_SECRET"`
+	Audience          string `yaml:"audience" env:"OPENFGA_AUDIENCE"`
+	Issuer            string `yaml:"issuer" env:"OPENFGA_ISSUER"`
 }
 
 // Auth0Config holds Auth0 webhook configuration
 type Auth0Config struct {
-	WebhookSecret string `yaml:"webhook_secret" env:"AUTH0_WEBHOOK_SECRET"`
-	VerifySignature bool  `yaml:"verify_signature" env:"AUTH0_VERIFY_SIGNATURE" envDefault:"true"`
+	WebhookSecret   string `yaml:"webhook_secret" env:"AUTH0_WEBHOOK_SECRET"`
+	VerifySignature bool   `yaml:"verify_signature" env:"AUTH0_VERIFY_SIGNATURE" envDefault:"true"`
 }
 
-// MappingsConfig holds the mapping configuration files
+// MappingsConfig holds where the mapping configuration files are loaded from.
+// Events are routed to every file whose events list declares their type.
 type MappingsConfig struct {
-	UserMappings       string `yaml:"user_mappings" env:"USER_MAPPINGS_FILE" envDefault:"configs/user-mappings.yaml"`
-	OrgMappings        string `yaml:"org_mappings" env:"ORG_MAPPINGS_FILE" envDefault:"configs/organization-mappings.yaml"`
-	OrgMemberMappings  string `yaml:"org_member_mappings" env:"ORG_MEMBER_MAPPINGS_FILE" envDefault:"configs/organization-member-mappings.yaml"`
-	OrgRoleMappings    string `yaml:"org_role_mappings" env:"ORG_ROLE_MAPPINGS_FILE" envDefault:"configs/organization-role-mappings.yaml"`
+	Paths         []string      `yaml:"paths" env:"MAPPINGS_PATHS" envDefault:"configs/*-mappings.yaml"` // Files, directories or globs; comma-separated in the environment
+	WatchInterval time.Duration `yaml:"watch_interval" env:"MAPPINGS_WATCH_INTERVAL"`                    // How often the files are checked for changes to reload; 0 disables watching
+}
+
+// QueueConfig holds the durable event queue configuration
+type QueueConfig struct {
+	Dir     string `yaml:"dir" env:"QUEUE_DIR" envDefault:"data/queue"` // Empty processes events synchronously
+	Workers int    `yaml:"workers" env:"QUEUE_WORKERS" envDefault:"4"`
+}
+
+// RetryConfig holds the retry policy for events that fail with transient errors
+type RetryConfig struct {
+	MaxAttempts     int           `yaml:"max_attempts" env:"RETRY_MAX_ATTEMPTS" envDefault:"10"` // Negative retries indefinitely
+	InitialInterval time.Duration `yaml:"initial_interval" env:"RETRY_INITIAL_INTERVAL" envDefault:"500ms"`
+	MaxInterval     time.Duration `yaml:"max_interval" env:"RETRY_MAX_INTERVAL" envDefault:"30s"`
+	Multiplier      float64       `yaml:"multiplier" env:"RETRY_MULTIPLIER" envDefault:"2"`
+	Jitter          float64       `yaml:"jitter" env:"RETRY_JITTER" envDefault:"0.2"`
+}
+
+// DedupConfig holds how long processed event IDs are remembered to skip redelivered events
+type DedupConfig struct {
+	File string        `yaml:"file" env:"DEDUP_FILE" envDefault:"data/dedup.jsonl"` // Empty keeps event IDs in memory only
+	TTL  time.Duration `yaml:"ttl" env:"DEDUP_TTL" envDefault:"24h"`                // 0 disables deduplication
+}
+
+// OrderingConfig holds how long the latest event time of each entity is remembered to drop late, older events
+type OrderingConfig struct {
+	TTL time.Duration `yaml:"ttl" env:"ORDERING_TTL" envDefault:"24h"` // 0 disables event ordering
+}
+
+// TracingConfig holds where OpenTelemetry traces of webhook events are exported
+type TracingConfig struct {
+	Exporter    string  `yaml:"exporter" env:"TRACING_EXPORTER" envDefault:"none"`                   // none, otlp or stdout
+	Endpoint    string  `yaml:"endpoint" env:"TRACING_ENDPOINT"`                                     // OTLP/HTTP endpoint URL; empty uses OTEL_EXPORTER_OTLP_ENDPOINT
+	ServiceName string  `yaml:"service_name" env:"TRACING_SERVICE_NAME" envDefault:"mapping-engine"` // service.name of the exported spans
+	SampleRatio float64 `yaml:"sample_ratio" env:"TRACING_SAMPLE_RATIO" envDefault:"1"`              // Fraction of traces started by the service that are recorded
+}
+
+// LoggingConfig holds the level and format of the service's logs, written to stderr
+type LoggingConfig struct {
+	Level  string `yaml:"level" env:"LOG_LEVEL" envDefault:"info"`   // debug, info, warn or error
+	Format string `yaml:"format" env:"LOG_FORMAT" envDefault:"json"` // json or text
+}
+
+// AdminConfig holds the authentication of the admin endpoints, such as /admin/reload-mappings
+type AdminConfig struct {
+	Token string `yaml:"token" env:"ADMIN_TOKEN"` // Bearer token required by the admin endpoints; empty disables them
+}
+
+// DeadLetterConfig holds where events that still fail after retries are stored
+type DeadLetterConfig struct {
+	File string `yaml:"file" env:"DEAD_LETTER_FILE" envDefault:"data/dead-letter.jsonl"` // Empty disables dead-lettering
 }
 
-// LoadServiceConfig loads the service configuration from environment variables and config file
-func LoadServiceConfig() (*ServiceConfig, error) {
+// LoadServiceConfig loads the service configuration. Defaults are overridden by the YAML file at
+// configFile, or at CONFIG_FILE when configFile is empty, and then by environment variables.
+// Unknown keys in the file and invalid values are reported as errors.
+func LoadServiceConfig(configFile string) (*ServiceConfig, error) {
 	cfg := &ServiceConfig{}
-	
+
 	// Set defaults
 	cfg.Server = ServerConfig{
 		Port:         8080,
@@ -63,94 +133,299 @@ func LoadServiceConfig() (*ServiceConfig, error) {
 		WriteTimeout: 10 * time.Second,
 		IdleTimeout:  120 * time.Second,
 	}
-	
+
 	cfg.OpenFGA = OpenFGAConfig{
 		APIUrl:     "http://localhost:8080",
 		ModelFile:  "configs/model.json",
+		ModelID:    "latest",
 		AuthMethod: "none",
+
+		WriteMode:         "transactional",
+		MaxTuplesPerWrite: 100,
+		MaxParallelWrites: 10,
 	}
-	
+
 	cfg.Auth0 = Auth0Config{
 		VerifySignature: true,
 	}
-	
+
 	cfg.Mappings = MappingsConfig{
-		UserMappings:      "configs/user-mappings.yaml",
-		OrgMappings:       "configs/organization-mappings.yaml",
-		OrgMemberMappings: "configs/organization-member-mappings.yaml",
-		OrgRoleMappings:   "configs/organization-role-mappings.yaml",
+		Paths: []string{"configs/*-mappings.yaml"},
+	}
+
+	cfg.Queue = QueueConfig{
+		Dir:     "data/queue",
+		Workers: 4,
+	}
+
+	cfg.Retry = RetryConfig{
+		MaxAttempts:     10,
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     30 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+	}
+
+	cfg.DeadLetter = DeadLetterConfig{
+		File: "data/dead-letter.jsonl",
 	}
-	
+
+	cfg.Dedup = DedupConfig{
+		File: "data/dedup.jsonl",
+		TTL:  24 * time.Hour,
+	}
+
+	cfg.Ordering = OrderingConfig{
+		TTL: 24 * time.Hour,
+	}
+
+	cfg.Tracing = TracingConfig{
+		Exporter:    "none",
+		ServiceName: "mapping-engine",
+		SampleRatio: 1,
+	}
+
+	cfg.Logging = LoggingConfig{
+		Level:  "info",
+		Format: "json",
+	}
+
+	// Load from the config file
+	if configFile == "" {
+		configFile = os.Getenv("CONFIG_FILE")
+	}
+	if configFile != "" {
+		if err := loadFromFile(cfg, configFile); err != nil {
+			return nil, err
+		}
+	}
+
 	// Load from environment variables
 	if err := loadFromEnv(cfg); err != nil {
 		return nil, fmt.Errorf("failed to load from environment: %w", err)
 	}
-	
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+
 	return cfg, nil
 }
 
+// loadFromFile loads configuration from a YAML file, rejecting keys that don't match a field
+func loadFromFile(cfg *ServiceConfig, configFile string) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && err != io.EOF {
+		return fmt.Errorf("failed to parse config file %s: %w", configFile, err)
+	}
+
+	return nil
+}
+
 // loadFromEnv loads configuration from environment variables
 func loadFromEnv(cfg *ServiceConfig) error {
+	env := &envLoader{}
+
 	// Server config
-	if port := os.Getenv("PORT"); port != "" {
-		if p, err := strconv.Atoi(port); err == nil {
-			cfg.Server.Port = p
-		}
-	}
-	if host := os.Getenv("HOST"); host != "" {
-		cfg.Server.Host = host
-	}
-	
+	env.int("PORT", &cfg.Server.Port)
+	env.string("HOST", &cfg.Server.Host)
+	env.duration("READ_TIMEOUT", &cfg.Server.ReadTimeout)
+	env.duration("WRITE_TIMEOUT", &cfg.Server.WriteTimeout)
+	env.duration("IDLE_TIMEOUT", &cfg.Server.IdleTimeout)
+
 	// OpenFGA config
-	if apiUrl := os.Getenv("OPENFGA_API_URL"); apiUrl != "" {
-		cfg.OpenFGA.APIUrl = apiUrl
-	}
-	if storeID := os.Getenv("OPENFGA_STORE_ID"); storeID != "" {
-		cfg.OpenFGA.StoreID = storeID
-	}
-	if modelFile := os.Getenv("OPENFGA_MODEL_FILE"); modelFile != "" {
-		cfg.OpenFGA.ModelFile = modelFile
-	}
-	if authMethod := os.Getenv("OPENFGA_AUTH_METHOD"); authMethod != "" {
-		cfg.OpenFGA.AuthMethod = authMethod
-	}
-	if clientID := os.Getenv("OPENFGA_CLIENT_ID"); clientID != "" {
-		cfg.OpenFGA.ClientID = clientID
+	env.string("OPENFGA_API_URL", &cfg.OpenFGA.APIUrl)
+	env.string("OPENFGA_STORE_ID", &cfg.OpenFGA.StoreID)
+	env.string("OPENFGA_MODEL_FILE", &cfg.OpenFGA.ModelFile)
+	env.string("OPENFGA_MODEL_ID", &cfg.OpenFGA.ModelID)
+	env.bool("OPENFGA_WRITE_MODEL", &cfg.OpenFGA.WriteModel)
+	env.string("OPENFGA_WRITE_MODE", &cfg.OpenFGA.WriteMode)
+	env.int("OPENFGA_MAX_TUPLES_PER_WRITE", &cfg.OpenFGA.MaxTuplesPerWrite)
+	env.int("OPENFGA_MAX_PARALLEL_WRITES", &cfg.OpenFGA.MaxParallelWrites)
+	env.string("OPENFGA_AUTH_METHOD", &cfg.OpenFGA.AuthMethod)
+	env.string("OPENFGA_CLIENT_ID", &cfg.OpenFGA.ClientID)
+	env.string("OPENFGA_CLIENT_SECRET", &cfg.OpenFGA.ClientSecret)
+	env.string("OPENFGA_SHARED_SECRET", &cfg.OpenFGA.SharedSecret)
+	env.string("OPENFGA_AUDIENCE", &cfg.OpenFGA.Audience)
+	env.string("OPENFGA_ISSUER", &cfg.OpenFGA.Issuer)
+
+	// Auth0 config
+	env.string("AUTH0_WEBHOOK_SECRET", &cfg.Auth0.WebhookSecret)
+	env.bool("AUTH0_VERIFY_SIGNATURE", &cfg.Auth0.VerifySignature)
+
+	// Mappings config
+	env.list("MAPPINGS_PATHS", &cfg.Mappings.Paths)
+	env.duration("MAPPINGS_WATCH_INTERVAL", &cfg.Mappings.WatchInterval)
+
+	// Queue config
+	env.optionalString("QUEUE_DIR", &cfg.Queue.Dir)
+	env.int("QUEUE_WORKERS", &cfg.Queue.Workers)
+
+	// Retry config
+	env.int("RETRY_MAX_ATTEMPTS", &cfg.Retry.MaxAttempts)
+	env.duration("RETRY_INITIAL_INTERVAL", &cfg.Retry.InitialInterval)
+	env.duration("RETRY_MAX_INTERVAL", &cfg.Retry.MaxInterval)
+	env.float("RETRY_MULTIPLIER", &cfg.Retry.Multiplier)
+	env.float("RETRY_JITTER", &cfg.Retry.Jitter)
+
+	// Dead-letter config
+	env.optionalString("DEAD_LETTER_FILE", &cfg.DeadLetter.File)
+
+	// Dedup config
+	env.optionalString("DEDUP_FILE", &cfg.Dedup.File)
+	env.duration("DEDUP_TTL", &cfg.Dedup.TTL)
+
+	// Ordering config
+	env.duration("ORDERING_TTL", &cfg.Ordering.TTL)
+
+	// Tracing config
+	env.string("TRACING_EXPORTER", &cfg.Tracing.Exporter)
+	env.string("TRACING_ENDPOINT", &cfg.Tracing.Endpoint)
+	env.string("TRACING_SERVICE_NAME", &cfg.Tracing.ServiceName)
+	env.float("TRACING_SAMPLE_RATIO", &cfg.Tracing.SampleRatio)
+
+	// Logging config
+	env.string("LOG_LEVEL", &cfg.Logging.Level)
+	env.string("LOG_FORMAT", &cfg.Logging.Format)
+
+	// Admin config
+	env.string("ADMIN_TOKEN", &cfg.Admin.Token)
+
+	return errors.Join(env.errs...)
+}
+
+// envLoader overrides configuration fields with environment variables, collecting invalid values
+type envLoader struct {
+	errs []error
+}
+
+// string overrides dst with a non-empty variable
+func (l *envLoader) string(key string, dst *string) {
+	if value := os.Getenv(key); value != "" {
+		*dst = value
 	}
-	if clientSecret := os.Getenv("OPENFGA_CLIENT_SECRET"); clientSecret != "" {
-		cfg.OpenFGA.ClientSecret = clientSecret
+}
+
+// optionalString overrides dst with a set variable, even an empty one
+func (l *envLoader) optionalString(key string, dst *string) {
+	if value, ok := os.LookupEnv(key); ok {
+		*dst = value
 	}
-	if sharedSecret := os.Getenv("OPENFGA_SHARED_SECRET"); sharedSecret != "" {
-		cfg.OpenFGA.SharedSecret = sharedSecret
+}
+
+// list overrides dst with the comma-separated values of a non-empty variable
+func (l *envLoader) list(key string, dst *[]string) {
+	if value := os.Getenv(key); value != "" {
+		var values []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				values = append(values, item)
+			}
+		}
+		*dst = values
 	}
-	if audience := os.Getenv("OPENFGA_AUDIENCE"); audience != "" {
-		cfg.OpenFGA.Audience = audience
+}
+
+func (l *envLoader) int(key string, dst *int) {
+	if value := os.Getenv(key); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %q is not an integer", key, value))
+			return
+		}
+		*dst = n
 	}
-	if issuer := os.Getenv("OPENFGA_ISSUER"); issuer != "" {
-		cfg.OpenFGA.Issuer = issuer
+}
+
+func (l *envLoader) float(key string, dst *float64) {
+	if value := os.Getenv(key); value != "" {
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %q is not a number", key, value))
+			return
+		}
+		*dst = f
 	}
-	
-	// Auth0 config
-	if webhookSecret := os.Getenv("AUTH0_WEBHOOK_SECRET"); webhookSecret != "" {
-		cfg.Auth0.WebhookSecret = webhookSecret
+}
+
+func (l *envLoader) bool(key string, dst *bool) {
+	if value := os.Getenv(key); value != "" {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %q is not a boolean", key, value))
+			return
+		}
+		*dst = b
 	}
-	if verifySignature := os.Getenv("AUTH0_VERIFY_SIGNATURE"); verifySignature != "" {
-		cfg.Auth0.VerifySignature = verifySignature != "false"
+}
+
+func (l *envLoader) duration(key string, dst *time.Duration) {
+	if value := os.Getenv(key); value != "" {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			l.errs = append(l.errs, fmt.Errorf("%s: %q is not a duration such as \"30s\" or \"5m\"", key, value))
+			return
+		}
+		*dst = d
 	}
-	
-	// Mappings config
-	if userMappings := os.Getenv("USER_MAPPINGS_FILE"); userMappings != "" {
-		cfg.Mappings.UserMappings = userMappings
+}
+
+// Validate checks that the configuration values are within their allowed ranges
+func (cfg *ServiceConfig) Validate() error {
+	var errs []error
+	check := func(ok bool, format string, args ...interface{}) {
+		if !ok {
+			errs = append(errs, fmt.Errorf(format, args...))
+		}
 	}
-	if orgMappings := os.Getenv("ORG_MAPPINGS_FILE"); orgMappings != "" {
-		cfg.Mappings.OrgMappings = orgMappings
+
+	check(cfg.Server.Port >= 1 && cfg.Server.Port <= 65535, "server.port must be between 1 and 65535, got %d", cfg.Server.Port)
+	check(cfg.Server.ReadTimeout >= 0, "server.read_timeout must not be negative, got %v", cfg.Server.ReadTimeout)
+	check(cfg.Server.WriteTimeout >= 0, "server.write_timeout must not be negative, got %v", cfg.Server.WriteTimeout)
+	check(cfg.Server.IdleTimeout >= 0, "server.idle_timeout must not be negative, got %v", cfg.Server.IdleTimeout)
+
+	switch cfg.OpenFGA.AuthMethod {
+	case "none", "client_credentials", "shared_secret":
+	default:
+		check(false, "openfga.auth_method must be one of none, client_credentials, shared_secret, got %q", cfg.OpenFGA.AuthMethod)
 	}
-	if orgMemberMappings := os.Getenv("ORG_MEMBER_MAPPINGS_FILE"); orgMemberMappings != "" {
-		cfg.Mappings.OrgMemberMappings = orgMemberMappings
+	check(cfg.OpenFGA.WriteMode == "transactional" || cfg.OpenFGA.WriteMode == "parallel", "openfga.write_mode must be transactional or parallel, got %q", cfg.OpenFGA.WriteMode)
+	check(cfg.OpenFGA.MaxTuplesPerWrite >= 1, "openfga.max_tuples_per_write must be at least 1, got %d", cfg.OpenFGA.MaxTuplesPerWrite)
+	check(cfg.OpenFGA.MaxParallelWrites >= 1, "openfga.max_parallel_writes must be at least 1, got %d", cfg.OpenFGA.MaxParallelWrites)
+
+	check(len(cfg.Mappings.Paths) > 0, "mappings.paths must list at least one file, directory or glob")
+	check(cfg.Mappings.WatchInterval >= 0, "mappings.watch_interval must not be negative, got %v", cfg.Mappings.WatchInterval)
+
+	check(cfg.Queue.Workers >= 1, "queue.workers must be at least 1, got %d", cfg.Queue.Workers)
+
+	check(cfg.Retry.MaxAttempts != 0, "retry.max_attempts must be positive, or negative to retry indefinitely, got 0")
+	check(cfg.Retry.InitialInterval >= 0, "retry.initial_interval must not be negative, got %v", cfg.Retry.InitialInterval)
+	check(cfg.Retry.MaxInterval >= 0, "retry.max_interval must not be negative, got %v", cfg.Retry.MaxInterval)
+	check(cfg.Retry.Multiplier >= 1, "retry.multiplier must be at least 1, got %v", cfg.Retry.Multiplier)
+	check(cfg.Retry.Jitter >= 0 && cfg.Retry.Jitter <= 1, "retry.jitter must be between 0 and 1, got %v", cfg.Retry.Jitter)
+
+	check(cfg.Dedup.TTL >= 0, "dedup.ttl must not be negative, got %v", cfg.Dedup.TTL)
+	check(cfg.Ordering.TTL >= 0, "ordering.ttl must not be negative, got %v", cfg.Ordering.TTL)
+
+	switch cfg.Tracing.Exporter {
+	case "none", "otlp", "stdout":
+	default:
+		check(false, "tracing.exporter must be one of none, otlp, stdout, got %q", cfg.Tracing.Exporter)
 	}
-	if orgRoleMappings := os.Getenv("ORG_ROLE_MAPPINGS_FILE"); orgRoleMappings != "" {
-		cfg.Mappings.OrgRoleMappings = orgRoleMappings
+	check(cfg.Tracing.SampleRatio >= 0 && cfg.Tracing.SampleRatio <= 1, "tracing.sample_ratio must be between 0 and 1, got %v", cfg.Tracing.SampleRatio)
+
+	switch cfg.Logging.Level {
+	case "debug", "info", "warn", "error":
+	default:
+		check(false, "logging.level must be one of debug, info, warn, error, got %q", cfg.Logging.Level)
 	}
-	
-	return nil
-}
\ No newline at end of file
+	check(cfg.Logging.Format == "json" || cfg.Logging.Format == "text", "logging.format must be json or text, got %q", cfg.Logging.Format)
+
+	return errors.Join(errs...)
+}
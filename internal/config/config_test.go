@@ -0,0 +1,273 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "service.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadServiceConfig_ShippedFile(t *testing.T) {
+	cfg, err := LoadServiceConfig("../../configs/service.yaml")
+	require.NoError(t, err)
+
+	assert.Equal(t, 8080, cfg.Server.Port)
+	assert.Equal(t, 120*time.Second, cfg.Server.IdleTimeout)
+	assert.Equal(t, "data/queue", cfg.Queue.Dir)
+	assert.Equal(t, 500*time.Millisecond, cfg.Retry.InitialInterval)
+}
+
+func TestLoadServiceConfig_EnvOverridesFile(t *testing.T) {
+	path := writeConfigFile(t, `
+server:
+  port: 9000
+  read_timeout: "3s"
+openfga:
+  store_id: "from-file"
+queue:
+  dir: "/var/lib/queue"
+`)
+	t.Setenv("OPENFGA_STORE_ID", "from-env")
+	t.Setenv("OPENFGA_WRITE_MODEL", "true")
+	t.Setenv("OPENFGA_WRITE_MODE", "parallel")
+	t.Setenv("QUEUE_DIR", "")
+	t.Setenv("TRACING_EXPORTER", "otlp")
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("MAPPINGS_WATCH_INTERVAL", "30s")
+	t.Setenv("ADMIN_TOKEN", "secret")
+
+	cfg, err := LoadServiceConfig(path)
+	require.NoError(t, err)
+
+	assert.Equal(t, 9000, cfg.Server.Port)
+	assert.Equal(t, 3*time.Second, cfg.Server.ReadTimeout)
+	assert.Equal(t, 10*time.Second, cfg.Server.WriteTimeout) // default kept
+	assert.Equal(t, "from-env", cfg.OpenFGA.StoreID)
+	assert.Equal(t, "latest", cfg.OpenFGA.ModelID) // default kept
+	assert.True(t, cfg.OpenFGA.WriteModel)
+	assert.Equal(t, "parallel", cfg.OpenFGA.WriteMode)
+	assert.Equal(t, 100, cfg.OpenFGA.MaxTuplesPerWrite) // default kept
+	assert.Equal(t, "", cfg.Queue.Dir)
+	assert.Equal(t, "otlp", cfg.Tracing.Exporter)
+	assert.Equal(t, 1.0, cfg.Tracing.SampleRatio) // default kept
+	assert.Equal(t, "debug", cfg.Logging.Level)
+	assert.Equal(t, "json", cfg.Logging.Format) // default kept
+	assert.Equal(t, 30*time.Second, cfg.Mappings.WatchInterval)
+	assert.Equal(t, "secret", cfg.Admin.Token)
+}
+
+func TestLoadServiceConfig_ConfigFileEnv(t *testing.T) {
+	path := writeConfigFile(t, "server:\n  port: 9100\n")
+	t.Setenv("CONFIG_FILE", path)
+
+	cfg, err := LoadServiceConfig("")
+	require.NoError(t, err)
+	assert.Equal(t, 9100, cfg.Server.Port)
+}
+
+func TestLoadServiceConfig_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		file    string
+		env     map[string]string
+		wantErr string
+	}{
+		{
+			name:    "unknown key",
+			file:    "server:\n  prot: 9000\n",
+			wantErr: "field prot not found",
+		},
+		{
+			name:    "invalid duration in file",
+			file:    "server:\n  read_timeout: \"ten seconds\"\n",
+			wantErr: "time.Duration",
+		},
+		{
+			name:    "invalid value in env",
+			env:     map[string]string{"RETRY_INITIAL_INTERVAL": "soon"},
+			wantErr: "RETRY_INITIAL_INTERVAL",
+		},
+		{
+			name:    "out of range value",
+			file:    "retry:\n  jitter: 2\n",
+			wantErr: "retry.jitter must be between 0 and 1",
+		},
+		{
+			name:    "zero max attempts",
+			file:    "retry:\n  max_attempts: 0\n",
+			wantErr: "retry.max_attempts must be positive",
+		},
+		{
+			name:    "unknown auth method",
+			env:     map[string]string{"OPENFGA_AUTH_METHOD": "oauth"},
+			wantErr: "openfga.auth_method",
+		},
+		{
+			name:    "unknown write mode",
+			file:    "openfga:\n  write_mode: batched\n",
+			wantErr: "openfga.write_mode must be transactional or parallel",
+		},
+		{
+			name:    "zero tuples per write",
+			env:     map[string]string{"OPENFGA_MAX_TUPLES_PER_WRITE": "0"},
+			wantErr: "openfga.max_tuples_per_write must be at least 1",
+		},
+		{
+			name:    "unknown trace exporter",
+			file:    "tracing:\n  exporter: jaeger\n",
+			wantErr: "tracing.exporter must be one of none, otlp, stdout",
+		},
+		{
+			name:    "sample ratio out of range",
+			env:     map[string]string{"TRACING_SAMPLE_RATIO": "1.5"},
+			wantErr: "tracing.sample_ratio must be between 0 and 1",
+		},
+		{
+			name:    "unknown log level",
+			env:     map[string]string{"LOG_LEVEL": "verbose"},
+			wantErr: "logging.level must be one of debug, info, warn, error",
+		},
+		{
+			name:    "unknown log format",
+			file:    "logging:\n  format: logfmt\n",
+			wantErr: "logging.format must be json or text",
+		},
+		{
+			name:    "negative watch interval",
+			env:     map[string]string{"MAPPINGS_WATCH_INTERVAL": "-1s"},
+			wantErr: "mappings.watch_interval must not be negative",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+
+			path := ""
+			if tt.file != "" {
+				path = writeConfigFile(t, tt.file)
+			}
+
+			_, err := LoadServiceConfig(path)
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.wantErr)
+		})
+	}
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := LoadServiceConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+		assert.Error(t, err)
+	})
+}
+
+func writeMappingFile(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+const connectionMappings = `
+events:
+  - type: "organization.connection.*"
+    action: "update"
+mappings: []
+`
+
+func TestLoadMappingConfig_NameAndUnknownKeys(t *testing.T) {
+	dir := t.TempDir()
+
+	cfg, err := LoadMappingConfig(writeMappingFile(t, dir, "connection-mappings.yaml", connectionMappings))
+	require.NoError(t, err)
+	assert.Equal(t, "connection-mappings", cfg.Name)
+
+	cfg, err = LoadMappingConfig(writeMappingFile(t, dir, "named.yaml", "name: connections\n"+connectionMappings))
+	require.NoError(t, err)
+	assert.Equal(t, "connections", cfg.Name)
+
+	_, err = LoadMappingConfig(writeMappingFile(t, dir, "typo.yaml", connectionMappings+"mapings: []\n"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "field mapings not found")
+}
+
+func TestLoadMappingConfigPaths(t *testing.T) {
+	t.Run("shipped glob", func(t *testing.T) {
+		configs, err := LoadMappingConfigPaths([]string{"../../configs/*-mappings.yaml"})
+		require.NoError(t, err)
+
+		var names []string
+		for _, cfg := range configs {
+			names = append(names, cfg.Name)
+		}
+		assert.Equal(t, []string{"organization-mappings", "organization-member-mappings", "organization-role-mappings", "user-mappings"}, names)
+	})
+
+	t.Run("directory and overlapping file", func(t *testing.T) {
+		dir := t.TempDir()
+		b := writeMappingFile(t, dir, "b.yml", connectionMappings)
+		writeMappingFile(t, dir, "a.yaml", connectionMappings)
+		writeMappingFile(t, dir, "notes.txt", "not a mapping file")
+
+		configs, err := LoadMappingConfigPaths([]string{dir, b})
+		require.NoError(t, err)
+		require.Len(t, configs, 2)
+		assert.Equal(t, "a", configs[0].Name)
+		assert.Equal(t, "b", configs[1].Name)
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		dir := t.TempDir()
+		writeMappingFile(t, dir, "first.yaml", "name: same\n"+connectionMappings)
+		writeMappingFile(t, dir, "second.yaml", "name: same\n"+connectionMappings)
+		noEvents := writeMappingFile(t, t.TempDir(), "empty.yaml", "mappings: []\n")
+
+		_, err := LoadMappingConfigPaths([]string{filepath.Join(dir, "*.json")})
+		assert.ErrorContains(t, err, "no mapping files found")
+
+		_, err = LoadMappingConfigPaths([]string{dir})
+		assert.ErrorContains(t, err, `both named "same"`)
+
+		_, err = LoadMappingConfigPaths([]string{noEvents})
+		assert.ErrorContains(t, err, "declares no events")
+	})
+}
+
+func TestHashMappingFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeMappingFile(t, dir, "a.yaml", connectionMappings)
+
+	hash, err := HashMappingFiles([]string{dir})
+	require.NoError(t, err)
+	assert.Len(t, hash, 12)
+
+	unchanged, err := HashMappingFiles([]string{dir})
+	require.NoError(t, err)
+	assert.Equal(t, hash, unchanged)
+
+	// Adding, editing and renaming a file all change the hash
+	seen := map[string]bool{hash: true}
+	for _, change := range []func(){
+		func() { writeMappingFile(t, dir, "b.yaml", connectionMappings) },
+		func() { writeMappingFile(t, dir, "b.yaml", "name: b\n"+connectionMappings) },
+		func() { require.NoError(t, os.Rename(filepath.Join(dir, "b.yaml"), filepath.Join(dir, "c.yaml"))) },
+	} {
+		change()
+		changed, err := HashMappingFiles([]string{dir})
+		require.NoError(t, err)
+		assert.False(t, seen[changed], "hash %s was already seen", changed)
+		seen[changed] = true
+	}
+
+	_, err = HashMappingFiles([]string{filepath.Join(dir, "*.json")})
+	assert.ErrorContains(t, err, "no mapping files found")
+}
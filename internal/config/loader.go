@@ -1,15 +1,24 @@
 package config
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 
 	"mapping-engine/internal/types"
 )
 
-// LoadMappingConfig loads mapping configuration from a YAML file
+// LoadMappingConfig loads mapping configuration from a YAML file.
+// Unknown keys are rejected, and the configuration is named after the file unless it sets a name.
 func LoadMappingConfig(configPath string) (*types.MappingConfig, error) {
 	filename, err := filepath.Abs(configPath)
 	if err != nil {
@@ -22,14 +31,53 @@ func LoadMappingConfig(configPath string) (*types.MappingConfig, error) {
 	}
 
 	var config types.MappingConfig
-	err = yaml.Unmarshal(yamlFile, &config)
-	if err != nil {
-		return nil, err
+	decoder := yaml.NewDecoder(bytes.NewReader(yamlFile))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&config); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
+	}
+
+	if config.Name == "" {
+		config.Name = strings.TrimSuffix(filepath.Base(configPath), filepath.Ext(configPath))
+	}
+
+	// Remember where each field is, so errors found when compiling the configuration can point at it
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlFile, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", configPath, err)
 	}
+	config.Source = configPath
+	config.Lines = make(map[string]int)
+	recordLines(&root, "", config.Lines)
 
 	return &config, nil
 }
 
+// recordLines records the line of every mapping key and sequence item below node, keyed by field path
+func recordLines(node *yaml.Node, path string, lines map[string]int) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			recordLines(child, path, lines)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			childPath := node.Content[i].Value
+			if path != "" {
+				childPath = path + "." + childPath
+			}
+			lines[childPath] = node.Content[i].Line
+			recordLines(node.Content[i+1], childPath, lines)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			childPath := fmt.Sprintf("%s[%d]", path, i)
+			lines[childPath] = child.Line
+			recordLines(child, childPath, lines)
+		}
+	}
+}
+
 // LoadMappingConfigs loads multiple mapping configurations from YAML files
 func LoadMappingConfigs(configPaths []string) ([]*types.MappingConfig, error) {
 	var configs []*types.MappingConfig
@@ -44,3 +92,89 @@ func LoadMappingConfigs(configPaths []string) ([]*types.MappingConfig, error) {
 
 	return configs, nil
 }
+
+// LoadMappingConfigPaths loads the mapping configurations found at each path, which may be a file,
+// a directory (every *.yaml and *.yml file in it) or a glob such as "configs/*-mappings.yaml".
+// Every path must match at least one file, every file must declare events, and names must be unique.
+func LoadMappingConfigPaths(paths []string) ([]*types.MappingConfig, error) {
+	files, err := resolveMappingFiles(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := LoadMappingConfigs(files)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make(map[string]string)
+	for i, config := range configs {
+		if len(config.Events) == 0 {
+			return nil, fmt.Errorf("mapping file %s declares no events", files[i])
+		}
+		if other, exists := names[config.Name]; exists {
+			return nil, fmt.Errorf("mapping files %s and %s are both named %q", other, files[i], config.Name)
+		}
+		names[config.Name] = files[i]
+	}
+
+	return configs, nil
+}
+
+// HashMappingFiles returns a short hash of the names and contents of the mapping files found at
+// paths, as resolved by LoadMappingConfigPaths. It changes whenever a file is edited, added,
+// renamed or removed, so it identifies the version of a set of mapping configurations.
+func HashMappingFiles(paths []string) (string, error) {
+	files, err := resolveMappingFiles(paths)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.New()
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(hash, "%s\x00%d\x00", file, len(data))
+		hash.Write(data)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))[:12], nil
+}
+
+// resolveMappingFiles expands directories and globs into a sorted, de-duplicated list of files per path
+func resolveMappingFiles(paths []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+
+	for _, path := range paths {
+		var matches []string
+		if info, err := os.Stat(path); err == nil && info.IsDir() {
+			for _, pattern := range []string{"*.yaml", "*.yml"} {
+				dirMatches, _ := filepath.Glob(filepath.Join(path, pattern))
+				matches = append(matches, dirMatches...)
+			}
+		} else {
+			globMatches, err := filepath.Glob(path)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mappings path %q: %w", path, err)
+			}
+			matches = globMatches
+		}
+
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no mapping files found at %s", path)
+		}
+
+		sort.Strings(matches)
+		for _, match := range matches {
+			if !seen[match] {
+				seen[match] = true
+				files = append(files, match)
+			}
+		}
+	}
+
+	return files, nil
+}
@@ -0,0 +1,145 @@
+package deadletter
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry is an event that could not be processed, together with why
+type Entry struct {
+	EventID   string          `json:"event_id,omitempty"`
+	EventType string          `json:"event_type,omitempty"`
+	FailedAt  time.Time       `json:"failed_at"`
+	Attempts  int             `json:"attempts"`
+	Retryable bool            `json:"retryable"` // Whether the last error was transient
+	Error     string          `json:"error"`
+	Event     json.RawMessage `json:"event"`
+}
+
+// NewEntry builds an entry for an event that failed with err after the given number of attempts
+func NewEntry(event map[string]interface{}, attempts int, retryable bool, err error) (Entry, error) {
+	payload, marshalErr := json.Marshal(event)
+	if marshalErr != nil {
+		return Entry{}, fmt.Errorf("failed to encode dead-lettered event: %w", marshalErr)
+	}
+
+	entry := Entry{
+		FailedAt:  time.Now().UTC(),
+		Attempts:  attempts,
+		Retryable: retryable,
+		Error:     err.Error(),
+		Event:     payload,
+	}
+	entry.EventID, _ = event["id"].(string)
+	entry.EventType, _ = event["type"].(string)
+
+	return entry, nil
+}
+
+// File stores dead-lettered events as JSON lines in a single file
+type File struct {
+	mu   sync.Mutex
+	path string
+}
+
+// NewFile creates a dead-letter store at path; the file is created on the first Add
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// Path returns the location of the dead-letter file
+func (f *File) Path() string {
+	return f.path
+}
+
+// Add appends an entry and flushes it to disk
+func (f *File) Add(entry Entry) error {
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode dead-letter entry: %w", err)
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create dead-letter directory: %w", err)
+	}
+
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dead-letter entry: %w", err)
+	}
+
+	return file.Sync()
+}
+
+// List returns every entry in the order they were added
+func (f *File) List() ([]Entry, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	data, err := os.ReadFile(f.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read dead-letter file: %w", err)
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+
+		var entry Entry
+		if err := json.Unmarshal(line, &entry); err != nil {
+			return nil, fmt.Errorf("invalid dead-letter entry on line %d: %w", lineNumber, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, scanner.Err()
+}
+
+// Replace atomically rewrites the file to contain exactly the given entries
+func (f *File) Replace(entries []Entry) error {
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		line, err := json.Marshal(entry)
+		if err != nil {
+			return fmt.Errorf("failed to encode dead-letter entry: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp := f.path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write dead-letter file: %w", err)
+	}
+
+	if err := os.Rename(tmp, f.path); err != nil {
+		return fmt.Errorf("failed to replace dead-letter file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package deadletter
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFile_AddListReplace(t *testing.T) {
+	f := NewFile(filepath.Join(t.TempDir(), "nested", "dead-letter.jsonl"))
+
+	entries, err := f.List()
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	first, err := NewEntry(map[string]interface{}{"id": "evt_1", "type": "user.created"}, 5, true, errors.New("openfga unavailable"))
+	require.NoError(t, err)
+	second, err := NewEntry(map[string]interface{}{"id": "evt_2", "type": "user.updated"}, 1, false, errors.New("invalid tuple"))
+	require.NoError(t, err)
+
+	require.NoError(t, f.Add(first))
+	require.NoError(t, f.Add(second))
+
+	entries, err = f.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, "evt_1", entries[0].EventID)
+	assert.Equal(t, "user.created", entries[0].EventType)
+	assert.Equal(t, 5, entries[0].Attempts)
+	assert.True(t, entries[0].Retryable)
+	assert.Equal(t, "invalid tuple", entries[1].Error)
+	assert.JSONEq(t, `{"id":"evt_2","type":"user.updated"}`, string(entries[1].Event))
+
+	require.NoError(t, f.Replace(entries[1:]))
+	entries, err = f.List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "evt_2", entries[0].EventID)
+}
@@ -0,0 +1,222 @@
+package dedup
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// expireEvery is how many event IDs are marked between sweeps for expired IDs, both in memory and on disk
+const expireEvery = 1024
+
+// Store remembers the IDs of processed events for a TTL, so redelivered events can be skipped
+type Store interface {
+	// Seen reports whether the event ID was marked within the TTL
+	Seen(id string) bool
+
+	// Mark records the event ID as processed
+	Mark(id string) error
+}
+
+// MemoryStore is a Store that keeps event IDs in memory
+type MemoryStore struct {
+	mu   sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+	now  func() time.Time
+}
+
+// NewMemoryStore creates an in-memory store that remembers event IDs for ttl
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{
+		ttl:  ttl,
+		seen: make(map[string]time.Time),
+		now:  time.Now,
+	}
+}
+
+// Seen reports whether the event ID was marked within the TTL
+func (ms *MemoryStore) Seen(id string) bool {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	markedAt, ok := ms.seen[id]
+	return ok && ms.now().Sub(markedAt) < ms.ttl
+}
+
+// Mark records the event ID as processed
+func (ms *MemoryStore) Mark(id string) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.mark(id, ms.now())
+	return nil
+}
+
+// Len returns the number of event IDs within the TTL
+func (ms *MemoryStore) Len() int {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.expire()
+	return len(ms.seen)
+}
+
+// mark records an event ID, expiring old ones as the map grows; the caller must hold the lock
+func (ms *MemoryStore) mark(id string, at time.Time) {
+	if len(ms.seen) > 0 && len(ms.seen)%expireEvery == 0 {
+		ms.expire()
+	}
+	ms.seen[id] = at
+}
+
+// expire removes event IDs older than the TTL; the caller must hold the lock
+func (ms *MemoryStore) expire() {
+	now := ms.now()
+	for id, markedAt := range ms.seen {
+		if now.Sub(markedAt) >= ms.ttl {
+			delete(ms.seen, id)
+		}
+	}
+}
+
+// fileRecord is one line of a FileStore
+type fileRecord struct {
+	ID     string    `json:"id"`
+	SeenAt time.Time `json:"seen_at"`
+}
+
+// FileStore is a Store that also appends event IDs to a file, so they survive restarts
+type FileStore struct {
+	*MemoryStore
+	path     string
+	file     *os.File
+	appended int // Records appended since the file was last compacted
+}
+
+// OpenFileStore opens the store at path, loading the event IDs still within the TTL.
+// The file is rewritten without expired IDs on open and after every expireEvery marks.
+func OpenFileStore(path string, ttl time.Duration) (*FileStore, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create dedup directory: %w", err)
+	}
+
+	memory := NewMemoryStore(ttl)
+	if err := memory.load(path); err != nil {
+		return nil, err
+	}
+
+	if err := memory.compact(path); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open dedup file: %w", err)
+	}
+
+	return &FileStore{MemoryStore: memory, path: path, file: file}, nil
+}
+
+// Mark records the event ID as processed and flushes it to disk
+func (fs *FileStore) Mark(id string) error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	now := fs.now()
+	line, err := json.Marshal(fileRecord{ID: id, SeenAt: now.UTC()})
+	if err != nil {
+		return fmt.Errorf("failed to encode dedup record: %w", err)
+	}
+
+	if _, err := fs.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("failed to write dedup record: %w", err)
+	}
+	if err := fs.file.Sync(); err != nil {
+		return fmt.Errorf("failed to sync dedup file: %w", err)
+	}
+
+	fs.mark(id, now)
+
+	fs.appended++
+	if fs.appended >= expireEvery {
+		return fs.compact()
+	}
+	return nil
+}
+
+// compact rewrites the file without expired IDs and reopens it for appending; the caller must hold the lock
+func (fs *FileStore) compact() error {
+	fs.expire()
+	if err := fs.MemoryStore.compact(fs.path); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(fs.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open dedup file: %w", err)
+	}
+	fs.file.Close()
+	fs.file = file
+	fs.appended = 0
+	return nil
+}
+
+// Close closes the underlying file
+func (fs *FileStore) Close() error {
+	return fs.file.Close()
+}
+
+// load reads the records in path that are still within the TTL; a torn last line is ignored
+func (ms *MemoryStore) load(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read dedup file: %w", err)
+	}
+
+	now := ms.now()
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var record fileRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil || record.ID == "" {
+			continue
+		}
+		if now.Sub(record.SeenAt) < ms.ttl {
+			ms.seen[record.ID] = record.SeenAt
+		}
+	}
+
+	return scanner.Err()
+}
+
+// compact atomically rewrites path to contain only the loaded records
+func (ms *MemoryStore) compact(path string) error {
+	var buf bytes.Buffer
+	for id, seenAt := range ms.seen {
+		line, err := json.Marshal(fileRecord{ID: id, SeenAt: seenAt.UTC()})
+		if err != nil {
+			return fmt.Errorf("failed to encode dedup record: %w", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o644); err != nil {
+		return fmt.Errorf("failed to write dedup file: %w", err)
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to replace dedup file: %w", err)
+	}
+
+	return nil
+}
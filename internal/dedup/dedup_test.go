@@ -0,0 +1,94 @@
+package dedup
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryStore_SeenWithinTTL(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ms := NewMemoryStore(time.Hour)
+	ms.now = func() time.Time { return now }
+
+	assert.False(t, ms.Seen("evt_1"))
+	require.NoError(t, ms.Mark("evt_1"))
+	assert.True(t, ms.Seen("evt_1"))
+	assert.False(t, ms.Seen("evt_2"))
+
+	now = now.Add(time.Hour)
+	assert.False(t, ms.Seen("evt_1"))
+	assert.Equal(t, 0, ms.Len())
+}
+
+func TestFileStore_SurvivesReopen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.jsonl")
+
+	fs, err := OpenFileStore(path, time.Hour)
+	require.NoError(t, err)
+	require.NoError(t, fs.Mark("evt_1"))
+	require.NoError(t, fs.Mark("evt_2"))
+	require.NoError(t, fs.Close())
+
+	reopened, err := OpenFileStore(path, time.Hour)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.True(t, reopened.Seen("evt_1"))
+	assert.True(t, reopened.Seen("evt_2"))
+	assert.False(t, reopened.Seen("evt_3"))
+}
+
+func TestFileStore_DropsExpiredOnOpen(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.jsonl")
+
+	fs, err := OpenFileStore(path, time.Hour)
+	require.NoError(t, err)
+	fs.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	require.NoError(t, fs.Mark("evt_old"))
+	fs.now = time.Now
+	require.NoError(t, fs.Mark("evt_new"))
+	require.NoError(t, fs.Close())
+
+	reopened, err := OpenFileStore(path, time.Hour)
+	require.NoError(t, err)
+	defer reopened.Close()
+
+	assert.False(t, reopened.Seen("evt_old"))
+	assert.True(t, reopened.Seen("evt_new"))
+	assert.Equal(t, 1, reopened.Len())
+}
+
+func TestFileStore_CompactsWhileRunning(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.jsonl")
+
+	fs, err := OpenFileStore(path, time.Hour)
+	require.NoError(t, err)
+	defer fs.Close()
+
+	fs.now = func() time.Time { return time.Now().Add(-2 * time.Hour) }
+	for i := 0; i < expireEvery-1; i++ {
+		require.NoError(t, fs.Mark(fmt.Sprintf("evt_old_%d", i)))
+	}
+	fs.now = time.Now
+	require.NoError(t, fs.Mark("evt_new"))
+
+	// The expired IDs were dropped from the file without reopening the store
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Equal(t, 1, bytes.Count(data, []byte("\n")))
+
+	require.NoError(t, fs.Mark("evt_after"))
+	reopened, err := OpenFileStore(path, time.Hour)
+	require.NoError(t, err)
+	defer reopened.Close()
+	assert.True(t, reopened.Seen("evt_new"))
+	assert.True(t, reopened.Seen("evt_after"))
+	assert.Equal(t, 2, reopened.Len())
+}
@@ -0,0 +1,243 @@
+package engine
+
+import (
+	"fmt"
+	"strings"
+	"text/template"
+
+	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
+
+	"mapping-engine/internal/types"
+)
+
+// CompiledMappingConfig is a mapping configuration whose conditions and templates were compiled
+// once, so processing an event only executes precompiled programs and templates
+type CompiledMappingConfig struct {
+	*types.MappingConfig
+
+	entityID *template.Template // nil without an entity block
+	mappings []compiledMapping
+}
+
+// compiledMapping is a tuple mapping with its condition and templates compiled
+type compiledMapping struct {
+	types.TupleMapping
+
+	position  string      // Where the mapping is declared, e.g. "configs/user-mappings.yaml:12: mappings[2]"
+	forEach   *vm.Program // nil without for_each
+	condition *vm.Program // nil without a condition
+	tuple     compiledTuple
+	owns      *compiledTuple // Declared scope with defaults applied; nil when the scope is inferred
+
+	skipOnError bool // on_error: skip
+}
+
+// compiledTuple holds the templates of a tuple definition or scope
+type compiledTuple struct {
+	user      *template.Template
+	relation  *template.Template
+	object    *template.Template
+	condition *compiledCondition // nil for a tuple without a condition, and for scopes
+}
+
+// compiledCondition holds the name of a tuple condition and the templates of its string context values
+type compiledCondition struct {
+	name      string
+	templates map[string]*template.Template
+	values    map[string]interface{} // Non-string context values, passed through as-is
+}
+
+// Compile compiles the conditions and templates of a mapping configuration.
+// Errors name the file and line of the offending field when the configuration was loaded from a file.
+func Compile(config *types.MappingConfig) (*CompiledMappingConfig, error) {
+	compiled := &CompiledMappingConfig{MappingConfig: config}
+
+	if config.Entity != nil {
+		tmpl, err := compileTemplate(config, "entity.id", config.Entity.ID)
+		if err != nil {
+			return nil, err
+		}
+		compiled.entityID = tmpl
+	}
+
+	mappings, err := compileMappings(config, config.Mappings)
+	if err != nil {
+		return nil, err
+	}
+	compiled.mappings = mappings
+
+	return compiled, nil
+}
+
+// CompileAll compiles every mapping configuration, stopping at the first error
+func CompileAll(configs []*types.MappingConfig) ([]*CompiledMappingConfig, error) {
+	compiled := make([]*CompiledMappingConfig, 0, len(configs))
+	for _, config := range configs {
+		c, err := Compile(config)
+		if err != nil {
+			return nil, err
+		}
+		compiled = append(compiled, c)
+	}
+	return compiled, nil
+}
+
+// compileMappings compiles the condition, tuple and declared scope of each mapping
+func compileMappings(config *types.MappingConfig, mappings []types.TupleMapping) ([]compiledMapping, error) {
+	compiled := make([]compiledMapping, 0, len(mappings))
+
+	skipByDefault, err := configSkipsOnError(config)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, mapping := range mappings {
+		path := fmt.Sprintf("mappings[%d]", i)
+		cm := compiledMapping{TupleMapping: mapping, position: fmt.Sprintf("%s: %s", config.Position(path), path)}
+
+		switch onError := mapping.OnError; onError {
+		case "":
+			cm.skipOnError = skipByDefault
+		case types.OnErrorFail, types.OnErrorSkip:
+			cm.skipOnError = onError == types.OnErrorSkip
+		default:
+			return nil, fmt.Errorf("%s: %s.on_error: must be %q or %q, got %q", config.Position(path+".on_error"), path, types.OnErrorFail, types.OnErrorSkip, onError)
+		}
+
+		if mapping.ForEach != "" {
+			program, err := expr.Compile(mapping.ForEach)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s.for_each: %w", config.Position(path+".for_each"), path, err)
+			}
+			cm.forEach = program
+		}
+
+		if mapping.Condition != "" {
+			program, err := compileCondition(mapping.Condition)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s.condition: %w", config.Position(path+".condition"), path, err)
+			}
+			cm.condition = program
+		}
+
+		tuple, err := compileTuple(config, path+".tuple", types.TupleScope{
+			User:     mapping.Tuple.User,
+			Relation: mapping.Tuple.Relation,
+			Object:   mapping.Tuple.Object,
+		})
+		if err != nil {
+			return nil, err
+		}
+		if mapping.Tuple.Condition != nil {
+			tuple.condition, err = compileTupleCondition(config, path+".tuple.condition", mapping.Tuple.Condition)
+			if err != nil {
+				return nil, err
+			}
+		}
+		cm.tuple = tuple
+
+		if mapping.Owns != nil {
+			owns, err := compileTuple(config, path+".owns", declaredScope(mapping))
+			if err != nil {
+				return nil, err
+			}
+			cm.owns = &owns
+		}
+
+		compiled = append(compiled, cm)
+	}
+
+	return compiled, nil
+}
+
+// configSkipsOnError reports whether a configuration's on_error defaults its mappings to skip
+func configSkipsOnError(config *types.MappingConfig) (bool, error) {
+	switch config.OnError {
+	case "", types.OnErrorFail:
+		return false, nil
+	case types.OnErrorSkip:
+		return true, nil
+	default:
+		return false, fmt.Errorf("%s: on_error: must be %q or %q, got %q", config.Position("on_error"), types.OnErrorFail, types.OnErrorSkip, config.OnError)
+	}
+}
+
+// compileCondition compiles a condition expression, which must evaluate to a boolean.
+// Identifiers are resolved against the event at run time.
+func compileCondition(condition string) (*vm.Program, error) {
+	return expr.Compile(condition, expr.AsBool())
+}
+
+// compileTuple compiles the user, relation and object templates found at path
+func compileTuple(config *types.MappingConfig, path string, tuple types.TupleScope) (compiledTuple, error) {
+	user, err := compileTemplate(config, path+".user", tuple.User)
+	if err != nil {
+		return compiledTuple{}, err
+	}
+
+	relation, err := compileTemplate(config, path+".relation", tuple.Relation)
+	if err != nil {
+		return compiledTuple{}, err
+	}
+
+	object, err := compileTemplate(config, path+".object", tuple.Object)
+	if err != nil {
+		return compiledTuple{}, err
+	}
+
+	return compiledTuple{user: user, relation: relation, object: object}, nil
+}
+
+// compileTupleCondition compiles the templated context values of a tuple condition
+func compileTupleCondition(config *types.MappingConfig, path string, condition *types.TupleCondition) (*compiledCondition, error) {
+	if condition.Name == "" {
+		return nil, fmt.Errorf("%s: %s.name: condition name is required", config.Position(path), path)
+	}
+
+	compiled := &compiledCondition{
+		name:      condition.Name,
+		templates: make(map[string]*template.Template),
+		values:    make(map[string]interface{}),
+	}
+	for key, value := range condition.Context {
+		text, ok := value.(string)
+		if !ok {
+			compiled.values[key] = value
+			continue
+		}
+		tmpl, err := compileTemplate(config, path+".context."+key, text)
+		if err != nil {
+			return nil, err
+		}
+		compiled.templates[key] = tmpl
+	}
+
+	return compiled, nil
+}
+
+// compileTemplate parses a tuple template, naming it after its field path.
+// A field the event does not have yields nil, so helpers such as default, coalesce and required
+// see it as empty; rendered directly it fails the template in processTemplate.
+func compileTemplate(config *types.MappingConfig, path, text string) (*template.Template, error) {
+	tmpl, err := template.New(path).Option("missingkey=zero").Funcs(templateFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s: %w", config.Position(path), path, err)
+	}
+	return tmpl, nil
+}
+
+// declaredScope returns a mapping's owns block with empty fields defaulted from its tuple definition
+func declaredScope(mapping types.TupleMapping) types.TupleScope {
+	scope := *mapping.Owns
+	if scope.User == "" {
+		scope.User = templateTypePrefix(mapping.Tuple.User) + ":"
+	}
+	if scope.Relation == "" && !strings.Contains(mapping.Tuple.Relation, "{{") {
+		scope.Relation = mapping.Tuple.Relation
+	}
+	if scope.Object == "" {
+		scope.Object = templateTypePrefix(mapping.Tuple.Object) + ":"
+	}
+	return scope
+}
@@ -3,273 +3,302 @@ package engine
 import (
 	"bytes"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"text/template"
 
 	"github.com/antonmedv/expr"
+	"github.com/antonmedv/expr/vm"
 	"github.com/openfga/go-sdk/client"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
+	"mapping-engine/internal/logging"
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/tracing"
 	"mapping-engine/internal/types"
 )
 
 // MappingEngine handles the mapping of Auth0 events to OpenFGA tuples
 type MappingEngine struct {
-	fgaClient *client.OpenFgaClient
-	storeID   string
-	modelID   string
-	isDryRun  bool // Added for mock mode
-}
+	store   store.TupleStore
+	modelID string
+
+	// Object types searched, besides those of the event's own mapping file, when every tuple of a
+	// deleted entity is removed; see SetObjectTypes. Guarded by objectTypesMu, as mappings can be
+	// reloaded while events are processed.
+	objectTypes   []string
+	objectTypesMu sync.RWMutex
+
+	writeOptions WriteOptions
 
-// MockMappingEngine is a dry-run version that doesn't make actual API calls
-type MockMappingEngine struct {
-	*MappingEngine
+	logger *slog.Logger // nil discards records; see SetLogger
 }
 
-// NewMockMappingEngine creates a new mock mapping engine for dry-run mode
+// NewMockMappingEngine creates a new mock mapping engine for dry-run mode, backed by an empty in-memory store.
+// The in-memory store is not scoped to an OpenFGA store, so storeID is ignored.
 func NewMockMappingEngine(storeID, modelID string) *MappingEngine {
-	return &MappingEngine{
-		fgaClient: nil, // No actual client for dry-run
-		storeID:   storeID,
-		modelID:   modelID,
-		isDryRun:  true,
-	}
+	return NewMockMappingEngineWithStore(modelID, store.NewMemoryStore())
 }
 
-// NewMappingEngine creates a new mapping engine instance
+// NewMockMappingEngineWithStore creates a new mock mapping engine for dry-run mode that reads
+// and mutates the given tuple store instead of OpenFGA
+func NewMockMappingEngineWithStore(modelID string, tupleStore store.TupleStore) *MappingEngine {
+	return NewMappingEngineWithStore(tupleStore, modelID)
+}
+
+// NewMappingEngine creates a new mapping engine instance whose writes are pinned to modelID.
+// Resolve "latest" with store.ResolveModelID first; an empty modelID leaves writes unpinned.
 func NewMappingEngine(apiURL, storeID, modelID string) *MappingEngine {
 	configuration := &client.ClientConfiguration{
-		ApiUrl:               apiURL,
-		StoreId:              storeID,
-		AuthorizationModelId: modelID,
+		ApiUrl:  apiURL,
+		StoreId: storeID,
 	}
 
 	fgaClient, _ := client.NewSdkClient(configuration)
 
-	return &MappingEngine{
-		fgaClient: fgaClient,
-		storeID:   storeID,
-		modelID:   modelID,
-		isDryRun:  false,
-	}
+	return NewMappingEngineWithClient(fgaClient, storeID, modelID)
 }
 
 // NewMappingEngineWithClient creates a new mapping engine instance with a pre-configured client
-func NewMappingEngineWithClient(fgaClient *client.OpenFgaClient, storeID, modelFile string) *MappingEngine {
+func NewMappingEngineWithClient(fgaClient *client.OpenFgaClient, storeID, modelID string) *MappingEngine {
+	return NewMappingEngineWithStore(store.NewOpenFGAStore(fgaClient, storeID, modelID), modelID)
+}
+
+// NewMappingEngineWithStore creates a new mapping engine instance on top of any tuple store
+func NewMappingEngineWithStore(tupleStore store.TupleStore, modelID string) *MappingEngine {
 	return &MappingEngine{
-		fgaClient: fgaClient,
-		storeID:   storeID,
-		modelID:   modelFile,
-		isDryRun:  false,
+		store:   tupleStore,
+		modelID: modelID,
 	}
 }
 
+// SetObjectTypes sets the object types to search when an event deletes every tuple of its entity.
+// Pass ObjectTypes of every loaded mapping file, so that e.g. user.deleted also removes the
+// organization memberships and roles written by other mapping files.
+// It is safe to call while events are processed, e.g. when the mapping files are reloaded.
+func (me *MappingEngine) SetObjectTypes(objectTypes []string) {
+	me.objectTypesMu.Lock()
+	defer me.objectTypesMu.Unlock()
+	me.objectTypes = objectTypes
+}
+
+// searchedObjectTypes returns the object types set by SetObjectTypes
+func (me *MappingEngine) searchedObjectTypes() []string {
+	me.objectTypesMu.RLock()
+	defer me.objectTypesMu.RUnlock()
+	return me.objectTypes
+}
+
+// SetLogger sets the logger the engine reports skipped mappings and processed events to; nil discards them.
+// Records are logged with the event's context, so a logger from logging.New tags them with its correlation IDs.
+func (me *MappingEngine) SetLogger(logger *slog.Logger) {
+	me.logger = logger
+}
+
+// log returns the engine's logger
+func (me *MappingEngine) log() *slog.Logger {
+	return logging.OrDiscard(me.logger)
+}
+
 // ProcessEventResult contains the result of processing an event
 type ProcessEventResult struct {
 	TuplesAdded   []types.ProcessedTuple
 	TuplesDeleted []types.ProcessedTuple
 	Action        string
 	EventType     string
-}
 
-// ProcessEventWithDetails processes an event and returns detailed information about the operations
-func (me *MappingEngine) ProcessEventWithDetails(ctx context.Context, event map[string]interface{}, config *types.MappingConfig) (*ProcessEventResult, error) {
-	eventType, ok := event["type"].(string)
-	if !ok {
-		return nil, fmt.Errorf("event type not found or not a string")
-	}
+	// Write requests the change was applied in
+	Chunks []ChunkResult
 
-	// Find the action for this event type
-	var action string
-	for _, eventMapping := range config.Events {
-		if eventMapping.Type == eventType {
-			action = eventMapping.Action
-			break
-		}
-	}
+	// What each mapping did for the event, in mapping order
+	Mappings []MappingOutcome
 
-	if action == "" {
-		return nil, fmt.Errorf("no action found for event type: %s", eventType)
-	}
+	// Mappings that errored or produced a malformed tuple and were skipped; the rest of the event was still applied
+	MappingErrors []MappingError
+}
 
-	result := &ProcessEventResult{
-		Action:    action,
-		EventType: eventType,
-	}
+// MappingStatus is what a mapping did for an event
+type MappingStatus string
 
-	// Process mappings based on action
-	switch action {
-	case "create":
-		tuples, err := me.evaluateMappings(event, config.Mappings)
-		if err != nil {
-			return nil, fmt.Errorf("failed to evaluate mappings: %w", err)
-		}
-		result.TuplesAdded = tuples
-		if !me.isDryRun {
-			err = me.processCreateEvent(ctx, event, config)
-			if err != nil {
-				return nil, err
-			}
-		}
-	case "update":
-		if me.isDryRun {
-			// For dry-run, simulate the tuple change calculation
-			newTuples, err := me.evaluateMappings(event, config.Mappings)
-			if err != nil {
-				return nil, fmt.Errorf("failed to evaluate mappings: %w", err)
-			}
-
-			// Get entity ID for simulating existing tuples
-			entityID, err := me.extractUserID(event)
-			if err != nil {
-				return nil, fmt.Errorf("failed to extract entity ID: %w", err)
-			}
+const (
+	MappingMatched MappingStatus = "matched" // The mapping produced a tuple
+	MappingSkipped MappingStatus = "skipped" // The mapping's condition did not match
+	MappingErrored MappingStatus = "errored" // The mapping failed to evaluate or produced a malformed tuple
+)
 
-			// Simulate existing tuples for dry-run mode
-			existingTuples := me.simulateExistingTuples(entityID, config.Mappings)
-
-			tuplesToAdd, tuplesToDelete := me.calculateTupleChanges(existingTuples, newTuples)
-			result.TuplesAdded = tuplesToAdd
-			result.TuplesDeleted = tuplesToDelete
-		} else {
-			// For real update, we need to calculate changes
-			newTuples, err := me.evaluateMappings(event, config.Mappings)
-			if err != nil {
-				return nil, fmt.Errorf("failed to evaluate mappings: %w", err)
-			}
+// MappingOutcome reports one mapping's result for an event; for_each mappings report one outcome per element
+type MappingOutcome struct {
+	Mapping string                `json:"mapping"` // e.g. "configs/user-mappings.yaml:12: mappings[2]"
+	Status  MappingStatus         `json:"status"`
+	Tuple   *types.ProcessedTuple `json:"tuple,omitempty"` // Tuple produced, if any
+	Error   string                `json:"error,omitempty"`
+}
 
-			// Get entity ID for reading existing tuples
-			entityID, err := me.extractUserID(event)
-			if err != nil {
-				return nil, fmt.Errorf("failed to extract entity ID: %w", err)
-			}
+// evaluation is the result of evaluating a configuration's mappings against an event
+type evaluation struct {
+	tuples   []types.ProcessedTuple // Well-formed tuples of the matched mappings
+	outcomes []MappingOutcome
+	errors   []MappingError // Mappings skipped because of an error
+}
 
-			// Get existing tuples that are relevant to this mapping configuration
-			existingTuples, err := me.readExistingTuplesForMappings(ctx, entityID, config.Mappings)
-			if err != nil {
-				return nil, fmt.Errorf("failed to read existing tuples: %w", err)
-			}
+// record adds the outcome of a mapping for one element
+func (ev *evaluation) record(mapping compiledMapping, status MappingStatus, tuple *types.ProcessedTuple, err error) {
+	outcome := MappingOutcome{Mapping: mapping.position, Status: status, Tuple: tuple}
+	if err != nil {
+		outcome.Error = err.Error()
+	}
+	ev.outcomes = append(ev.outcomes, outcome)
+}
 
-			tuplesToAdd, tuplesToDelete := me.calculateTupleChanges(existingTuples, newTuples)
-			result.TuplesAdded = tuplesToAdd
-			result.TuplesDeleted = tuplesToDelete
+// skip records a mapping that was skipped because of an error
+func (ev *evaluation) skip(mapping compiledMapping, index int, tuple *types.ProcessedTuple, err error) {
+	ev.errors = append(ev.errors, MappingError{Mapping: mapping.position, Tuple: tuple, Err: err, index: index})
+	ev.record(mapping, MappingErrored, tuple, err)
+}
 
-			err = me.processUpdateEvent(ctx, event, config)
-			if err != nil {
-				return nil, err
-			}
-		}
-	case "delete":
-		tuples, err := me.evaluateMappings(event, config.Mappings)
-		if err != nil {
-			return nil, fmt.Errorf("failed to evaluate mappings: %w", err)
-		}
-		result.TuplesDeleted = tuples
-		if !me.isDryRun {
-			err = me.processDeleteEvent(ctx, event, config)
-			if err != nil {
-				return nil, err
-			}
-		}
-	default:
-		return nil, fmt.Errorf("unknown action: %s", action)
+// fail applies a mapping's on_error policy to an evaluation error, returning the error when it must fail the event
+func (ev *evaluation) fail(mapping compiledMapping, index int, err error) error {
+	if !mapping.skipOnError {
+		return MappingError{Mapping: mapping.position, Err: err, index: index}
 	}
+	ev.skip(mapping, index, nil, err)
+	return nil
+}
 
-	return result, nil
+// apply copies the outcomes of an evaluation to an event result
+func (ev *evaluation) apply(result *ProcessEventResult) {
+	result.Mappings = ev.outcomes
+	result.MappingErrors = ev.errors
 }
 
-// ProcessEvent processes an Auth0 event according to the mapping configuration
-func (me *MappingEngine) ProcessEvent(ctx context.Context, event map[string]interface{}, config *types.MappingConfig) error {
+// ProcessEventWithDetails processes an event and returns detailed information about the operations
+func (me *MappingEngine) ProcessEventWithDetails(ctx context.Context, event map[string]interface{}, config *CompiledMappingConfig) (result *ProcessEventResult, err error) {
+	ctx, span := tracing.Tracer().Start(ctx, "engine.ProcessEvent", trace.WithAttributes(me.eventAttributes(event, config)...))
+	ctx = logging.WithAttrs(ctx, logging.EventAttrs(event)...)
+	defer func() {
+		if result != nil {
+			span.SetAttributes(
+				attribute.String("engine.action", result.Action),
+				tracing.AttrTuplesWritten.Int(len(result.TuplesAdded)),
+				tracing.AttrTuplesDeleted.Int(len(result.TuplesDeleted)),
+				attribute.Int("openfga.write_requests", len(result.Chunks)),
+			)
+		}
+		tracing.End(span, err)
+	}()
+
 	eventType, ok := event["type"].(string)
 	if !ok {
-		return fmt.Errorf("event type not found or not a string")
+		return nil, fmt.Errorf("event type not found or not a string")
 	}
 
 	// Find the action for this event type
-	var action string
-	for _, eventMapping := range config.Events {
-		if eventMapping.Type == eventType {
-			action = eventMapping.Action
-			break
-		}
+	action, ok := eventAction(config.MappingConfig, eventType)
+	if !ok || action == "" {
+		return nil, fmt.Errorf("no action found for event type: %s", eventType)
 	}
 
-	if action == "" {
-		return fmt.Errorf("no action found for event type: %s", eventType)
+	result = &ProcessEventResult{
+		Action:    action,
+		EventType: eventType,
 	}
 
 	// Process mappings based on action
 	switch action {
 	case "create":
-		return me.processCreateEvent(ctx, event, config)
+		err = me.processCreateEvent(ctx, event, config, result)
 	case "update":
-		return me.processUpdateEvent(ctx, event, config)
+		err = me.processUpdateEvent(ctx, event, config, result)
 	case "delete":
-		return me.processDeleteEvent(ctx, event, config)
+		err = me.processDeleteEvent(ctx, event, config, result)
 	default:
-		return fmt.Errorf("unknown action: %s", action)
+		return nil, fmt.Errorf("unknown action: %s", action)
 	}
-}
-
-// processCreateEvent handles create actions
-func (me *MappingEngine) processCreateEvent(ctx context.Context, event map[string]interface{}, config *types.MappingConfig) error {
-	tuples, err := me.evaluateMappings(event, config.Mappings)
 	if err != nil {
-		return fmt.Errorf("failed to evaluate mappings: %w", err)
+		return nil, err
 	}
 
-	if len(tuples) == 0 {
-		return nil // No tuples to create
+	for _, mappingErr := range result.MappingErrors {
+		me.log().WarnContext(ctx, "Skipped mapping", "mapping_config", config.Name, "mapping", mappingErr.Mapping, "error", mappingErr.Err)
 	}
+	me.log().DebugContext(ctx, "Processed event",
+		"mapping_config", config.Name,
+		"action", result.Action,
+		"tuples_added", len(result.TuplesAdded),
+		"tuples_deleted", len(result.TuplesDeleted),
+		"write_requests", len(result.Chunks),
+	)
 
-	// Convert to OpenFGA tuples
-	fgaTuples := make([]client.ClientTupleKey, len(tuples))
-	for i, tuple := range tuples {
-		fgaTuples[i] = client.ClientTupleKey{
-			User:     tuple.User,
-			Relation: tuple.Relation,
-			Object:   tuple.Object,
-		}
+	return result, nil
+}
+
+// eventAttributes returns the span attributes identifying an event and the entity it is about
+func (me *MappingEngine) eventAttributes(event map[string]interface{}, config *CompiledMappingConfig) []attribute.KeyValue {
+	eventType, _ := event["type"].(string)
+	eventID, _ := event["id"].(string)
+	attributes := []attribute.KeyValue{
+		tracing.AttrEventID.String(eventID),
+		tracing.AttrEventType.String(eventType),
+		tracing.AttrMappingConfig.String(config.Name),
 	}
 
-	// Write tuples to OpenFGA
-	body := client.ClientWriteRequest{
-		Writes: fgaTuples,
+	if entityKey, err := me.EntityKey(event, config); err == nil {
+		attributes = append(attributes, tracing.AttrEntityID.String(entityKey))
 	}
+	return attributes
+}
+
+// ProcessEvent processes an Auth0 event according to the mapping configuration
+func (me *MappingEngine) ProcessEvent(ctx context.Context, event map[string]interface{}, config *CompiledMappingConfig) error {
+	_, err := me.ProcessEventWithDetails(ctx, event, config)
+	return err
+}
 
-	options := client.ClientWriteOptions{
-		StoreId: &me.storeID,
+// processCreateEvent handles create actions, recording the tuples written in the result
+func (me *MappingEngine) processCreateEvent(ctx context.Context, event map[string]interface{}, config *CompiledMappingConfig, result *ProcessEventResult) error {
+	ev, err := me.evaluateMappings(ctx, event, config.mappings)
+	if err != nil {
+		return fmt.Errorf("failed to evaluate mappings: %w", err)
 	}
+	ev.apply(result)
+	tuples := ev.tuples
 
-	if me.isDryRun {
-		// In dry-run mode, just log the action
-		fmt.Printf("Dry-run: create tuples %v\n", fgaTuples)
-		return nil
+	if len(tuples) == 0 {
+		return nil // No tuples to create
 	}
 
-	_, err = me.fgaClient.Write(ctx).Body(body).Options(options).Execute()
-	if err != nil {
+	if result.Chunks, err = me.writeTuples(ctx, tuples, nil); err != nil {
 		return fmt.Errorf("failed to write tuples to OpenFGA: %w", err)
 	}
 
+	result.TuplesAdded = tuples
 	return nil
 }
 
-// processUpdateEvent handles update actions
-func (me *MappingEngine) processUpdateEvent(ctx context.Context, event map[string]interface{}, config *types.MappingConfig) error {
-	newTuples, err := me.evaluateMappings(event, config.Mappings)
+// processUpdateEvent handles update actions, recording the tuples added and deleted in the result
+func (me *MappingEngine) processUpdateEvent(ctx context.Context, event map[string]interface{}, config *CompiledMappingConfig, result *ProcessEventResult) error {
+	ev, err := me.evaluateMappings(ctx, event, config.mappings)
 	if err != nil {
 		return fmt.Errorf("failed to evaluate mappings: %w", err)
 	}
+	ev.apply(result)
+	newTuples := ev.tuples
 
-	// Get the user ID from the event to query existing tuples
-	userID, err := me.extractUserID(event)
+	// Get the entity from the event to query existing tuples
+	entity, err := me.extractEntity(event, config)
 	if err != nil {
-		return fmt.Errorf("failed to extract user ID: %w", err)
+		return fmt.Errorf("failed to extract entity ID: %w", err)
 	}
 
-	// Read existing tuples for this user that are relevant to this mapping configuration
-	existingTuples, err := me.readExistingTuplesForMappings(ctx, userID, config.Mappings)
+	// Read existing tuples for this entity that are relevant to this mapping configuration
+	existingTuples, err := me.readExistingTuplesForMappings(ctx, event, entity, config.mappings)
 	if err != nil {
 		return fmt.Errorf("failed to read existing tuples: %w", err)
 	}
@@ -277,104 +306,57 @@ func (me *MappingEngine) processUpdateEvent(ctx context.Context, event map[strin
 	// Determine which tuples to add and which to delete
 	tuplesToAdd, tuplesToDelete := me.calculateTupleChanges(existingTuples, newTuples)
 
+	// A failed mapping produced nothing, which must not be mistaken for its tuples having been removed
+	tuplesToDelete, err = me.withoutFailedScopes(event, entity, config.mappings, ev.errors, tuplesToDelete)
+	if err != nil {
+		return fmt.Errorf("failed to resolve owned tuple scopes: %w", err)
+	}
+
 	// Execute changes
 	if len(tuplesToDelete) > 0 || len(tuplesToAdd) > 0 {
-		body := client.ClientWriteRequest{}
-
-		if len(tuplesToAdd) > 0 {
-			fgaTuples := make([]client.ClientTupleKey, len(tuplesToAdd))
-			for i, tuple := range tuplesToAdd {
-				fgaTuples[i] = client.ClientTupleKey{
-					User:     tuple.User,
-					Relation: tuple.Relation,
-					Object:   tuple.Object,
-				}
-			}
-			body.Writes = fgaTuples
-		}
-
-		if len(tuplesToDelete) > 0 {
-			fgaTuples := make([]client.ClientTupleKeyWithoutCondition, len(tuplesToDelete))
-			for i, tuple := range tuplesToDelete {
-				fgaTuples[i] = client.ClientTupleKeyWithoutCondition{
-					User:     tuple.User,
-					Relation: tuple.Relation,
-					Object:   tuple.Object,
-				}
-			}
-			body.Deletes = fgaTuples
-		}
-
-		options := client.ClientWriteOptions{
-			StoreId: &me.storeID,
-		}
-
-		if me.isDryRun {
-			// In dry-run mode, just log the action
-			fmt.Printf("Dry-run: update tuples, add: %v, delete: %v\n", body.Writes, body.Deletes)
-			return nil
-		}
-
-		_, err = me.fgaClient.Write(ctx).Body(body).Options(options).Execute()
-		if err != nil {
+		if result.Chunks, err = me.writeTuples(ctx, tuplesToAdd, tuplesToDelete); err != nil {
 			return fmt.Errorf("failed to update tuples in OpenFGA: %w", err)
 		}
 	}
 
+	result.TuplesAdded, result.TuplesDeleted = tuplesToAdd, tuplesToDelete
 	return nil
 }
 
-// processDeleteEvent handles delete actions
-func (me *MappingEngine) processDeleteEvent(ctx context.Context, event map[string]interface{}, config *types.MappingConfig) error {
+// processDeleteEvent handles delete actions, recording the tuples deleted in the result
+func (me *MappingEngine) processDeleteEvent(ctx context.Context, event map[string]interface{}, config *CompiledMappingConfig, result *ProcessEventResult) error {
 	// First, try to evaluate mappings to determine specific tuples to delete
-	tuplesToDelete, err := me.evaluateMappings(event, config.Mappings)
+	ev, err := me.evaluateMappings(ctx, event, config.mappings)
 	if err != nil {
 		return fmt.Errorf("failed to evaluate mappings: %w", err)
 	}
+	ev.apply(result)
+	tuplesToDelete := ev.tuples
 
 	// If we have specific tuples from mappings, delete those
 	if len(tuplesToDelete) > 0 {
-		// Convert to OpenFGA tuples for deletion
-		fgaTuples := make([]client.ClientTupleKeyWithoutCondition, len(tuplesToDelete))
-		for i, tuple := range tuplesToDelete {
-			fgaTuples[i] = client.ClientTupleKeyWithoutCondition{
-				User:     tuple.User,
-				Relation: tuple.Relation,
-				Object:   tuple.Object,
-			}
-		}
-
-		body := client.ClientWriteRequest{
-			Deletes: fgaTuples,
-		}
-
-		options := client.ClientWriteOptions{
-			StoreId: &me.storeID,
-		}
-
-		if me.isDryRun {
-			// In dry-run mode, just log the action
-			fmt.Printf("Dry-run: delete tuples %v\n", fgaTuples)
-			return nil
-		}
-
-		_, err = me.fgaClient.Write(ctx).Body(body).Options(options).Execute()
-		if err != nil {
+		if result.Chunks, err = me.writeTuples(ctx, nil, tuplesToDelete); err != nil {
 			return fmt.Errorf("failed to delete tuples from OpenFGA: %w", err)
 		}
 
+		result.TuplesDeleted = tuplesToDelete
+		return nil
+	}
+
+	// Mappings that errored may have named the tuples to delete, so don't fall back to deleting everything
+	if len(ev.errors) > 0 {
 		return nil
 	}
 
 	// If no specific tuples were found from mappings, fall back to deleting all tuples for the entity
 	// This handles cases like user.deleted or organization.deleted where we want to remove all related tuples
-	userID, err := me.extractUserID(event)
+	entity, err := me.extractEntity(event, config)
 	if err != nil {
-		return fmt.Errorf("failed to extract user ID: %w", err)
+		return fmt.Errorf("failed to extract entity ID: %w", err)
 	}
 
 	// Read all existing tuples for this entity
-	existingTuples, err := me.readExistingTuples(ctx, userID)
+	existingTuples, err := me.readExistingTuples(ctx, entity, config.Mappings)
 	if err != nil {
 		return fmt.Errorf("failed to read existing tuples: %w", err)
 	}
@@ -384,78 +366,170 @@ func (me *MappingEngine) processDeleteEvent(ctx context.Context, event map[strin
 	}
 
 	// Delete all tuples for this entity
-	fgaTuples := make([]client.ClientTupleKeyWithoutCondition, len(existingTuples))
-	for i, tuple := range existingTuples {
-		fgaTuples[i] = client.ClientTupleKeyWithoutCondition{
-			User:     tuple.User,
-			Relation: tuple.Relation,
-			Object:   tuple.Object,
-		}
-	}
-
-	body := client.ClientWriteRequest{
-		Deletes: fgaTuples,
+	if result.Chunks, err = me.writeTuples(ctx, nil, existingTuples); err != nil {
+		return fmt.Errorf("failed to delete tuples from OpenFGA: %w", err)
 	}
 
-	options := client.ClientWriteOptions{
-		StoreId: &me.storeID,
-	}
+	result.TuplesDeleted = existingTuples
+	return nil
+}
 
-	if me.isDryRun {
-		// In dry-run mode, just log the action
-		fmt.Printf("Dry-run: delete all tuples for entity %s\n", userID)
-		return nil
+// EvaluateMappings evaluates all mapping conditions and returns the resulting tuples
+// This is a public method that exposes the internal evaluateMappings functionality. It compiles
+// the mappings on every call; use a CompiledMappingConfig when processing many events.
+// Malformed tuples are returned as an error.
+func (me *MappingEngine) EvaluateMappings(event map[string]interface{}, mappings []types.TupleMapping) ([]types.ProcessedTuple, error) {
+	compiled, err := compileMappings(&types.MappingConfig{Name: "mappings"}, mappings)
+	if err != nil {
+		return nil, err
 	}
 
-	_, err = me.fgaClient.Write(ctx).Body(body).Options(options).Execute()
+	ev, err := me.evaluateMappings(context.Background(), event, compiled)
 	if err != nil {
-		return fmt.Errorf("failed to delete tuples from OpenFGA: %w", err)
+		return nil, err
 	}
-
-	return nil
+	if len(ev.errors) > 0 {
+		errs := make([]error, len(ev.errors))
+		for i, mappingErr := range ev.errors {
+			errs[i] = mappingErr
+		}
+		return nil, errors.Join(errs...)
+	}
+	return ev.tuples, nil
 }
 
-// EvaluateMappings evaluates all mapping conditions and returns the resulting tuples
-// This is a public method that exposes the internal evaluateMappings functionality
-func (me *MappingEngine) EvaluateMappings(event map[string]interface{}, mappings []types.TupleMapping) ([]types.ProcessedTuple, error) {
-	return me.evaluateMappings(event, mappings)
+// evaluateMappings evaluates all mapping conditions and returns the resulting tuples with the
+// outcome of each mapping. Tuples that are not well-formed OpenFGA tuples are left out and reported
+// as mapping errors, as are mappings that fail to evaluate with on_error: skip; any other
+// evaluation error is returned as a MappingError. Each mapping is traced as a span of ctx.
+func (me *MappingEngine) evaluateMappings(ctx context.Context, event map[string]interface{}, mappings []compiledMapping) (*evaluation, error) {
+	ev := &evaluation{}
+
+	for i, mapping := range mappings {
+		_, span := tracing.Tracer().Start(ctx, "engine.evaluateMapping", trace.WithAttributes(tracing.AttrMapping.String(mapping.position)))
+		tuples, errs := len(ev.tuples), len(ev.errors)
+
+		err := me.evaluateMappingScopes(ev, i, mapping, event)
+
+		// Mappings skipped on error are reported on their span too
+		spanErr := err
+		if spanErr == nil && len(ev.errors) > errs {
+			spanErr = ev.errors[len(ev.errors)-1]
+		}
+		span.SetAttributes(tracing.AttrTuplesMatched.Int(len(ev.tuples) - tuples))
+		tracing.End(span, spanErr)
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return ev, nil
 }
 
-// evaluateMappings evaluates all mapping conditions and returns the resulting tuples
-func (me *MappingEngine) evaluateMappings(event map[string]interface{}, mappings []types.TupleMapping) ([]types.ProcessedTuple, error) {
-	var results []types.ProcessedTuple
+// evaluateMappingScopes evaluates a mapping against the event, or against each element of its
+// for_each collection, and adds the results to the evaluation
+func (me *MappingEngine) evaluateMappingScopes(ev *evaluation, index int, mapping compiledMapping, event map[string]interface{}) error {
+	scopes := []map[string]interface{}{event}
+	if mapping.forEach != nil {
+		var err error
+		scopes, err = me.forEachScopes(mapping.forEach, event)
+		if err != nil {
+			return ev.fail(mapping, index, fmt.Errorf("failed to evaluate for_each '%s': %w", mapping.ForEach, err))
+		}
+	}
 
-	for _, mapping := range mappings {
-		// Evaluate condition if present
-		if mapping.Condition != "" {
-			matches, err := me.evaluateCondition(mapping.Condition, event)
-			if err != nil {
-				return nil, fmt.Errorf("failed to evaluate condition '%s': %w", mapping.Condition, err)
-			}
-			if !matches {
-				continue
+	for _, scope := range scopes {
+		processedTuple, matched, err := me.evaluateMapping(mapping, scope)
+		if err != nil {
+			if err := ev.fail(mapping, index, err); err != nil {
+				return err
 			}
+			continue
+		}
+		if !matched {
+			ev.record(mapping, MappingSkipped, nil, nil)
+			continue
 		}
 
-		// Process templates
-		processedTuple, err := me.processTemplates(mapping.Tuple, event)
-		if err != nil {
-			return nil, fmt.Errorf("failed to process templates: %w", err)
+		if err := ValidateTuple(processedTuple); err != nil {
+			ev.skip(mapping, index, &processedTuple, err)
+			continue
 		}
 
-		results = append(results, processedTuple)
+		ev.tuples = append(ev.tuples, processedTuple)
+		ev.record(mapping, MappingMatched, &processedTuple, nil)
 	}
 
-	return results, nil
+	return nil
 }
 
-// evaluateCondition evaluates a condition expression against the event data
-func (me *MappingEngine) evaluateCondition(condition string, event map[string]interface{}) (bool, error) {
-	program, err := expr.Compile(condition, expr.Env(event))
+// evaluateMapping evaluates a mapping's condition and, if it matches, renders its tuple
+func (me *MappingEngine) evaluateMapping(mapping compiledMapping, scope map[string]interface{}) (types.ProcessedTuple, bool, error) {
+	if mapping.condition != nil {
+		matches, err := me.evaluateCondition(mapping.condition, scope)
+		if err != nil {
+			return types.ProcessedTuple{}, false, fmt.Errorf("failed to evaluate condition '%s': %w", mapping.Condition, err)
+		}
+		if !matches {
+			return types.ProcessedTuple{}, false, nil
+		}
+	}
+
+	processedTuple, err := me.processTemplates(mapping.tuple, scope)
 	if err != nil {
-		return false, err
+		return types.ProcessedTuple{}, false, fmt.Errorf("failed to process templates: %w", err)
+	}
+	return processedTuple, true, nil
+}
+
+// forEachScopes evaluates a for_each expression and returns one copy of the event per element,
+// with the element bound as "item" and its index or map key as "key". Map keys are visited in
+// sorted order; a missing (nil) collection yields no elements.
+func (me *MappingEngine) forEachScopes(program *vm.Program, event map[string]interface{}) ([]map[string]interface{}, error) {
+	output, err := expr.Run(program, event)
+	if err != nil {
+		return nil, err
+	}
+
+	bind := func(key, item interface{}) map[string]interface{} {
+		scope := make(map[string]interface{}, len(event)+2)
+		for k, v := range event {
+			scope[k] = v
+		}
+		scope["key"] = key
+		scope["item"] = item
+		return scope
+	}
+
+	if output == nil {
+		return nil, nil
 	}
 
+	var scopes []map[string]interface{}
+	collection := reflect.ValueOf(output)
+	switch collection.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < collection.Len(); i++ {
+			scopes = append(scopes, bind(i, collection.Index(i).Interface()))
+		}
+	case reflect.Map:
+		keys := collection.MapKeys()
+		sort.Slice(keys, func(i, j int) bool {
+			return fmt.Sprint(keys[i].Interface()) < fmt.Sprint(keys[j].Interface())
+		})
+		for _, key := range keys {
+			scopes = append(scopes, bind(key.Interface(), collection.MapIndex(key).Interface()))
+		}
+	default:
+		return nil, fmt.Errorf("expected an array or map, got %T", output)
+	}
+
+	return scopes, nil
+}
+
+// evaluateCondition evaluates a compiled condition expression against the event data
+func (me *MappingEngine) evaluateCondition(program *vm.Program, event map[string]interface{}) (bool, error) {
 	output, err := expr.Run(program, event)
 	if err != nil {
 		return false, err
@@ -469,45 +543,136 @@ func (me *MappingEngine) evaluateCondition(condition string, event map[string]in
 	return result, nil
 }
 
-// processTemplates processes Go templates in tuple definitions
-func (me *MappingEngine) processTemplates(tupleDefinition types.TupleDefinition, event map[string]interface{}) (types.ProcessedTuple, error) {
-	user, err := me.processTemplate(tupleDefinition.User, event)
+// processTemplates executes the compiled templates of a tuple definition
+func (me *MappingEngine) processTemplates(tupleDefinition compiledTuple, event map[string]interface{}) (types.ProcessedTuple, error) {
+	user, err := me.processTemplate(tupleDefinition.user, event)
 	if err != nil {
 		return types.ProcessedTuple{}, fmt.Errorf("failed to process user template: %w", err)
 	}
 
-	relation, err := me.processTemplate(tupleDefinition.Relation, event)
+	relation, err := me.processTemplate(tupleDefinition.relation, event)
 	if err != nil {
 		return types.ProcessedTuple{}, fmt.Errorf("failed to process relation template: %w", err)
 	}
 
-	object, err := me.processTemplate(tupleDefinition.Object, event)
+	object, err := me.processTemplate(tupleDefinition.object, event)
 	if err != nil {
 		return types.ProcessedTuple{}, fmt.Errorf("failed to process object template: %w", err)
 	}
 
-	return types.ProcessedTuple{
+	processed := types.ProcessedTuple{
 		User:     user,
 		Relation: relation,
 		Object:   object,
-	}, nil
+	}
+
+	if tupleDefinition.condition != nil {
+		processed.Condition, err = me.processCondition(tupleDefinition.condition, event)
+		if err != nil {
+			return types.ProcessedTuple{}, fmt.Errorf("failed to process condition %s: %w", tupleDefinition.condition.name, err)
+		}
+	}
+
+	return processed, nil
 }
 
-// processTemplate processes a single template string
-func (me *MappingEngine) processTemplate(templateStr string, event map[string]interface{}) (string, error) {
-	tmpl, err := template.New("tuple").Parse(templateStr)
-	if err != nil {
-		return "", err
+// processCondition renders the context of a tuple condition
+func (me *MappingEngine) processCondition(condition *compiledCondition, event map[string]interface{}) (*types.TupleCondition, error) {
+	processed := &types.TupleCondition{Name: condition.name}
+	if len(condition.templates)+len(condition.values) == 0 {
+		return processed, nil
+	}
+
+	processed.Context = make(map[string]interface{}, len(condition.templates)+len(condition.values))
+	for key, value := range condition.values {
+		processed.Context[key] = value
 	}
+	for key, tmpl := range condition.templates {
+		value, err := me.processTemplate(tmpl, event)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process context value %s: %w", key, err)
+		}
+		processed.Context[key] = value
+	}
+
+	return processed, nil
+}
 
+// processTemplate executes a single compiled template. A field that is missing or null renders
+// "<no value>", so that is an error rather than part of a tuple.
+func (me *MappingEngine) processTemplate(tmpl *template.Template, event map[string]interface{}) (string, error) {
 	var buf bytes.Buffer
 	if err := tmpl.Execute(&buf, event); err != nil {
 		return "", err
 	}
 
+	if strings.Contains(buf.String(), "<no value>") {
+		return "", fmt.Errorf("template: %s: a field is missing or null; use default or coalesce for optional fields", tmpl.Name())
+	}
+
 	return buf.String(), nil
 }
 
+// entityRef identifies the entity an event is about
+type entityRef struct {
+	Type string // OpenFGA type of the entity; empty when the mapping file does not declare one
+	ID   string
+}
+
+// keys returns the OpenFGA keys the entity may appear under
+func (e entityRef) keys() []string {
+	if e.Type != "" {
+		return []string{fmt.Sprintf("%s:%s", e.Type, e.ID)}
+	}
+	return entityKeys(e.ID)
+}
+
+// matches reports whether a rendered "type:id" value refers to the entity
+func (e entityRef) matches(value string) bool {
+	idx := strings.Index(value, ":")
+	if idx <= 0 || value[idx+1:] != e.ID {
+		return false
+	}
+	return e.Type == "" || value[:idx] == e.Type
+}
+
+// extractEntity identifies the entity an event is about, using the mapping file's entity block if present
+func (me *MappingEngine) extractEntity(event map[string]interface{}, config *CompiledMappingConfig) (entityRef, error) {
+	if config.entityID == nil {
+		entityID, err := me.extractUserID(event)
+		if err != nil {
+			return entityRef{}, err
+		}
+		return entityRef{ID: entityID}, nil
+	}
+
+	entityID, err := me.processTemplate(config.entityID, event)
+	if err != nil {
+		return entityRef{}, fmt.Errorf("failed to process entity ID template: %w", err)
+	}
+
+	entityID = strings.TrimSpace(entityID)
+	if entityID == "" {
+		return entityRef{}, fmt.Errorf("entity ID template %q did not resolve to a value", config.Entity.ID)
+	}
+
+	return entityRef{Type: config.Entity.Type, ID: entityID}, nil
+}
+
+// EntityKey returns the key of the entity an event is about, such as "user:auth0|123".
+// Without an entity block in the mapping file, the key is the bare entity ID.
+func (me *MappingEngine) EntityKey(event map[string]interface{}, config *CompiledMappingConfig) (string, error) {
+	entity, err := me.extractEntity(event, config)
+	if err != nil {
+		return "", err
+	}
+
+	if entity.Type == "" {
+		return entity.ID, nil
+	}
+	return fmt.Sprintf("%s:%s", entity.Type, entity.ID), nil
+}
+
 // extractUserID extracts the user ID from the event
 func (me *MappingEngine) extractUserID(event map[string]interface{}) (string, error) {
 	data, ok := event["data"].(map[string]interface{})
@@ -540,136 +705,349 @@ func (me *MappingEngine) extractUserID(event map[string]interface{}) (string, er
 	return "", fmt.Errorf("could not extract user/entity ID from event")
 }
 
-// readExistingTuples reads existing tuples for an entity from OpenFGA
-func (me *MappingEngine) readExistingTuples(ctx context.Context, entityID string) ([]types.ProcessedTuple, error) {
-	// Read all tuples without filtering by user first
-	body := client.ClientReadRequest{}
+// readFilter describes a single filtered tuple store read.
+// OpenFGA requires an object, or at least an object type such as "user:", whenever a user is given.
+type readFilter struct {
+	User     string
+	Relation string
+	Object   string
+	// UserType restricts the results to users of this type; it is applied client-side
+	UserType string
+}
 
-	response, err := me.fgaClient.Read(ctx).Body(body).Execute()
-	if err != nil {
-		return nil, err
+// matches reports whether a tuple would be returned by the read
+func (f readFilter) matches(tuple types.ProcessedTuple) bool {
+	if f.User != "" && tuple.User != f.User {
+		return false
+	}
+	if f.Relation != "" && tuple.Relation != f.Relation {
+		return false
 	}
+	if f.UserType != "" && !strings.HasPrefix(tuple.User, f.UserType+":") {
+		return false
+	}
+	if strings.HasSuffix(f.Object, ":") {
+		return strings.HasPrefix(tuple.Object, f.Object)
+	}
+	return f.Object == "" || tuple.Object == f.Object
+}
 
-	// Filter tuples that match the entity (could be user: or organization:)
-	// For organizations, we need to find tuples where:
-	// 1. User matches "organization:entityID" (e.g., organization has_tier tier)
-	// 2. Object matches "organization:entityID" (e.g., external_org external_org organization)
-	var tuples []types.ProcessedTuple
-	userKey := fmt.Sprintf("user:%s", entityID)
-	orgKey := fmt.Sprintf("organization:%s", entityID)
+// readExistingTuples reads all existing tuples for an entity from OpenFGA
+func (me *MappingEngine) readExistingTuples(ctx context.Context, entity entityRef, mappings []types.TupleMapping) ([]types.ProcessedTuple, error) {
+	// Tuples where the entity is the user: OpenFGA needs an object type for these reads,
+	// so use every object type this or any other loaded mapping file can produce
+	var filters []readFilter
+	for _, objectType := range mergeTypes(mappingObjectTypes(mappings), me.searchedObjectTypes()) {
+		for _, entityKey := range entity.keys() {
+			filters = append(filters, readFilter{User: entityKey, Object: objectType + ":"})
+		}
+	}
 
-	for _, tuple := range response.Tuples {
-		if tuple.Key.User == userKey || tuple.Key.User == orgKey || tuple.Key.Object == orgKey {
-			tuples = append(tuples, types.ProcessedTuple{
-				User:     tuple.Key.User,
-				Relation: tuple.Key.Relation,
-				Object:   tuple.Key.Object,
-			})
+	// Tuples where the entity is the object (e.g., external_org external_org organization).
+	// Without a declared entity type, only organizations are read this way.
+	if entity.Type != "" {
+		filters = append(filters, readFilter{Object: entity.keys()[0]})
+	} else {
+		filters = append(filters, readFilter{Object: fmt.Sprintf("organization:%s", entity.ID)})
+	}
+
+	return me.readTuplesForFilters(ctx, filters)
+}
+
+// withoutFailedScopes drops the tuples owned by failed mappings from a list of tuples to delete
+func (me *MappingEngine) withoutFailedScopes(event map[string]interface{}, entity entityRef, mappings []compiledMapping, mappingErrors []MappingError, tuples []types.ProcessedTuple) ([]types.ProcessedTuple, error) {
+	if len(mappingErrors) == 0 || len(tuples) == 0 {
+		return tuples, nil
+	}
+
+	var filters []readFilter
+	for _, mappingErr := range mappingErrors {
+		filter, ok, err := me.resolveScope(mappings[mappingErr.index], event, entity)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			filters = append(filters, filter)
 		}
 	}
 
-	return tuples, nil
+	var kept []types.ProcessedTuple
+	for _, tuple := range tuples {
+		owned := false
+		for _, filter := range filters {
+			if filter.matches(tuple) {
+				owned = true
+				break
+			}
+		}
+		if !owned {
+			kept = append(kept, tuple)
+		}
+	}
+	return kept, nil
 }
 
 // readExistingTuplesForMappings reads existing tuples that could be generated by the given mapping configuration
-func (me *MappingEngine) readExistingTuplesForMappings(ctx context.Context, entityID string, mappings []types.TupleMapping) ([]types.ProcessedTuple, error) {
-	if me.isDryRun {
-		// In dry-run mode, we can't read from OpenFGA, so return empty
-		return []types.ProcessedTuple{}, nil
+func (me *MappingEngine) readExistingTuplesForMappings(ctx context.Context, event map[string]interface{}, entity entityRef, mappings []compiledMapping) ([]types.ProcessedTuple, error) {
+	filters, err := me.buildReadFilters(event, entity, mappings)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve owned tuple scopes: %w", err)
 	}
 
-	// Generate all possible tuple patterns from the mappings
-	possibleRelations := make(map[string]bool)
-	possibleObjects := make(map[string]bool)
+	return me.readTuplesForFilters(ctx, filters)
+}
 
-	userKey := fmt.Sprintf("user:%s", entityID)
+// buildReadFilters resolves the scope owned by each mapping into read filters, de-duplicating shared scopes
+func (me *MappingEngine) buildReadFilters(event map[string]interface{}, entity entityRef, mappings []compiledMapping) ([]readFilter, error) {
+	var filters []readFilter
+	seen := make(map[readFilter]bool)
 
-	// Extract all possible relations and object patterns from mappings
 	for _, mapping := range mappings {
-		possibleRelations[mapping.Tuple.Relation] = true
-
-		// For self-referencing tuples (like email_verified, blocked)
-		if mapping.Tuple.Object == "user:{{ .data.object.user_id }}" {
-			possibleObjects[userKey] = true
+		filter, ok, err := me.resolveScope(mapping, event, entity)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			continue
 		}
 
-		// For manager relationships (dynamic objects)
-		if strings.Contains(mapping.Tuple.Object, "user:{{ .data.object.app_metadata.manager }}") ||
-			strings.Contains(mapping.Tuple.Object, "user:{{ .data.object.user_metadata.manager_id }}") {
-			// We'll need to check these dynamically below
+		if !seen[filter] {
+			seen[filter] = true
+			filters = append(filters, filter)
 		}
 	}
 
-	// Read all tuples and filter by what this mapping configuration could generate
-	body := client.ClientReadRequest{}
-	response, err := me.fgaClient.Read(ctx).Body(body).Execute()
+	return filters, nil
+}
+
+// resolveScope returns the read filter covering the tuples a mapping owns for this event.
+// Declared scopes are rendered as templates. Otherwise the scope is inferred from the tuple:
+// a side is fixed when it renders to the entity's "type:id", and the other side is narrowed to
+// the type prefix of its template, since its previous value is unknown.
+// It returns false if the mapping owns no tuples that can be diffed.
+func (me *MappingEngine) resolveScope(mapping compiledMapping, event map[string]interface{}, entity entityRef) (readFilter, bool, error) {
+	if mapping.owns != nil {
+		return me.resolveDeclaredScope(mapping, event)
+	}
+
+	var relation string
+	if !strings.Contains(mapping.Tuple.Relation, "{{") {
+		relation = mapping.Tuple.Relation
+	}
+
+	userType := templateTypePrefix(mapping.Tuple.User)
+	objectType := templateTypePrefix(mapping.Tuple.Object)
+
+	user, err := me.processTemplate(mapping.tuple.user, event)
+	userBound := err == nil && userType != "" && entity.matches(user)
+
+	object, err := me.processTemplate(mapping.tuple.object, event)
+	objectBound := err == nil && objectType != "" && entity.matches(object)
+
+	switch {
+	case userBound && objectBound:
+		return readFilter{User: user, Relation: relation, Object: object}, true, nil
+	case userBound && objectType != "":
+		return readFilter{User: user, Relation: relation, Object: objectType + ":"}, true, nil
+	case objectBound:
+		return readFilter{Relation: relation, Object: object, UserType: userType}, true, nil
+	default:
+		// Neither side identifies the entity, so there is nothing this mapping can be diffed against
+		return readFilter{}, false, nil
+	}
+}
+
+// resolveDeclaredScope renders an explicit owns block, whose empty fields were defaulted from the tuple definition at compile time
+func (me *MappingEngine) resolveDeclaredScope(mapping compiledMapping, event map[string]interface{}) (readFilter, bool, error) {
+	scope := mapping.owns
+
+	user, err := me.processTemplate(scope.user, event)
 	if err != nil {
-		return nil, err
+		return readFilter{}, false, fmt.Errorf("failed to process owns user template: %w", err)
+	}
+
+	relation, err := me.processTemplate(scope.relation, event)
+	if err != nil {
+		return readFilter{}, false, fmt.Errorf("failed to process owns relation template: %w", err)
+	}
+
+	object, err := me.processTemplate(scope.object, event)
+	if err != nil {
+		return readFilter{}, false, fmt.Errorf("failed to process owns object template: %w", err)
+	}
+
+	if isEntityKey(user) {
+		if !strings.Contains(object, ":") || object == ":" {
+			return readFilter{}, false, fmt.Errorf("owns scope for user %s needs an object type", user)
+		}
+		return readFilter{User: user, Relation: relation, Object: object}, true, nil
+	}
+
+	if isEntityKey(object) {
+		return readFilter{Relation: relation, Object: object, UserType: strings.TrimSuffix(user, ":")}, true, nil
+	}
+
+	return readFilter{}, false, fmt.Errorf("owns scope must fix either the user or the object, got user %q and object %q", user, object)
+}
+
+// readTuplesForFilters runs each filter against OpenFGA and returns the de-duplicated union of the results
+func (me *MappingEngine) readTuplesForFilters(ctx context.Context, filters []readFilter) ([]types.ProcessedTuple, error) {
+	var tuples []types.ProcessedTuple
+	seen := make(map[string]bool)
+
+	for _, filter := range filters {
+		results, err := me.readTuples(ctx, filter)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tuple := range results {
+			key := fmt.Sprintf("%s#%s#%s", tuple.User, tuple.Relation, tuple.Object)
+			if !seen[key] {
+				seen[key] = true
+				tuples = append(tuples, tuple)
+			}
+		}
+	}
+
+	return tuples, nil
+}
+
+// readTuples reads every tuple matching the filter, following continuation tokens through all pages
+func (me *MappingEngine) readTuples(ctx context.Context, filter readFilter) ([]types.ProcessedTuple, error) {
+	storeFilter := store.Filter{
+		User:     filter.User,
+		Relation: filter.Relation,
+		Object:   filter.Object,
+	}
+
+	var tuples []types.ProcessedTuple
+	continuationToken := ""
+	for {
+		page, err := me.store.Read(ctx, storeFilter, continuationToken)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, tuple := range page.Tuples {
+			if filter.UserType != "" && !strings.HasPrefix(tuple.User, filter.UserType+":") {
+				continue
+			}
+			tuples = append(tuples, tuple)
+		}
+
+		if page.ContinuationToken == "" {
+			break
+		}
+		continuationToken = page.ContinuationToken
 	}
 
-	var relevantTuples []types.ProcessedTuple
-
-	for _, tuple := range response.Tuples {
-		// Only consider tuples where this user is the subject
-		if tuple.Key.User == userKey {
-			// Check if this tuple could have been generated by our mappings
-			if possibleRelations[tuple.Key.Relation] {
-				// For self-referencing relations (email_verified, phone_verified, blocked)
-				if possibleObjects[tuple.Key.Object] {
-					relevantTuples = append(relevantTuples, types.ProcessedTuple{
-						User:     tuple.Key.User,
-						Relation: tuple.Key.Relation,
-						Object:   tuple.Key.Object,
-					})
-				} else if tuple.Key.Relation == "manager" && strings.HasPrefix(tuple.Key.Object, "user:") {
-					// For manager relationships, include any user: object
-					relevantTuples = append(relevantTuples, types.ProcessedTuple{
-						User:     tuple.Key.User,
-						Relation: tuple.Key.Relation,
-						Object:   tuple.Key.Object,
-					})
+	return tuples, nil
+}
+
+// entityKeys returns the OpenFGA keys an entity ID of unknown type may appear under
+func entityKeys(entityID string) []string {
+	return []string{
+		fmt.Sprintf("user:%s", entityID),
+		fmt.Sprintf("organization:%s", entityID),
+	}
+}
+
+// mappingObjectTypes returns the distinct object types the mappings can produce, in mapping order
+func mappingObjectTypes(mappings []types.TupleMapping) []string {
+	var objectTypes []string
+	seen := make(map[string]bool)
+
+	for _, mapping := range mappings {
+		objectType := templateTypePrefix(mapping.Tuple.Object)
+		if objectType != "" && !seen[objectType] {
+			seen[objectType] = true
+			objectTypes = append(objectTypes, objectType)
+		}
+	}
+
+	return objectTypes
+}
+
+// ObjectTypes returns the distinct object types the mapping configurations can produce, in order
+func ObjectTypes(configs []*CompiledMappingConfig) []string {
+	var objectTypes []string
+	for _, config := range configs {
+		objectTypes = mergeTypes(objectTypes, mappingObjectTypes(config.Mappings))
+	}
+	return objectTypes
+}
+
+// LiteralRelations returns the relations the mapping configurations name literally, rather than
+// through a template, in their tuples and owned scopes
+func LiteralRelations(configs []*CompiledMappingConfig) map[string]bool {
+	relations := make(map[string]bool)
+	for _, config := range configs {
+		for _, mapping := range config.Mappings {
+			for _, relation := range []string{mapping.Tuple.Relation, ownedRelation(mapping)} {
+				if relation != "" && !strings.Contains(relation, "{{") {
+					relations[relation] = true
 				}
 			}
 		}
 	}
+	return relations
+}
 
-	return relevantTuples, nil
+// ownedRelation returns the relation of a mapping's explicit owned scope, or "" if it has none
+func ownedRelation(mapping types.TupleMapping) string {
+	if mapping.Owns == nil {
+		return ""
+	}
+	return mapping.Owns.Relation
 }
 
-// simulateExistingTuples creates mock existing tuples for dry-run mode
-// This simulates a scenario where all user mappings have existing tuples
-func (me *MappingEngine) simulateExistingTuples(entityID string, mappings []types.TupleMapping) []types.ProcessedTuple {
-	var simulatedTuples []types.ProcessedTuple
-	userKey := fmt.Sprintf("user:%s", entityID)
+// mergeTypes appends the types from extra that are not already in base
+func mergeTypes(base, extra []string) []string {
+	merged := append([]string(nil), base...)
+	seen := make(map[string]bool)
+	for _, t := range base {
+		seen[t] = true
+	}
 
-	// Simulate common existing tuples for user mappings
-	simulatedTuples = append(simulatedTuples, types.ProcessedTuple{
-		User:     userKey,
-		Relation: "email_verified",
-		Object:   userKey,
-	})
+	for _, t := range extra {
+		if !seen[t] {
+			seen[t] = true
+			merged = append(merged, t)
+		}
+	}
 
-	simulatedTuples = append(simulatedTuples, types.ProcessedTuple{
-		User:     userKey,
-		Relation: "manager",
-		Object:   "user:auth0|manager123", // Simulate an existing manager
-	})
+	return merged
+}
+
+// isEntityKey reports whether a value names a single entity ("type:id") rather than just a type ("type:")
+func isEntityKey(value string) bool {
+	idx := strings.Index(value, ":")
+	return idx > 0 && idx < len(value)-1
+}
 
-	return simulatedTuples
+// templateTypePrefix returns the literal OpenFGA type at the start of a tuple template
+// (e.g. "user" for "user:{{ .data.object.user_id }}"), or "" if the type itself is templated
+func templateTypePrefix(templateStr string) string {
+	idx := strings.Index(templateStr, ":")
+	if idx <= 0 || strings.Contains(templateStr[:idx], "{{") {
+		return ""
+	}
+	return templateStr[:idx]
 }
 
-// calculateTupleChanges determines which tuples to add and which to delete
+// calculateTupleChanges determines which tuples to add and which to delete.
+// Tuples are compared including their condition, so a tuple whose condition or context changed
+// is both deleted and added.
 func (me *MappingEngine) calculateTupleChanges(existing, new []types.ProcessedTuple) ([]types.ProcessedTuple, []types.ProcessedTuple) {
 	existingMap := make(map[string]types.ProcessedTuple)
 	for _, tuple := range existing {
-		key := fmt.Sprintf("%s#%s#%s", tuple.User, tuple.Relation, tuple.Object)
-		existingMap[key] = tuple
+		existingMap[tupleChangeKey(tuple)] = tuple
 	}
 
 	newMap := make(map[string]types.ProcessedTuple)
 	for _, tuple := range new {
-		key := fmt.Sprintf("%s#%s#%s", tuple.User, tuple.Relation, tuple.Object)
-		newMap[key] = tuple
+		newMap[tupleChangeKey(tuple)] = tuple
 	}
 
 	var tuplesToAdd []types.ProcessedTuple
@@ -691,3 +1069,15 @@ func (me *MappingEngine) calculateTupleChanges(existing, new []types.ProcessedTu
 
 	return tuplesToAdd, tuplesToDelete
 }
+
+// tupleChangeKey identifies a tuple together with its condition. Contexts are compared by their JSON
+// encoding, so values read back from OpenFGA (e.g. float64) equal the configured ones (e.g. int).
+func tupleChangeKey(tuple types.ProcessedTuple) string {
+	key := fmt.Sprintf("%s#%s#%s", tuple.User, tuple.Relation, tuple.Object)
+	if tuple.Condition == nil {
+		return key
+	}
+
+	context, _ := json.Marshal(tuple.Condition.Context)
+	return fmt.Sprintf("%s with %s%s", key, tuple.Condition.Name, context)
+}
@@ -236,8 +236,7 @@ func TestIntegration_UserLifecycle(t *testing.T) {
 	require.NoError(t, err)
 
 	// Load user mappings configuration
-	userConfig, err := config.LoadMappingConfig("../../configs/user-mappings.yaml")
-	require.NoError(t, err)
+	userConfig := loadCompiledConfig(t, "../../configs/user-mappings.yaml")
 
 	// Create mapping engine
 	engine := NewMappingEngine(container.apiURL, storeID, modelID)
@@ -371,8 +370,7 @@ func TestIntegration_OrganizationManagement(t *testing.T) {
 	require.NoError(t, err)
 
 	// Load organization mappings configuration
-	orgConfig, err := config.LoadMappingConfig("../../configs/organization-mappings.yaml")
-	require.NoError(t, err)
+	orgConfig := loadCompiledConfig(t, "../../configs/organization-mappings.yaml")
 
 	// Create mapping engine
 	engine := NewMappingEngine(container.apiURL, storeID, modelID)
@@ -474,8 +472,7 @@ func TestIntegration_OrganizationMembership(t *testing.T) {
 	require.NoError(t, err)
 
 	// Load organization member mappings configuration
-	memberConfig, err := config.LoadMappingConfig("../../configs/organization-member-mappings.yaml")
-	require.NoError(t, err)
+	memberConfig := loadCompiledConfig(t, "../../configs/organization-member-mappings.yaml")
 
 	// Create mapping engine
 	engine := NewMappingEngine(container.apiURL, storeID, modelID)
@@ -590,8 +587,7 @@ func TestIntegration_RoleAssignments(t *testing.T) {
 	require.NoError(t, err)
 
 	// Load organization role mappings configuration
-	roleConfig, err := config.LoadMappingConfig("../../configs/organization-role-mappings.yaml")
-	require.NoError(t, err)
+	roleConfig := loadCompiledConfig(t, "../../configs/organization-role-mappings.yaml")
 
 	// Create mapping engine
 	engine := NewMappingEngine(container.apiURL, storeID, modelID)
@@ -722,7 +718,9 @@ func TestIntegration_MultiConfiguration(t *testing.T) {
 		"../../configs/organization-role-mappings.yaml",
 	}
 
-	configs, err := config.LoadMappingConfigs(configPaths)
+	rawConfigs, err := config.LoadMappingConfigs(configPaths)
+	require.NoError(t, err)
+	configs, err := CompileAll(rawConfigs)
 	require.NoError(t, err)
 
 	// Create multi-config processor
@@ -946,7 +944,7 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 			},
 		}
 
-		err = engine.ProcessEvent(ctx, event, config)
+		err = engine.ProcessEvent(ctx, event, mustCompile(t, config))
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "no action found for event type")
 	})
@@ -967,16 +965,8 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 			},
 		}
 
-		event := map[string]interface{}{
-			"type": "user.created",
-			"data": map[string]interface{}{
-				"object": map[string]interface{}{
-					"user_id": "auth0|test",
-				},
-			},
-		}
-
-		err = engine.ProcessEvent(ctx, event, config)
+		// Syntax errors are reported when the configuration is compiled, before any event is processed
+		_, err = Compile(config)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "template")
 	})
@@ -998,16 +988,7 @@ func TestIntegration_ErrorHandling(t *testing.T) {
 			},
 		}
 
-		event := map[string]interface{}{
-			"type": "user.created",
-			"data": map[string]interface{}{
-				"object": map[string]interface{}{
-					"user_id": "auth0|test",
-				},
-			},
-		}
-
-		err = engine.ProcessEvent(ctx, event, config)
+		_, err = Compile(config)
 		assert.Error(t, err)
 		assert.Contains(t, err.Error(), "condition")
 	})
@@ -1,12 +1,47 @@
 package engine
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mapping-engine/internal/config"
+	"mapping-engine/internal/retry"
+	"mapping-engine/internal/store"
 	"mapping-engine/internal/types"
 )
 
+// mustCompile compiles a mapping configuration, failing the test on errors
+func mustCompile(t *testing.T, config *types.MappingConfig) *CompiledMappingConfig {
+	t.Helper()
+	compiled, err := Compile(config)
+	require.NoError(t, err)
+	return compiled
+}
+
+// loadCompiledConfig loads and compiles a mapping configuration file
+func loadCompiledConfig(t *testing.T, path string) *CompiledMappingConfig {
+	t.Helper()
+	mappingConfig, err := config.LoadMappingConfig(path)
+	require.NoError(t, err)
+	return mustCompile(t, mappingConfig)
+}
+
+// mustCompileMappings compiles tuple mappings outside of a mapping configuration
+func mustCompileMappings(t *testing.T, mappings ...types.TupleMapping) []compiledMapping {
+	t.Helper()
+	compiled, err := compileMappings(&types.MappingConfig{}, mappings)
+	require.NoError(t, err)
+	return compiled
+}
+
 func TestMappingEngine_EvaluateCondition(t *testing.T) {
 	engine := &MappingEngine{}
 
@@ -75,7 +110,10 @@ func TestMappingEngine_EvaluateCondition(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := engine.evaluateCondition(tt.condition, tt.event)
+			program, err := compileCondition(tt.condition)
+			require.NoError(t, err)
+
+			result, err := engine.evaluateCondition(program, tt.event)
 			if tt.wantError {
 				assert.Error(t, err)
 			} else {
@@ -99,6 +137,9 @@ func TestMappingEngine_ProcessTemplates(t *testing.T) {
 				"organization": map[string]interface{}{
 					"id": "org_123",
 				},
+				"app_metadata": map[string]interface{}{
+					"access_expires_at": "2027-01-01T00:00:00Z",
+				},
 			},
 		},
 	}
@@ -134,11 +175,41 @@ func TestMappingEngine_ProcessTemplates(t *testing.T) {
 				Object:   "role:admin#organization:org_123",
 			},
 		},
+		{
+			name: "conditional tuple",
+			definition: types.TupleDefinition{
+				User:     "user:{{ .data.object.user_id }}",
+				Relation: "member",
+				Object:   "organization:{{ .data.object.organization.id }}",
+				Condition: &types.TupleCondition{
+					Name: "not_expired",
+					Context: map[string]interface{}{
+						"expires_at": "{{ .data.object.app_metadata.access_expires_at }}",
+						"max_uses":   5,
+					},
+				},
+			},
+			expected: types.ProcessedTuple{
+				User:     "user:auth0|123456",
+				Relation: "member",
+				Object:   "organization:org_123",
+				Condition: &types.TupleCondition{
+					Name: "not_expired",
+					Context: map[string]interface{}{
+						"expires_at": "2027-01-01T00:00:00Z",
+						"max_uses":   5,
+					},
+				},
+			},
+		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			result, err := engine.processTemplates(tt.definition, event)
+			mappings, err := compileMappings(&types.MappingConfig{}, []types.TupleMapping{{Tuple: tt.definition}})
+			require.NoError(t, err)
+
+			result, err := engine.processTemplates(mappings[0].tuple, event)
 			assert.NoError(t, err)
 			assert.Equal(t, tt.expected, result)
 		})
@@ -177,7 +248,7 @@ func TestMappingEngine_EvaluateMappings(t *testing.T) {
 		},
 	}
 
-	result, err := engine.evaluateMappings(event, mappings)
+	result, err := engine.EvaluateMappings(event, mappings)
 	assert.NoError(t, err)
 	assert.Len(t, result, 1) // Only email_verified should match
 	assert.Equal(t, "user:auth0|123456", result[0].User)
@@ -206,3 +277,916 @@ func TestMappingEngine_CalculateTupleChanges(t *testing.T) {
 	assert.Len(t, toDelete, 1)
 	assert.Equal(t, "blocked", toDelete[0].Relation)
 }
+
+func TestMappingEngine_CalculateTupleChanges_Conditions(t *testing.T) {
+	engine := &MappingEngine{}
+
+	conditional := func(expiresAt interface{}) types.ProcessedTuple {
+		return types.ProcessedTuple{User: "user:123", Relation: "member", Object: "organization:1", Condition: &types.TupleCondition{
+			Name:    "not_expired",
+			Context: map[string]interface{}{"expires_at": expiresAt},
+		}}
+	}
+
+	// An unchanged condition is not a change, even when the context was read back with other Go types
+	toAdd, toDelete := engine.calculateTupleChanges(
+		[]types.ProcessedTuple{conditional(float64(1767225600))},
+		[]types.ProcessedTuple{conditional(1767225600)})
+	assert.Empty(t, toAdd)
+	assert.Empty(t, toDelete)
+
+	// A changed context rewrites the tuple
+	toAdd, toDelete = engine.calculateTupleChanges(
+		[]types.ProcessedTuple{conditional("2026-01-01T00:00:00Z")},
+		[]types.ProcessedTuple{conditional("2027-01-01T00:00:00Z")})
+	assert.Equal(t, []types.ProcessedTuple{conditional("2027-01-01T00:00:00Z")}, toAdd)
+	assert.Equal(t, []types.ProcessedTuple{conditional("2026-01-01T00:00:00Z")}, toDelete)
+
+	// So does adding a condition to an unconditional tuple
+	plain := types.ProcessedTuple{User: "user:123", Relation: "member", Object: "organization:1"}
+	toAdd, toDelete = engine.calculateTupleChanges([]types.ProcessedTuple{plain}, []types.ProcessedTuple{conditional("2027-01-01T00:00:00Z")})
+	assert.Len(t, toAdd, 1)
+	assert.Equal(t, []types.ProcessedTuple{plain}, toDelete)
+}
+
+func TestMappingEngine_BuildReadFilters(t *testing.T) {
+	engine := &MappingEngine{}
+
+	t.Run("user mappings", func(t *testing.T) {
+		event := map[string]interface{}{
+			"data": map[string]interface{}{
+				"object": map[string]interface{}{
+					"user_id": "auth0|123456",
+					"app_metadata": map[string]interface{}{
+						"manager": "auth0|manager-1",
+					},
+				},
+			},
+		}
+
+		mappings := []types.TupleMapping{
+			{
+				Condition: "data.object.email_verified == true",
+				Tuple: types.TupleDefinition{
+					User:     "user:{{ .data.object.user_id }}",
+					Relation: "email_verified",
+					Object:   "user:{{ .data.object.user_id }}",
+				},
+			},
+			{
+				Condition: "data.object.app_metadata != nil && data.object.app_metadata.manager != nil",
+				Tuple: types.TupleDefinition{
+					User:     "user:{{ .data.object.user_id }}",
+					Relation: "manager",
+					Object:   "user:{{ .data.object.app_metadata.manager }}",
+				},
+			},
+		}
+
+		filters, err := engine.buildReadFilters(event, entityRef{ID: "auth0|123456"}, mustCompileMappings(t, mappings...))
+		assert.NoError(t, err)
+		assert.Equal(t, []readFilter{
+			{User: "user:auth0|123456", Relation: "email_verified", Object: "user:auth0|123456"},
+			{User: "user:auth0|123456", Relation: "manager", Object: "user:"},
+		}, filters)
+	})
+
+	t.Run("organization mappings", func(t *testing.T) {
+		event := map[string]interface{}{
+			"data": map[string]interface{}{
+				"object": map[string]interface{}{
+					"id":       "org_123",
+					"metadata": map[string]interface{}{},
+				},
+			},
+		}
+
+		mappings := []types.TupleMapping{
+			{
+				Tuple: types.TupleDefinition{
+					User:     "external_org:{{ .data.object.metadata.external_org_id }}",
+					Relation: "external_org",
+					Object:   "organization:{{ .data.object.id }}",
+				},
+			},
+			{
+				Tuple: types.TupleDefinition{
+					User:     "organization:{{ .data.object.id }}",
+					Relation: "has_tier",
+					Object:   "tier:{{ .data.object.metadata.tier }}",
+				},
+			},
+		}
+
+		filters, err := engine.buildReadFilters(event, entityRef{ID: "org_123"}, mustCompileMappings(t, mappings...))
+		assert.NoError(t, err)
+		assert.Equal(t, []readFilter{
+			{Relation: "external_org", Object: "organization:org_123", UserType: "external_org"},
+			{User: "organization:org_123", Relation: "has_tier", Object: "tier:"},
+		}, filters)
+	})
+}
+
+func TestMappingEngine_ResolveScope(t *testing.T) {
+	engine := &MappingEngine{}
+
+	event := map[string]interface{}{
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"id":      "grp_1",
+				"team_id": "team_9",
+			},
+		},
+	}
+
+	t.Run("inferred for custom types", func(t *testing.T) {
+		mapping := types.TupleMapping{
+			Tuple: types.TupleDefinition{
+				User:     "group:{{ .data.object.id }}",
+				Relation: "parent",
+				Object:   "team:{{ .data.object.team_id }}",
+			},
+		}
+
+		filter, ok, err := engine.resolveScope(mustCompileMappings(t, mapping)[0], event, entityRef{Type: "group", ID: "grp_1"})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, readFilter{User: "group:grp_1", Relation: "parent", Object: "team:"}, filter)
+	})
+
+	t.Run("declared scope", func(t *testing.T) {
+		mapping := types.TupleMapping{
+			Tuple: types.TupleDefinition{
+				User:     "team:{{ .data.object.team_id }}",
+				Relation: "parent",
+				Object:   "group:{{ .data.object.id }}",
+			},
+			Owns: &types.TupleScope{
+				Object: "group:{{ .data.object.id }}",
+			},
+		}
+
+		filter, ok, err := engine.resolveScope(mustCompileMappings(t, mapping)[0], event, entityRef{Type: "group", ID: "unrelated"})
+		assert.NoError(t, err)
+		assert.True(t, ok)
+		assert.Equal(t, readFilter{Relation: "parent", Object: "group:grp_1", UserType: "team"}, filter)
+	})
+
+	t.Run("declared scope without a fixed side", func(t *testing.T) {
+		mapping := types.TupleMapping{
+			Tuple: types.TupleDefinition{
+				User:     "team:{{ .data.object.team_id }}",
+				Relation: "parent",
+				Object:   "group:{{ .data.object.id }}",
+			},
+			Owns: &types.TupleScope{},
+		}
+
+		_, _, err := engine.resolveScope(mustCompileMappings(t, mapping)[0], event, entityRef{Type: "group", ID: "grp_1"})
+		assert.Error(t, err)
+	})
+
+	t.Run("no scope", func(t *testing.T) {
+		mapping := types.TupleMapping{
+			Tuple: types.TupleDefinition{
+				User:     "team:{{ .data.object.team_id }}",
+				Relation: "parent",
+				Object:   "group:{{ .data.object.id }}",
+			},
+		}
+
+		_, ok, err := engine.resolveScope(mustCompileMappings(t, mapping)[0], event, entityRef{Type: "group", ID: "someone-else"})
+		assert.NoError(t, err)
+		assert.False(t, ok)
+	})
+}
+
+func TestMappingEngine_ExtractEntity(t *testing.T) {
+	engine := &MappingEngine{}
+
+	event := map[string]interface{}{
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"client_id": "client_abc",
+				"user_id":   "auth0|123456",
+			},
+		},
+	}
+
+	t.Run("declared entity", func(t *testing.T) {
+		config := mustCompile(t, &types.MappingConfig{
+			Entity: &types.EntityConfig{Type: "client", ID: "{{ .data.object.client_id }}"},
+		})
+
+		entity, err := engine.extractEntity(event, config)
+		assert.NoError(t, err)
+		assert.Equal(t, entityRef{Type: "client", ID: "client_abc"}, entity)
+		assert.True(t, entity.matches("client:client_abc"))
+		assert.False(t, entity.matches("user:client_abc"))
+
+		key, err := engine.EntityKey(event, config)
+		assert.NoError(t, err)
+		assert.Equal(t, "client:client_abc", key)
+	})
+
+	t.Run("declared entity missing from event", func(t *testing.T) {
+		config := mustCompile(t, &types.MappingConfig{
+			Entity: &types.EntityConfig{Type: "group", ID: "{{ .data.object.group_id }}"},
+		})
+
+		_, err := engine.extractEntity(event, config)
+		assert.Error(t, err)
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		entity, err := engine.extractEntity(event, mustCompile(t, &types.MappingConfig{}))
+		assert.NoError(t, err)
+		assert.Equal(t, entityRef{ID: "auth0|123456"}, entity)
+		assert.Equal(t, []string{"user:auth0|123456", "organization:auth0|123456"}, entity.keys())
+
+		key, err := engine.EntityKey(event, mustCompile(t, &types.MappingConfig{}))
+		assert.NoError(t, err)
+		assert.Equal(t, "auth0|123456", key)
+	})
+}
+
+func TestTemplateTypePrefix(t *testing.T) {
+	assert.Equal(t, "user", templateTypePrefix("user:{{ .data.object.user_id }}"))
+	assert.Equal(t, "role", templateTypePrefix("role:{{ .data.object.role.name }}|organization|{{ .data.object.organization.id }}"))
+	assert.Equal(t, "", templateTypePrefix("{{ .data.object.type }}:{{ .data.object.id }}"))
+	assert.Equal(t, "", templateTypePrefix("no-type"))
+}
+
+func TestMockMappingEngine_CumulativeUpdates(t *testing.T) {
+	ctx := context.Background()
+
+	userConfig := loadCompiledConfig(t, "../../configs/user-mappings.yaml")
+
+	seed := types.ProcessedTuple{User: "user:auth0|1", Relation: "manager", Object: "user:auth0|old-manager"}
+	memoryStore := store.NewMemoryStore(seed)
+	engine := NewMockMappingEngineWithStore("model", memoryStore)
+
+	userEvent := func(eventType string, object map[string]interface{}) map[string]interface{} {
+		object["user_id"] = "auth0|1"
+		return map[string]interface{}{
+			"type": eventType,
+			"data": map[string]interface{}{"object": object},
+		}
+	}
+
+	result, err := engine.ProcessEventWithDetails(ctx, userEvent("user.updated", map[string]interface{}{
+		"email_verified": true,
+		"app_metadata":   map[string]interface{}{"manager": "auth0|new-manager"},
+	}), userConfig)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []types.ProcessedTuple{
+		{User: "user:auth0|1", Relation: "email_verified", Object: "user:auth0|1"},
+		{User: "user:auth0|1", Relation: "manager", Object: "user:auth0|new-manager"},
+	}, result.TuplesAdded)
+	assert.Equal(t, []types.ProcessedTuple{seed}, result.TuplesDeleted)
+
+	// A second identical update is a no-op because the store now reflects the first one
+	result, err = engine.ProcessEventWithDetails(ctx, userEvent("user.updated", map[string]interface{}{
+		"email_verified": true,
+		"app_metadata":   map[string]interface{}{"manager": "auth0|new-manager"},
+	}), userConfig)
+	require.NoError(t, err)
+	assert.Empty(t, result.TuplesAdded)
+	assert.Empty(t, result.TuplesDeleted)
+
+	result, err = engine.ProcessEventWithDetails(ctx, userEvent("user.deleted", map[string]interface{}{}), userConfig)
+	require.NoError(t, err)
+	assert.Len(t, result.TuplesDeleted, 2)
+	assert.Empty(t, memoryStore.Tuples())
+}
+
+func TestMultiConfigProcessor_ProcessEvent(t *testing.T) {
+	ctx := context.Background()
+
+	rawConfigs, err := config.LoadMappingConfigs([]string{
+		"../../configs/user-mappings.yaml",
+		"../../configs/organization-member-mappings.yaml",
+	})
+	require.NoError(t, err)
+	configs, err := CompileAll(rawConfigs)
+	require.NoError(t, err)
+
+	memoryStore := store.NewMemoryStore()
+	processor := NewMultiConfigProcessorWithEngine(NewMappingEngineWithStore(memoryStore, ""), configs)
+
+	err = processor.ProcessEvent(ctx, map[string]interface{}{
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|1"},
+				"organization": map[string]interface{}{"id": "org_1"},
+			},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []types.ProcessedTuple{
+		{User: "user:auth0|1", Relation: "member", Object: "organization:org_1"},
+	}, memoryStore.Tuples())
+
+	err = processor.ProcessEvent(ctx, map[string]interface{}{"type": "unknown.event"})
+	assert.Error(t, err)
+}
+
+func TestEventAction(t *testing.T) {
+	config := &types.MappingConfig{
+		Events: []types.EventMapping{
+			{Type: "organization.connection.*", Action: "update"},
+			{Type: "organization.connection.removed", Action: "delete"},
+			{Type: "user.role.assigned", Action: "create"},
+		},
+	}
+
+	tests := []struct {
+		eventType string
+		action    string
+		ok        bool
+	}{
+		{"organization.connection.added", "update", true},
+		{"organization.connection.removed", "delete", true}, // exact match wins over the earlier wildcard
+		{"user.role.assigned", "create", true},
+		{"user.role.deleted", "", false},
+		{"organization.connection", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			action, ok := eventAction(config, tt.eventType)
+			assert.Equal(t, tt.ok, ok)
+			assert.Equal(t, tt.action, action)
+			assert.Equal(t, tt.ok, HandlesEvent(config, tt.eventType))
+		})
+	}
+}
+
+func TestConfigsForEvent(t *testing.T) {
+	users := mustCompile(t, &types.MappingConfig{Name: "users", Events: []types.EventMapping{{Type: "user.*", Action: "update"}}})
+	roles := mustCompile(t, &types.MappingConfig{Name: "roles", Events: []types.EventMapping{{Type: "user.role.assigned", Action: "create"}}})
+	configs := []*CompiledMappingConfig{users, roles}
+
+	assert.Equal(t, []*CompiledMappingConfig{users}, ConfigsForEvent(configs, "user.created"))
+	assert.Equal(t, []*CompiledMappingConfig{users, roles}, ConfigsForEvent(configs, "user.role.assigned"))
+	assert.Empty(t, ConfigsForEvent(configs, "organization.created"))
+}
+
+func TestLiteralRelations(t *testing.T) {
+	users := mustCompile(t, &types.MappingConfig{Name: "users", Mappings: []types.TupleMapping{
+		{Tuple: types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "organization:{{ .data.object.org_id }}"}},
+		{Tuple: types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "{{ .data.object.role }}", Object: "organization:{{ .data.object.org_id }}"},
+			Owns: &types.TupleScope{User: "user:{{ .data.object.user_id }}", Relation: "admin", Object: "organization:"}},
+	}})
+	roles := mustCompile(t, &types.MappingConfig{Name: "roles", Mappings: []types.TupleMapping{
+		{Tuple: types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "role:{{ .data.object.role_id }}"}},
+	}})
+
+	assert.Equal(t, map[string]bool{"member": true, "admin": true}, LiteralRelations([]*CompiledMappingConfig{users, roles}))
+	assert.Empty(t, LiteralRelations(nil))
+}
+
+func TestMappingEngine_DeleteRemovesTuplesFromOtherMappingFiles(t *testing.T) {
+	ctx := context.Background()
+
+	rawConfigs, err := config.LoadMappingConfigPaths([]string{"../../configs/*-mappings.yaml"})
+	require.NoError(t, err)
+	configs, err := CompileAll(rawConfigs)
+	require.NoError(t, err)
+	userConfig := ConfigsForEvent(configs, "user.deleted")[0]
+
+	memoryStore := store.NewMemoryStore(
+		types.ProcessedTuple{User: "user:u1", Relation: "email_verified", Object: "user:u1"},
+		types.ProcessedTuple{User: "user:u1", Relation: "member", Object: "organization:o1"},
+		types.ProcessedTuple{User: "user:u1", Relation: "is_role", Object: "role:admin|organization|o1"},
+		types.ProcessedTuple{User: "user:u2", Relation: "member", Object: "organization:o1"},
+	)
+	engine := NewMappingEngineWithStore(memoryStore, "")
+	engine.SetObjectTypes(ObjectTypes(configs))
+
+	result, err := engine.ProcessEventWithDetails(ctx, map[string]interface{}{
+		"type": "user.deleted",
+		"data": map[string]interface{}{"object": map[string]interface{}{"user_id": "u1"}},
+	}, userConfig)
+	require.NoError(t, err)
+	assert.Len(t, result.TuplesDeleted, 3)
+	assert.Equal(t, []types.ProcessedTuple{
+		{User: "user:u2", Relation: "member", Object: "organization:o1"},
+	}, memoryStore.Tuples())
+}
+
+func TestCompile_ReportsFileAndLine(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "broken-mappings.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(`events:
+  - type: user.created
+    action: create
+
+mappings:
+  - tuple:
+      user: "user:{{ .data.object.user_id }}"
+      relation: "member"
+      object: "organization:acme"
+  - condition: "data.object.email_verified =="
+    tuple:
+      user: "user:{{ .data.object.user_id }}"
+      relation: "email_verified"
+      object: "user:{{ .data.object.user_id"
+`), 0o644))
+
+	mappingConfig, err := config.LoadMappingConfig(path)
+	require.NoError(t, err)
+
+	_, err = Compile(mappingConfig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), path+":10: mappings[1].condition")
+
+	mappingConfig.Mappings[1].Condition = ""
+	_, err = Compile(mappingConfig)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), path+":14: mappings[1].tuple.object")
+}
+
+func TestMappingEngine_EvaluateMappings_ForEach(t *testing.T) {
+	engine := &MappingEngine{}
+
+	event := map[string]interface{}{
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user_id": "u1",
+				"app_metadata": map[string]interface{}{
+					"roles":  []interface{}{"employee", "manager", "contractor"},
+					"groups": map[string]interface{}{"ops": "admin", "dev": "member"},
+					"tier":   "gold",
+				},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		mapping  types.TupleMapping
+		expected []types.ProcessedTuple
+		err      string
+	}{
+		{
+			name: "array with condition on the item",
+			mapping: types.TupleMapping{
+				ForEach:   "data.object.app_metadata.roles",
+				Condition: `item != "contractor"`,
+				Tuple:     types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "assignee", Object: "role:{{ .item }}"},
+			},
+			expected: []types.ProcessedTuple{
+				{User: "user:u1", Relation: "assignee", Object: "role:employee"},
+				{User: "user:u1", Relation: "assignee", Object: "role:manager"},
+			},
+		},
+		{
+			name: "map binds key and item in key order",
+			mapping: types.TupleMapping{
+				ForEach: "data.object.app_metadata.groups",
+				Tuple:   types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "{{ .item }}", Object: "group:{{ .key }}"},
+			},
+			expected: []types.ProcessedTuple{
+				{User: "user:u1", Relation: "member", Object: "group:dev"},
+				{User: "user:u1", Relation: "admin", Object: "group:ops"},
+			},
+		},
+		{
+			name: "missing collection yields no tuples",
+			mapping: types.TupleMapping{
+				ForEach: "data.object.app_metadata.teams",
+				Tuple:   types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "team:{{ .item }}"},
+			},
+		},
+		{
+			name: "scalar is an error",
+			mapping: types.TupleMapping{
+				ForEach: "data.object.app_metadata.tier",
+				Tuple:   types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "tier:{{ .item }}"},
+			},
+			err: "expected an array or map, got string",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := engine.evaluateMappings(context.Background(), event, mustCompileMappings(t, tt.mapping))
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Empty(t, result.errors)
+			assert.Equal(t, tt.expected, result.tuples)
+		})
+	}
+}
+
+func TestMockMappingEngine_ForEachUpdateDiff(t *testing.T) {
+	ctx := context.Background()
+
+	rolesConfig := mustCompile(t, &types.MappingConfig{
+		Name:   "roles",
+		Entity: &types.EntityConfig{Type: "user", ID: "{{ .data.object.user_id }}"},
+		Events: []types.EventMapping{{Type: "user.updated", Action: "update"}},
+		Mappings: []types.TupleMapping{{
+			ForEach: "data.object.app_metadata.roles",
+			Tuple:   types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "assignee", Object: "role:{{ .item }}"},
+		}},
+	})
+
+	memoryStore := store.NewMemoryStore(
+		types.ProcessedTuple{User: "user:u1", Relation: "assignee", Object: "role:employee"},
+		types.ProcessedTuple{User: "user:u1", Relation: "assignee", Object: "role:manager"},
+	)
+	engine := NewMockMappingEngineWithStore("", memoryStore)
+
+	result, err := engine.ProcessEventWithDetails(ctx, map[string]interface{}{
+		"type": "user.updated",
+		"data": map[string]interface{}{"object": map[string]interface{}{
+			"user_id":      "u1",
+			"app_metadata": map[string]interface{}{"roles": []interface{}{"manager", "senior"}},
+		}},
+	}, rolesConfig)
+	require.NoError(t, err)
+	assert.Equal(t, []types.ProcessedTuple{{User: "user:u1", Relation: "assignee", Object: "role:senior"}}, result.TuplesAdded)
+	assert.Equal(t, []types.ProcessedTuple{{User: "user:u1", Relation: "assignee", Object: "role:employee"}}, result.TuplesDeleted)
+	assert.ElementsMatch(t, []types.ProcessedTuple{
+		{User: "user:u1", Relation: "assignee", Object: "role:manager"},
+		{User: "user:u1", Relation: "assignee", Object: "role:senior"},
+	}, memoryStore.Tuples())
+}
+
+func TestProcessTemplate_Funcs(t *testing.T) {
+	engine := &MappingEngine{}
+
+	event := map[string]interface{}{
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user_id":  "auth0|123",
+				"name":     "Acme Corp",
+				"email":    "Jane@Example.com",
+				"nickname": nil,
+				"roles":    []interface{}{"admin", "viewer"},
+			},
+		},
+	}
+
+	tests := []struct {
+		template string
+		expected string
+		err      string
+	}{
+		{template: `{{ .data.object.email | lower }}`, expected: "jane@example.com"},
+		{template: `{{ .data.object.name | upper }}`, expected: "ACME CORP"},
+		{template: `{{ .data.object.user_id | replace "|" "_" }}`, expected: "auth0_123"},
+		{template: `{{ .data.object.user_id | trimPrefix "auth0|" }}`, expected: "123"},
+		{template: `{{ .data.object.name | urlencode }}`, expected: "Acme+Corp"},
+		{template: `{{ .data.object.email | lower | sha256 }}`, expected: "8c87b489ce35cf2e2f39f80e282cb2e804932a56a213983eeeb428407d43b52d"},
+		{template: `{{ index (split "|" .data.object.user_id) 1 }}`, expected: "123"},
+		{template: `{{ .data.object.roles | join "," }}`, expected: "admin,viewer"},
+		{template: `{{ .data.object.nickname | default "anonymous" }}`, expected: "anonymous"},
+		{template: `{{ index .data.object "picture" | default "none" }}`, expected: "none"},
+		{template: `{{ coalesce .data.object.nickname .data.object.name }}`, expected: "Acme Corp"},
+		{template: `{{ required "user_id is required" .data.object.user_id }}`, expected: "auth0|123"},
+		{template: `{{ required "nickname is required" .data.object.nickname }}`, err: "nickname is required"},
+		{template: `{{ .data.object.picture | default "none" }}`, expected: "none"},
+		{template: `{{ coalesce .data.object.picture .data.object.name }}`, expected: "Acme Corp"},
+		{template: `{{ coalesce .data.object.picture .data.object.nickname }}`, err: "a field is missing or null"},
+		{template: `{{ required "picture is required" .data.object.picture }}`, err: "picture is required"},
+		{template: `{{ .data.object.picture }}`, err: "a field is missing or null"},
+		{template: `{{ .data.object.nickname }}`, err: "a field is missing or null"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			tmpl, err := compileTemplate(&types.MappingConfig{}, "tuple.object", tt.template)
+			require.NoError(t, err)
+
+			result, err := engine.processTemplate(tmpl, event)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, result)
+		})
+	}
+}
+
+func TestValidateTuple(t *testing.T) {
+	tests := []struct {
+		name  string
+		tuple types.ProcessedTuple
+		err   string
+	}{
+		{name: "user", tuple: types.ProcessedTuple{User: "user:auth0|123", Relation: "member", Object: "organization:acme"}},
+		{name: "userset", tuple: types.ProcessedTuple{User: "group:eng#member", Relation: "viewer", Object: "document:1"}},
+		{name: "wildcard", tuple: types.ProcessedTuple{User: "user:*", Relation: "viewer", Object: "document:1"}},
+		{name: "id with separators", tuple: types.ProcessedTuple{User: "user:1", Relation: "assignee", Object: "role:admin|organization|o1"}},
+		{name: "empty id", tuple: types.ProcessedTuple{User: "user:", Relation: "member", Object: "organization:acme"}, err: "user \"user:\" is not of the form"},
+		{name: "missing type", tuple: types.ProcessedTuple{User: "user:1", Relation: "member", Object: "acme"}, err: "object \"acme\" is not of the form type:id"},
+		{name: "whitespace in id", tuple: types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:Acme Corp"}, err: "is not of the form type:id"},
+		{name: "wildcard object", tuple: types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:*"}, err: "object cannot be a wildcard"},
+		{name: "relation with separator", tuple: types.ProcessedTuple{User: "user:1", Relation: "member#admin", Object: "organization:acme"}, err: "must not contain"},
+		{name: "empty relation", tuple: types.ProcessedTuple{User: "user:1", Object: "organization:acme"}, err: "relation is empty"},
+		{name: "object too long", tuple: types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:" + strings.Repeat("a", 256)}, err: "OpenFGA allows at most 256"},
+		{name: "unnamed condition", tuple: types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:acme", Condition: &types.TupleCondition{}}, err: "condition name is empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateTuple(tt.tuple)
+			if tt.err != "" {
+				assert.ErrorContains(t, err, tt.err)
+				return
+			}
+			assert.NoError(t, err)
+		})
+	}
+}
+
+func TestMockMappingEngine_MalformedTuples(t *testing.T) {
+	ctx := context.Background()
+
+	config := mustCompile(t, &types.MappingConfig{
+		Name:   "memberships",
+		Entity: &types.EntityConfig{Type: "user", ID: "{{ .data.object.user_id }}"},
+		Events: []types.EventMapping{
+			{Type: "user.created", Action: "create"},
+			{Type: "user.updated", Action: "update"},
+		},
+		Mappings: []types.TupleMapping{
+			{Tuple: types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "organization:{{ .data.object.org }}"}},
+			{Tuple: types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "team:{{ .data.object.team }}"}},
+		},
+	})
+
+	event := func(eventType, team string) map[string]interface{} {
+		return map[string]interface{}{
+			"type": eventType,
+			"data": map[string]interface{}{"object": map[string]interface{}{"user_id": "u1", "org": "acme", "team": team}},
+		}
+	}
+
+	t.Run("create writes the valid tuples", func(t *testing.T) {
+		memoryStore := store.NewMemoryStore()
+		engine := NewMockMappingEngineWithStore("", memoryStore)
+
+		result, err := engine.ProcessEventWithDetails(ctx, event("user.created", "Platform Team"), config)
+		require.NoError(t, err)
+		assert.Equal(t, []types.ProcessedTuple{{User: "user:u1", Relation: "member", Object: "organization:acme"}}, result.TuplesAdded)
+		require.Len(t, result.MappingErrors, 1)
+		assert.Equal(t, "team:Platform Team", result.MappingErrors[0].Tuple.Object)
+		assert.Contains(t, result.MappingErrors[0].Error(), "mappings[1]")
+		assert.Equal(t, result.TuplesAdded, memoryStore.Tuples())
+	})
+
+	t.Run("update keeps the tuples of failed mappings", func(t *testing.T) {
+		memoryStore := store.NewMemoryStore(
+			types.ProcessedTuple{User: "user:u1", Relation: "member", Object: "organization:old"},
+			types.ProcessedTuple{User: "user:u1", Relation: "member", Object: "team:platform"},
+		)
+		engine := NewMockMappingEngineWithStore("", memoryStore)
+
+		result, err := engine.ProcessEventWithDetails(ctx, event("user.updated", "Platform Team"), config)
+		require.NoError(t, err)
+		require.Len(t, result.MappingErrors, 1)
+		assert.Equal(t, []types.ProcessedTuple{{User: "user:u1", Relation: "member", Object: "organization:old"}}, result.TuplesDeleted)
+		assert.ElementsMatch(t, []types.ProcessedTuple{
+			{User: "user:u1", Relation: "member", Object: "organization:acme"},
+			{User: "user:u1", Relation: "member", Object: "team:platform"},
+		}, memoryStore.Tuples())
+	})
+}
+
+func TestMockMappingEngine_OnError(t *testing.T) {
+	ctx := context.Background()
+
+	newConfig := func(configOnError, mappingOnError string) *types.MappingConfig {
+		return &types.MappingConfig{
+			Name:    "users",
+			OnError: configOnError,
+			Events:  []types.EventMapping{{Type: "user.created", Action: "create"}},
+			Mappings: []types.TupleMapping{
+				{
+					Condition: `data.object.app_metadata.plan.tier == "pro"`,
+					Tuple:     types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "plan:pro"},
+					OnError:   mappingOnError,
+				},
+				{
+					Condition: `data.object.email_verified == true`,
+					Tuple:     types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "verified", Object: "user:{{ .data.object.user_id }}"},
+				},
+				{
+					Tuple: types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "owner", Object: "user:{{ .data.object.user_id }}"},
+				},
+			},
+		}
+	}
+
+	// app_metadata has no plan, so the first mapping's condition fails on a nil map access
+	event := map[string]interface{}{
+		"type": "user.created",
+		"data": map[string]interface{}{"object": map[string]interface{}{
+			"user_id":        "u1",
+			"email_verified": false,
+			"app_metadata":   map[string]interface{}{},
+		}},
+	}
+
+	t.Run("fail is the default", func(t *testing.T) {
+		engine := NewMockMappingEngineWithStore("", store.NewMemoryStore())
+		_, err := engine.ProcessEventWithDetails(ctx, event, mustCompile(t, newConfig("", "")))
+		var mappingErr MappingError
+		require.ErrorAs(t, err, &mappingErr)
+		assert.Equal(t, "users: mappings[0]", mappingErr.Mapping)
+		assert.ErrorContains(t, err, "failed to evaluate condition")
+	})
+
+	for _, tt := range []struct{ name, configOnError, mappingOnError string }{
+		{name: "skip on the mapping", mappingOnError: types.OnErrorSkip},
+		{name: "skip on the file", configOnError: types.OnErrorSkip},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			memoryStore := store.NewMemoryStore()
+			engine := NewMockMappingEngineWithStore("", memoryStore)
+
+			result, err := engine.ProcessEventWithDetails(ctx, event, mustCompile(t, newConfig(tt.configOnError, tt.mappingOnError)))
+			require.NoError(t, err)
+
+			owner := types.ProcessedTuple{User: "user:u1", Relation: "owner", Object: "user:u1"}
+			assert.Equal(t, []types.ProcessedTuple{owner}, memoryStore.Tuples())
+			require.Len(t, result.Mappings, 3)
+			assert.Equal(t, MappingErrored, result.Mappings[0].Status)
+			assert.Contains(t, result.Mappings[0].Error, "failed to evaluate condition")
+			assert.Equal(t, MappingOutcome{Mapping: "users: mappings[1]", Status: MappingSkipped}, result.Mappings[1])
+			assert.Equal(t, MappingOutcome{Mapping: "users: mappings[2]", Status: MappingMatched, Tuple: &owner}, result.Mappings[2])
+			require.Len(t, result.MappingErrors, 1)
+			assert.Equal(t, "users: mappings[0]", result.MappingErrors[0].Mapping)
+		})
+	}
+
+	t.Run("mapping overrides the file", func(t *testing.T) {
+		engine := NewMockMappingEngineWithStore("", store.NewMemoryStore())
+		_, err := engine.ProcessEventWithDetails(ctx, event, mustCompile(t, newConfig(types.OnErrorSkip, types.OnErrorFail)))
+		assert.ErrorContains(t, err, "failed to evaluate condition")
+	})
+
+	t.Run("invalid policy", func(t *testing.T) {
+		_, err := Compile(newConfig("", "ignore"))
+		assert.ErrorContains(t, err, `mappings[0].on_error: must be "fail" or "skip", got "ignore"`)
+
+		_, err = Compile(newConfig("retry", ""))
+		assert.ErrorContains(t, err, `on_error: must be "fail" or "skip", got "retry"`)
+	})
+}
+
+// failingStore rejects every write of one tuple, as OpenFGA does for a tuple the model does not allow
+type failingStore struct {
+	*store.MemoryStore
+	fail types.ProcessedTuple
+}
+
+func (fs failingStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	for _, tuple := range writes {
+		if tuple == fs.fail {
+			return retry.Retryable(errors.New("openfga unavailable"))
+		}
+	}
+	return fs.MemoryStore.Write(ctx, writes, deletes)
+}
+
+func TestWriteTransactional_RevertsOnlyAppliedChanges(t *testing.T) {
+	tuple := func(user string) types.ProcessedTuple {
+		return types.ProcessedTuple{User: "user:" + user, Relation: "member", Object: "group:1"}
+	}
+	conditional := tuple("e")
+	conditional.Condition = &types.TupleCondition{Name: "not_expired", Context: map[string]interface{}{"expires_at": "2027-01-01T00:00:00Z"}}
+
+	// a already exists and d does not; e is rewritten without its condition
+	memoryStore := store.NewMemoryStore(tuple("a"), conditional)
+	engine := NewMockMappingEngineWithStore("", failingStore{MemoryStore: memoryStore, fail: tuple("c")})
+	engine.SetWriteOptions(WriteOptions{MaxTuplesPerWrite: 2})
+
+	results, err := engine.writeTuples(context.Background(),
+		[]types.ProcessedTuple{tuple("e"), tuple("a"), tuple("b"), tuple("c")},
+		[]types.ProcessedTuple{tuple("d"), tuple("e")})
+
+	var writeErr *WriteError
+	require.ErrorAs(t, err, &writeErr)
+	assert.Nil(t, results)
+	require.Len(t, writeErr.Chunks, 3)
+	assert.True(t, writeErr.Chunks[0].Reverted)
+	assert.True(t, writeErr.Chunks[1].Reverted)
+	assert.Empty(t, writeErr.Chunks[1].RevertError)
+	assert.ElementsMatch(t, []types.ProcessedTuple{tuple("a"), conditional}, memoryStore.Tuples())
+}
+
+func TestSplitChunks(t *testing.T) {
+	tuples := func(n int, relation string) []types.ProcessedTuple {
+		result := make([]types.ProcessedTuple, n)
+		for i := range result {
+			result[i] = types.ProcessedTuple{User: fmt.Sprintf("user:%d", i), Relation: relation, Object: "group:g"}
+		}
+		return result
+	}
+
+	tests := []struct {
+		name     string
+		writes   int
+		deletes  int
+		expected [][2]int // Writes and deletes of each chunk
+	}{
+		{name: "empty"},
+		{name: "fits in one chunk", writes: 60, deletes: 40, expected: [][2]int{{60, 40}}},
+		{name: "deletes first", writes: 150, deletes: 120, expected: [][2]int{{0, 100}, {80, 20}, {70, 0}}},
+		{name: "writes only", writes: 250, expected: [][2]int{{100, 0}, {100, 0}, {50, 0}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sizes [][2]int
+			for _, chunk := range splitChunks(tuples(tt.writes, "member"), tuples(tt.deletes, "admin"), 100) {
+				sizes = append(sizes, [2]int{len(chunk.writes), len(chunk.deletes)})
+			}
+			assert.Equal(t, tt.expected, sizes)
+		})
+	}
+}
+
+func TestMockMappingEngine_ChunkedWrites(t *testing.T) {
+	ctx := context.Background()
+
+	config := mustCompile(t, &types.MappingConfig{
+		Name:   "groups",
+		Entity: &types.EntityConfig{Type: "user", ID: "{{ .data.object.user_id }}"},
+		Events: []types.EventMapping{{Type: "user.created", Action: "create"}},
+		Mappings: []types.TupleMapping{{
+			ForEach: "data.object.app_metadata.groups",
+			Tuple:   types.TupleDefinition{User: "user:{{ .data.object.user_id }}", Relation: "member", Object: "group:{{ .item }}"},
+		}},
+	})
+
+	groups := make([]interface{}, 250)
+	for i := range groups {
+		groups[i] = fmt.Sprintf("g%03d", i)
+	}
+	event := map[string]interface{}{
+		"type": "user.created",
+		"data": map[string]interface{}{"object": map[string]interface{}{
+			"user_id":      "u1",
+			"app_metadata": map[string]interface{}{"groups": groups},
+		}},
+	}
+	last := types.ProcessedTuple{User: "user:u1", Relation: "member", Object: "group:g249"}
+
+	t.Run("chunks respect the limit", func(t *testing.T) {
+		memoryStore := store.NewMemoryStore()
+		engine := NewMockMappingEngineWithStore("", memoryStore)
+
+		result, err := engine.ProcessEventWithDetails(ctx, event, config)
+		require.NoError(t, err)
+		assert.Equal(t, []ChunkResult{{Writes: 100}, {Writes: 100}, {Writes: 50}}, result.Chunks)
+		assert.Len(t, memoryStore.Tuples(), 250)
+	})
+
+	t.Run("transactional reverts applied chunks", func(t *testing.T) {
+		memoryStore := store.NewMemoryStore()
+		engine := NewMockMappingEngineWithStore("", failingStore{MemoryStore: memoryStore, fail: last})
+
+		_, err := engine.ProcessEventWithDetails(ctx, event, config)
+		var writeErr *WriteError
+		require.ErrorAs(t, err, &writeErr)
+		assert.ErrorContains(t, err, "1 of 3 chunks failed, change reverted: openfga unavailable")
+		assert.True(t, retry.IsRetryable(err))
+		assert.True(t, writeErr.Chunks[0].Reverted)
+		assert.True(t, writeErr.Chunks[1].Reverted)
+		assert.Equal(t, "openfga unavailable", writeErr.Chunks[2].Error)
+		assert.Empty(t, memoryStore.Tuples())
+	})
+
+	t.Run("transactional revert keeps pre-existing tuples", func(t *testing.T) {
+		seed := types.ProcessedTuple{User: "user:u1", Relation: "member", Object: "group:g000"}
+		memoryStore := store.NewMemoryStore(seed)
+		engine := NewMockMappingEngineWithStore("", failingStore{MemoryStore: memoryStore, fail: last})
+
+		_, err := engine.ProcessEventWithDetails(ctx, event, config)
+		require.Error(t, err)
+		assert.Equal(t, []types.ProcessedTuple{seed}, memoryStore.Tuples())
+	})
+
+	t.Run("parallel keeps the chunks that succeeded", func(t *testing.T) {
+		memoryStore := store.NewMemoryStore()
+		engine := NewMockMappingEngineWithStore("", failingStore{MemoryStore: memoryStore, fail: last})
+		engine.SetWriteOptions(WriteOptions{Mode: WriteParallel, MaxParallelRequests: 2})
+
+		_, err := engine.ProcessEventWithDetails(ctx, event, config)
+		var writeErr *WriteError
+		require.ErrorAs(t, err, &writeErr)
+		assert.ErrorContains(t, err, "1 of 3 chunks failed: openfga unavailable")
+		assert.False(t, writeErr.Chunks[0].Reverted)
+		assert.Len(t, memoryStore.Tuples(), 200)
+	})
+}
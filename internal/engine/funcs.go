@@ -0,0 +1,87 @@
+package engine
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strings"
+	"text/template"
+)
+
+// templateFuncs are the helper functions available in tuple templates. Functions taking the
+// templated value take it last, so they can be used in pipelines: {{ .name | lower | replace " " "-" }}
+var templateFuncs = template.FuncMap{
+	"lower":      strings.ToLower,
+	"upper":      strings.ToUpper,
+	"replace":    func(old, new, s string) string { return strings.ReplaceAll(s, old, new) },
+	"trimPrefix": func(prefix, s string) string { return strings.TrimPrefix(s, prefix) },
+	"urlencode":  url.QueryEscape,
+	"sha256":     sha256Hex,
+	"split":      func(sep, s string) []string { return strings.Split(s, sep) },
+	"join":       join,
+	"default":    defaultValue,
+	"coalesce":   coalesce,
+	"required":   required,
+}
+
+// sha256Hex returns the hex-encoded SHA-256 digest of a string
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// join concatenates the elements of an array, formatted as with {{ . }}, separated by sep
+func join(sep string, list interface{}) (string, error) {
+	value := reflect.ValueOf(list)
+	if value.Kind() != reflect.Slice && value.Kind() != reflect.Array {
+		return "", fmt.Errorf("join: expected an array, got %T", list)
+	}
+
+	parts := make([]string, value.Len())
+	for i := range parts {
+		parts[i] = fmt.Sprint(value.Index(i).Interface())
+	}
+	return strings.Join(parts, sep), nil
+}
+
+// defaultValue returns value, or fallback when value is empty
+func defaultValue(fallback, value interface{}) interface{} {
+	if isEmpty(value) {
+		return fallback
+	}
+	return value
+}
+
+// coalesce returns the first non-empty value, or nil if all are empty
+func coalesce(values ...interface{}) interface{} {
+	for _, value := range values {
+		if !isEmpty(value) {
+			return value
+		}
+	}
+	return nil
+}
+
+// required returns value, failing the template with message when value is empty
+func required(message string, value interface{}) (interface{}, error) {
+	if isEmpty(value) {
+		return nil, errors.New(message)
+	}
+	return value, nil
+}
+
+// isEmpty reports whether a value is nil, a zero value, or an empty string, array or map
+func isEmpty(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Invalid:
+		return true
+	case reflect.String, reflect.Slice, reflect.Array, reflect.Map:
+		return v.Len() == 0
+	default:
+		return v.IsZero()
+	}
+}
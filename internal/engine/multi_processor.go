@@ -3,20 +3,24 @@ package engine
 import (
 	"context"
 	"fmt"
-
-	"mapping-engine/internal/types"
 )
 
 // MultiConfigProcessor processes events against multiple mapping configurations
 type MultiConfigProcessor struct {
 	engine  *MappingEngine
-	configs []*types.MappingConfig
+	configs []*CompiledMappingConfig
 }
 
 // NewMultiConfigProcessor creates a new multi-config processor
-func NewMultiConfigProcessor(apiURL, storeID, modelID string, configs []*types.MappingConfig) *MultiConfigProcessor {
+func NewMultiConfigProcessor(apiURL, storeID, modelID string, configs []*CompiledMappingConfig) *MultiConfigProcessor {
+	return NewMultiConfigProcessorWithEngine(NewMappingEngine(apiURL, storeID, modelID), configs)
+}
+
+// NewMultiConfigProcessorWithEngine creates a new multi-config processor around an existing mapping engine
+func NewMultiConfigProcessorWithEngine(engine *MappingEngine, configs []*CompiledMappingConfig) *MultiConfigProcessor {
+	engine.SetObjectTypes(ObjectTypes(configs))
 	return &MultiConfigProcessor{
-		engine:  NewMappingEngine(apiURL, storeID, modelID),
+		engine:  engine,
 		configs: configs,
 	}
 }
@@ -29,15 +33,7 @@ func (mcp *MultiConfigProcessor) ProcessEvent(ctx context.Context, event map[str
 	}
 
 	// Find all configurations that handle this event type
-	var applicableConfigs []*types.MappingConfig
-	for _, config := range mcp.configs {
-		for _, eventMapping := range config.Events {
-			if eventMapping.Type == eventType {
-				applicableConfigs = append(applicableConfigs, config)
-				break
-			}
-		}
-	}
+	applicableConfigs := ConfigsForEvent(mcp.configs, eventType)
 
 	if len(applicableConfigs) == 0 {
 		return fmt.Errorf("no configuration found for event type: %s", eventType)
@@ -53,12 +49,13 @@ func (mcp *MultiConfigProcessor) ProcessEvent(ctx context.Context, event map[str
 	return nil
 }
 
-// AddConfig adds a new mapping configuration
-func (mcp *MultiConfigProcessor) AddConfig(config *types.MappingConfig) {
+// AddConfig adds a new compiled mapping configuration
+func (mcp *MultiConfigProcessor) AddConfig(config *CompiledMappingConfig) {
 	mcp.configs = append(mcp.configs, config)
+	mcp.engine.SetObjectTypes(ObjectTypes(mcp.configs))
 }
 
 // GetConfigs returns all loaded configurations  
-func (mcp *MultiConfigProcessor) GetConfigs() []*types.MappingConfig {
+func (mcp *MultiConfigProcessor) GetConfigs() []*CompiledMappingConfig {
 	return mcp.configs
 }
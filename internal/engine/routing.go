@@ -0,0 +1,43 @@
+package engine
+
+import (
+	"path"
+
+	"mapping-engine/internal/types"
+)
+
+// eventAction returns the action a mapping configuration declares for an event type.
+// Event types in the configuration may use wildcards, e.g. "organization.connection.*";
+// an exact match takes precedence over a wildcard one.
+func eventAction(config *types.MappingConfig, eventType string) (string, bool) {
+	for _, eventMapping := range config.Events {
+		if eventMapping.Type == eventType {
+			return eventMapping.Action, true
+		}
+	}
+
+	for _, eventMapping := range config.Events {
+		if matched, err := path.Match(eventMapping.Type, eventType); err == nil && matched {
+			return eventMapping.Action, true
+		}
+	}
+
+	return "", false
+}
+
+// HandlesEvent reports whether a mapping configuration declares an action for the event type
+func HandlesEvent(config *types.MappingConfig, eventType string) bool {
+	_, ok := eventAction(config, eventType)
+	return ok
+}
+
+// ConfigsForEvent returns the mapping configurations that declare an action for the event type, in order
+func ConfigsForEvent(configs []*CompiledMappingConfig, eventType string) []*CompiledMappingConfig {
+	var matching []*CompiledMappingConfig
+	for _, config := range configs {
+		if HandlesEvent(config.MappingConfig, eventType) {
+			matching = append(matching, config)
+		}
+	}
+	return matching
+}
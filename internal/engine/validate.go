@@ -0,0 +1,97 @@
+package engine
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"mapping-engine/internal/types"
+)
+
+// OpenFGA limits on the length of tuple fields, in bytes
+const (
+	maxUserLength     = 512
+	maxRelationLength = 50
+	maxObjectLength   = 256
+	maxTypeLength     = 254
+)
+
+// Tuple field syntax accepted by OpenFGA
+var (
+	objectPattern   = regexp.MustCompile(`^[^:#@\s]+:[^#:\s]+$`)             // type:id
+	userPattern     = regexp.MustCompile(`^[^:#@\s]+:([^#:\s]+|\*)$`)        // type:id or type:*
+	usersetPattern  = regexp.MustCompile(`^[^:#@\s]+:[^#:*\s]+#[^:#@*\s]+$`) // type:id#relation
+	relationPattern = regexp.MustCompile(`^[^:#@*\s]+$`)
+)
+
+// MappingError reports a mapping whose tuple was not written for an event
+type MappingError struct {
+	Mapping string                // Mapping that failed, e.g. "configs/user-mappings.yaml:12: mappings[2]"
+	Tuple   *types.ProcessedTuple // Rejected tuple; nil when the mapping failed before producing one
+	Err     error
+
+	index int // Index of the mapping in its configuration
+}
+
+func (e MappingError) Error() string {
+	if e.Tuple != nil {
+		return fmt.Sprintf("%s: tuple %s %s %s: %v", e.Mapping, e.Tuple.User, e.Tuple.Relation, e.Tuple.Object, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Mapping, e.Err)
+}
+
+func (e MappingError) Unwrap() error {
+	return e.Err
+}
+
+// ValidateTuple checks that a tuple is well-formed for OpenFGA: the object is "type:id", the user
+// is "type:id", "type:*" or "type:id#relation", relations contain no separators, and every field
+// is within OpenFGA's length limits
+func ValidateTuple(tuple types.ProcessedTuple) error {
+	if err := validateField("user", tuple.User, maxUserLength); err != nil {
+		return err
+	}
+	if !userPattern.MatchString(tuple.User) && !usersetPattern.MatchString(tuple.User) {
+		return fmt.Errorf("user %q is not of the form type:id, type:* or type:id#relation", tuple.User)
+	}
+
+	if err := validateField("relation", tuple.Relation, maxRelationLength); err != nil {
+		return err
+	}
+	if !relationPattern.MatchString(tuple.Relation) {
+		return fmt.Errorf("relation %q must not contain whitespace or any of : # @ *", tuple.Relation)
+	}
+
+	if err := validateField("object", tuple.Object, maxObjectLength); err != nil {
+		return err
+	}
+	if !objectPattern.MatchString(tuple.Object) {
+		return fmt.Errorf("object %q is not of the form type:id", tuple.Object)
+	}
+	if strings.HasSuffix(tuple.Object, ":*") {
+		return fmt.Errorf("object cannot be a wildcard: %q", tuple.Object)
+	}
+
+	for _, value := range []string{tuple.User, tuple.Object} {
+		if objectType := value[:strings.Index(value, ":")]; len(objectType) > maxTypeLength {
+			return fmt.Errorf("type %q is longer than %d bytes", objectType, maxTypeLength)
+		}
+	}
+
+	if tuple.Condition != nil && tuple.Condition.Name == "" {
+		return fmt.Errorf("condition name is empty")
+	}
+
+	return nil
+}
+
+// validateField checks that a tuple field is set and within its length limit
+func validateField(name, value string, maxLength int) error {
+	if value == "" {
+		return fmt.Errorf("%s is empty", name)
+	}
+	if len(value) > maxLength {
+		return fmt.Errorf("%s is %d bytes long; OpenFGA allows at most %d", name, len(value), maxLength)
+	}
+	return nil
+}
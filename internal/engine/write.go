@@ -0,0 +1,270 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"mapping-engine/internal/types"
+)
+
+// WriteMode selects how a change with more tuples than fit in one OpenFGA write is applied
+type WriteMode string
+
+const (
+	// WriteTransactional applies the chunks one after another and, when one fails, reverts the
+	// chunks already applied, so the change is applied completely or not at all. The tuples of
+	// every chunk are read before it is applied, so that only what it changed is reverted.
+	WriteTransactional WriteMode = "transactional"
+
+	// WriteParallel applies the chunks concurrently, deletes before writes. A failed chunk does not
+	// affect the others; the event fails and is retried.
+	WriteParallel WriteMode = "parallel"
+)
+
+// Defaults of WriteOptions
+const (
+	DefaultMaxTuplesPerWrite   = 100 // OpenFGA's default limit on the tuples changed by one write
+	DefaultMaxParallelRequests = 10
+)
+
+// WriteOptions configures how changes are split into writes to the tuple store
+type WriteOptions struct {
+	Mode                WriteMode // Defaults to WriteTransactional
+	MaxTuplesPerWrite   int       // Writes plus deletes per request; must not exceed the server's limit
+	MaxParallelRequests int       // Chunks written at once in WriteParallel mode
+}
+
+// withDefaults returns the options with unset fields set to their defaults
+func (o WriteOptions) withDefaults() WriteOptions {
+	if o.Mode == "" {
+		o.Mode = WriteTransactional
+	}
+	if o.MaxTuplesPerWrite <= 0 {
+		o.MaxTuplesPerWrite = DefaultMaxTuplesPerWrite
+	}
+	if o.MaxParallelRequests <= 0 {
+		o.MaxParallelRequests = DefaultMaxParallelRequests
+	}
+	return o
+}
+
+// ChunkResult reports one write request of a change
+type ChunkResult struct {
+	Writes      int    `json:"writes"`
+	Deletes     int    `json:"deletes"`
+	Error       string `json:"error,omitempty"`
+	Reverted    bool   `json:"reverted,omitempty"` // Applied, then reverted because a later chunk failed
+	RevertError string `json:"revert_error,omitempty"`
+
+	err error
+}
+
+// WriteError reports a change of which some chunks failed to apply
+type WriteError struct {
+	Mode   WriteMode
+	Chunks []ChunkResult // Every chunk of the change, in order
+}
+
+func (e *WriteError) Error() string {
+	failed := 0
+	var first error
+	for _, chunk := range e.Chunks {
+		if chunk.err != nil {
+			failed++
+			if first == nil {
+				first = chunk.err
+			}
+		}
+	}
+
+	message := fmt.Sprintf("%d of %d chunks failed", failed, len(e.Chunks))
+	if e.Mode == WriteTransactional && len(e.Chunks) > 1 {
+		message += ", change reverted"
+	}
+	return fmt.Sprintf("%s: %v", message, first)
+}
+
+// Unwrap returns the errors of the failed chunks, so that retry.IsRetryable can classify them
+func (e *WriteError) Unwrap() []error {
+	var errs []error
+	for _, chunk := range e.Chunks {
+		if chunk.err != nil {
+			errs = append(errs, chunk.err)
+		}
+	}
+	return errs
+}
+
+// tupleChunk is the part of a change applied by one write request
+type tupleChunk struct {
+	writes  []types.ProcessedTuple
+	deletes []types.ProcessedTuple
+}
+
+// SetWriteOptions sets how changes are split into writes; see WriteOptions for the defaults
+func (me *MappingEngine) SetWriteOptions(options WriteOptions) {
+	me.writeOptions = options
+}
+
+// writeTuples applies a change to the tuple store in chunks of at most MaxTuplesPerWrite tuples
+// and returns the result of each chunk. If a chunk fails, the error is a *WriteError.
+func (me *MappingEngine) writeTuples(ctx context.Context, writes, deletes []types.ProcessedTuple) ([]ChunkResult, error) {
+	options := me.writeOptions.withDefaults()
+
+	var results []ChunkResult
+	if options.Mode == WriteParallel {
+		// A tuple may be deleted and written again with a new condition, so deletes go first
+		results = me.writeParallel(ctx, splitChunks(nil, deletes, options.MaxTuplesPerWrite), options.MaxParallelRequests)
+		results = append(results, me.writeParallel(ctx, splitChunks(writes, nil, options.MaxTuplesPerWrite), options.MaxParallelRequests)...)
+	} else {
+		results = me.writeTransactional(ctx, splitChunks(writes, deletes, options.MaxTuplesPerWrite))
+	}
+
+	for _, result := range results {
+		if result.err != nil {
+			if len(results) == 1 {
+				return nil, result.err
+			}
+			return nil, &WriteError{Mode: options.Mode, Chunks: results}
+		}
+	}
+	return results, nil
+}
+
+// writeTransactional applies chunks in order. When one fails, the chunks already applied are
+// reverted in reverse order by deleting the tuples they wrote and writing back the tuples they
+// deleted. Tuples that already existed, or were already deleted, are left as they were.
+func (me *MappingEngine) writeTransactional(ctx context.Context, chunks []tupleChunk) []ChunkResult {
+	results := make([]ChunkResult, len(chunks))
+	for i, chunk := range chunks {
+		results[i] = ChunkResult{Writes: len(chunk.writes), Deletes: len(chunk.deletes)}
+	}
+
+	applied := make([]tupleChunk, len(chunks))
+	for i, chunk := range chunks {
+		// A single chunk is never reverted, so its tuples need not be read
+		var err error
+		if len(chunks) > 1 {
+			applied[i], err = me.chunkChanges(ctx, chunk)
+		}
+		if err == nil {
+			err = me.store.Write(ctx, chunk.writes, chunk.deletes)
+		}
+
+		if err != nil {
+			results[i].Error, results[i].err = err.Error(), err
+
+			for j := i - 1; j >= 0; j-- {
+				results[j].Reverted = true
+				if err := me.store.Write(ctx, applied[j].deletes, applied[j].writes); err != nil {
+					results[j].RevertError = err.Error()
+					me.log().ErrorContext(ctx, "Failed to revert applied chunk; OpenFGA holds a partial change", "chunk", j, "writes", len(applied[j].writes), "deletes", len(applied[j].deletes), "error", err)
+				}
+			}
+			break
+		}
+	}
+
+	return results
+}
+
+// chunkChanges returns what applying a chunk will change: the writes of tuples that do not exist
+// yet, and the deletes of tuples that do, as they are stored before the chunk is applied
+func (me *MappingEngine) chunkChanges(ctx context.Context, chunk tupleChunk) (tupleChunk, error) {
+	var changes tupleChunk
+
+	deleted := make(map[string]bool, len(chunk.deletes))
+	for _, tuple := range chunk.deletes {
+		existing, err := me.readTuple(ctx, tuple)
+		if err != nil {
+			return tupleChunk{}, err
+		}
+		if existing != nil {
+			deleted[tupleKey(tuple)] = true
+			changes.deletes = append(changes.deletes, *existing)
+		}
+	}
+
+	for _, tuple := range chunk.writes {
+		// A tuple deleted by the same chunk is written again, with its new condition
+		if deleted[tupleKey(tuple)] {
+			changes.writes = append(changes.writes, tuple)
+			continue
+		}
+
+		existing, err := me.readTuple(ctx, tuple)
+		if err != nil {
+			return tupleChunk{}, err
+		}
+		if existing == nil {
+			changes.writes = append(changes.writes, tuple)
+		}
+	}
+
+	return changes, nil
+}
+
+// readTuple returns the stored tuple with the same user, relation and object, or nil if there is none
+func (me *MappingEngine) readTuple(ctx context.Context, tuple types.ProcessedTuple) (*types.ProcessedTuple, error) {
+	tuples, err := me.readTuples(ctx, readFilter{User: tuple.User, Relation: tuple.Relation, Object: tuple.Object})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tuple state before writing: %w", err)
+	}
+
+	for _, existing := range tuples {
+		if tupleKey(existing) == tupleKey(tuple) {
+			return &existing, nil
+		}
+	}
+	return nil, nil
+}
+
+// tupleKey identifies a tuple by its user, relation and object, regardless of its condition
+func tupleKey(tuple types.ProcessedTuple) string {
+	return fmt.Sprintf("%s#%s#%s", tuple.User, tuple.Relation, tuple.Object)
+}
+
+// writeParallel applies chunks concurrently, at most maxParallel at a time
+func (me *MappingEngine) writeParallel(ctx context.Context, chunks []tupleChunk, maxParallel int) []ChunkResult {
+	results := make([]ChunkResult, len(chunks))
+	semaphore := make(chan struct{}, maxParallel)
+
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		results[i] = ChunkResult{Writes: len(chunk.writes), Deletes: len(chunk.deletes)}
+
+		wg.Add(1)
+		semaphore <- struct{}{}
+		go func(i int, chunk tupleChunk) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+
+			if err := me.store.Write(ctx, chunk.writes, chunk.deletes); err != nil {
+				results[i].Error, results[i].err = err.Error(), err
+			}
+		}(i, chunk)
+	}
+	wg.Wait()
+
+	return results
+}
+
+// splitChunks splits a change into chunks of at most size tuples, deletes before writes
+func splitChunks(writes, deletes []types.ProcessedTuple, size int) []tupleChunk {
+	var chunks []tupleChunk
+	for len(writes) > 0 || len(deletes) > 0 {
+		var chunk tupleChunk
+
+		n := min(size, len(deletes))
+		chunk.deletes, deletes = deletes[:n], deletes[n:]
+
+		n = min(size-len(chunk.deletes), len(writes))
+		chunk.writes, writes = writes[:n], writes[n:]
+
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
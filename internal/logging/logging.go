@@ -0,0 +1,136 @@
+// Package logging builds the structured loggers of the service, engine and CLI. Loggers created
+// by New tag every record logged with a *Context method with the correlation attributes of the
+// context, such as the request ID and the Auth0 event ID and type, and with the active trace ID.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"strings"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Formats accepted by New
+const (
+	FormatJSON = "json"
+	FormatText = "text"
+)
+
+// Correlation attribute keys
+const (
+	KeyRequestID = "request_id"
+	KeyEventID   = "event_id"
+	KeyEventType = "event_type"
+	KeyTraceID   = "trace_id"
+)
+
+// New creates a logger writing records at level ("debug", "info", "warn" or "error") and above to
+// w in format, which is FormatJSON or FormatText
+func New(w io.Writer, level, format string) (*slog.Logger, error) {
+	var minLevel slog.Level
+	if err := minLevel.UnmarshalText([]byte(level)); err != nil {
+		return nil, fmt.Errorf("unknown log level %q", level)
+	}
+
+	options := &slog.HandlerOptions{Level: minLevel}
+	var handler slog.Handler
+	switch strings.ToLower(format) {
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, options)
+	case FormatText:
+		handler = slog.NewTextHandler(w, options)
+	default:
+		return nil, fmt.Errorf("unknown log format %q", format)
+	}
+
+	return slog.New(NewContextHandler(handler)), nil
+}
+
+// discard drops every record without formatting it, as its handler is enabled for no level
+var discard = slog.New(slog.NewTextHandler(io.Discard, &slog.HandlerOptions{Level: slog.Level(math.MaxInt)}))
+
+// Discard returns a logger that drops every record
+func Discard() *slog.Logger {
+	return discard
+}
+
+// OrDiscard returns logger, or a logger that drops every record when logger is nil
+func OrDiscard(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return Discard()
+	}
+	return logger
+}
+
+type attrsKey struct{}
+
+// WithAttrs returns ctx carrying correlation attributes for the records logged with it. An
+// attribute replaces one of the same key already carried by ctx.
+func WithAttrs(ctx context.Context, attrs ...slog.Attr) context.Context {
+	existing, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	merged := make([]slog.Attr, 0, len(existing)+len(attrs))
+	for _, attr := range existing {
+		if !hasKey(attrs, attr.Key) {
+			merged = append(merged, attr)
+		}
+	}
+	return context.WithValue(ctx, attrsKey{}, append(merged, attrs...))
+}
+
+// Attrs returns the correlation attributes carried by ctx
+func Attrs(ctx context.Context) []slog.Attr {
+	attrs, _ := ctx.Value(attrsKey{}).([]slog.Attr)
+	return attrs
+}
+
+// EventAttrs returns the correlation attributes of an Auth0 event; a missing ID or type is left out
+func EventAttrs(event map[string]interface{}) []slog.Attr {
+	var attrs []slog.Attr
+	if eventID, ok := event["id"].(string); ok && eventID != "" {
+		attrs = append(attrs, slog.String(KeyEventID, eventID))
+	}
+	if eventType, ok := event["type"].(string); ok && eventType != "" {
+		attrs = append(attrs, slog.String(KeyEventType, eventType))
+	}
+	return attrs
+}
+
+func hasKey(attrs []slog.Attr, key string) bool {
+	for _, attr := range attrs {
+		if attr.Key == key {
+			return true
+		}
+	}
+	return false
+}
+
+// ContextHandler adds the correlation attributes of the context, and the ID of its active trace,
+// to every record
+type ContextHandler struct {
+	slog.Handler
+}
+
+// NewContextHandler wraps handler to add the correlation attributes of the context to every record
+func NewContextHandler(handler slog.Handler) *ContextHandler {
+	return &ContextHandler{Handler: handler}
+}
+
+func (h *ContextHandler) Handle(ctx context.Context, record slog.Record) error {
+	record.AddAttrs(Attrs(ctx)...)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.HasTraceID() {
+		record.AddAttrs(slog.String(KeyTraceID, spanContext.TraceID().String()))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *ContextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithAttrs(attrs)}
+}
+
+func (h *ContextHandler) WithGroup(name string) slog.Handler {
+	return &ContextHandler{Handler: h.Handler.WithGroup(name)}
+}
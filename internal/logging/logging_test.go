@@ -0,0 +1,51 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+func TestNew(t *testing.T) {
+	var output bytes.Buffer
+	logger, err := New(&output, "info", FormatJSON)
+	require.NoError(t, err)
+
+	ctx := WithAttrs(context.Background(), slog.String(KeyRequestID, "req-1"), slog.String(KeyEventID, "evt_1"))
+	ctx = WithAttrs(ctx, EventAttrs(map[string]interface{}{"id": "evt_2", "type": "user.created"})...)
+	ctx, span := sdktrace.NewTracerProvider().Tracer("test").Start(ctx, "test")
+	defer span.End()
+
+	logger.DebugContext(ctx, "Dropped below the level")
+	logger.InfoContext(ctx, "Processed event", "tuples_added", 2)
+
+	var record map[string]interface{}
+	require.NoError(t, json.Unmarshal(output.Bytes(), &record))
+	assert.Equal(t, "Processed event", record["msg"])
+	assert.Equal(t, "req-1", record[KeyRequestID])
+	assert.Equal(t, "evt_2", record[KeyEventID]) // Replaced by the later attribute
+	assert.Equal(t, "user.created", record[KeyEventType])
+	assert.Equal(t, span.SpanContext().TraceID().String(), record[KeyTraceID])
+	assert.Equal(t, float64(2), record["tuples_added"])
+}
+
+func TestNew_Errors(t *testing.T) {
+	_, err := New(&bytes.Buffer{}, "verbose", FormatJSON)
+	assert.ErrorContains(t, err, `unknown log level "verbose"`)
+
+	_, err = New(&bytes.Buffer{}, "info", "logfmt")
+	assert.ErrorContains(t, err, `unknown log format "logfmt"`)
+}
+
+func TestOrDiscard(t *testing.T) {
+	assert.NotPanics(t, func() {
+		OrDiscard(nil).Error("Dropped")
+	})
+	assert.False(t, Discard().Enabled(context.Background(), slog.LevelError))
+}
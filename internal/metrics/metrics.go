@@ -0,0 +1,251 @@
+// Package metrics exposes the Prometheus metrics of the webhook service.
+// Metric and label names are relied on by dashboards and alerts, so they must not change.
+package metrics
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/types"
+)
+
+const namespace = "mapping_engine"
+
+// Reasons an event is ignored, as the reason label of mapping_engine_events_ignored_total
+const (
+	ReasonUnmapped  = "unmapped"  // No mapping configuration declares the event type
+	ReasonDuplicate = "duplicate" // An event with the same ID was already processed
+	ReasonStale     = "stale"     // The event is older than the latest event applied to its entity
+)
+
+// Results of a mapping configuration reload, as the result label of mapping_engine_mapping_config_reloads_total
+const (
+	ReloadSuccess   = "success"   // A changed set of mapping configurations was activated
+	ReloadUnchanged = "unchanged" // The mapping files did not change since the active version
+	ReloadFailure   = "failure"   // The mapping files were rejected and the active version was kept
+)
+
+// Metrics holds the service's collectors. All methods are safe to call on a nil *Metrics, which records nothing.
+type Metrics struct {
+	registry *prometheus.Registry
+
+	eventsReceived    *prometheus.CounterVec
+	eventsProcessed   *prometheus.CounterVec
+	eventsFailed      *prometheus.CounterVec
+	eventsIgnored     *prometheus.CounterVec
+	tuplesWritten     *prometheus.CounterVec
+	tuplesDeleted     *prometheus.CounterVec
+	openFGADuration   *prometheus.HistogramVec
+	signatureFailures prometheus.Counter
+	mappingConfig     *prometheus.GaugeVec
+	mappingReloads    *prometheus.CounterVec
+}
+
+// New creates the service metrics in a registry of their own, together with the Go runtime and process collectors
+func New() *Metrics {
+	m := &Metrics{
+		registry: prometheus.NewRegistry(),
+
+		eventsReceived: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_received_total",
+			Help:      "Webhook events received, by event type.",
+		}, []string{"type"}),
+		eventsProcessed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_processed_total",
+			Help:      "Events applied to OpenFGA, by event type.",
+		}, []string{"type"}),
+		eventsFailed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_failed_total",
+			Help:      "Events that failed permanently or ran out of retries, by event type.",
+		}, []string{"type"}),
+		eventsIgnored: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "events_ignored_total",
+			Help:      "Events skipped without changes, by event type and reason (unmapped, duplicate, stale).",
+		}, []string{"type", "reason"}),
+		tuplesWritten: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tuples_written_total",
+			Help:      "Tuples written to OpenFGA, by relation.",
+		}, []string{"relation"}),
+		tuplesDeleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "tuples_deleted_total",
+			Help:      "Tuples deleted from OpenFGA, by relation.",
+		}, []string{"relation"}),
+		openFGADuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "openfga_request_duration_seconds",
+			Help:      "Latency of OpenFGA requests, by operation (read, write) and result (success, error).",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"operation", "result"}),
+		signatureFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "webhook_signature_failures_total",
+			Help:      "Webhook requests rejected for a missing or invalid signature.",
+		}),
+		mappingConfig: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "mapping_config_info",
+			Help:      "Version and hash of the active set of mapping configurations; the value is always 1.",
+		}, []string{"version", "hash"}),
+		mappingReloads: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "mapping_config_reloads_total",
+			Help:      "Reloads of the mapping configurations, by result (success, unchanged, failure).",
+		}, []string{"result"}),
+	}
+
+	m.registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		m.eventsReceived,
+		m.eventsProcessed,
+		m.eventsFailed,
+		m.eventsIgnored,
+		m.tuplesWritten,
+		m.tuplesDeleted,
+		m.openFGADuration,
+		m.signatureFailures,
+		m.mappingConfig,
+		m.mappingReloads,
+	)
+
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// RegisterQueueDepth exposes the number of events waiting in the durable queue as mapping_engine_queue_depth
+func (m *Metrics) RegisterQueueDepth(depth func() int) {
+	if m == nil {
+		return
+	}
+	m.registry.MustRegister(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "queue_depth",
+		Help:      "Events accepted but not yet processed.",
+	}, func() float64 { return float64(depth()) }))
+}
+
+// EventReceived counts a webhook event
+func (m *Metrics) EventReceived(eventType string) {
+	if m != nil {
+		m.eventsReceived.WithLabelValues(eventType).Inc()
+	}
+}
+
+// EventProcessed counts an event applied to OpenFGA
+func (m *Metrics) EventProcessed(eventType string) {
+	if m != nil {
+		m.eventsProcessed.WithLabelValues(eventType).Inc()
+	}
+}
+
+// EventFailed counts an event that could not be processed
+func (m *Metrics) EventFailed(eventType string) {
+	if m != nil {
+		m.eventsFailed.WithLabelValues(eventType).Inc()
+	}
+}
+
+// EventIgnored counts an event skipped for one of the Reason constants
+func (m *Metrics) EventIgnored(eventType, reason string) {
+	if m != nil {
+		m.eventsIgnored.WithLabelValues(eventType, reason).Inc()
+	}
+}
+
+// TuplesChanged counts tuples written and deleted by relation. Relations not in known, such as
+// relations rendered from event data, are counted as "other" so they can't create unbounded label values.
+func (m *Metrics) TuplesChanged(written, deleted []types.ProcessedTuple, known map[string]bool) {
+	if m == nil {
+		return
+	}
+	for _, tuple := range written {
+		m.tuplesWritten.WithLabelValues(relationLabel(tuple.Relation, known)).Inc()
+	}
+	for _, tuple := range deleted {
+		m.tuplesDeleted.WithLabelValues(relationLabel(tuple.Relation, known)).Inc()
+	}
+}
+
+// relationLabel returns the relation to label tuple metrics with
+func relationLabel(relation string, known map[string]bool) string {
+	if !known[relation] {
+		return "other"
+	}
+	return relation
+}
+
+// SignatureFailure counts a webhook request rejected for its signature
+func (m *Metrics) SignatureFailure() {
+	if m != nil {
+		m.signatureFailures.Inc()
+	}
+}
+
+// MappingConfigActivated records the version and hash of the mapping configurations now active
+func (m *Metrics) MappingConfigActivated(version int, hash string) {
+	if m == nil {
+		return
+	}
+	m.mappingConfig.Reset()
+	m.mappingConfig.WithLabelValues(strconv.Itoa(version), hash).Set(1)
+}
+
+// MappingConfigReloaded counts a reload of the mapping configurations with one of the Reload constants
+func (m *Metrics) MappingConfigReloaded(result string) {
+	if m != nil {
+		m.mappingReloads.WithLabelValues(result).Inc()
+	}
+}
+
+// InstrumentStore returns a tuple store that records the latency of every Read and Write of tupleStore
+func (m *Metrics) InstrumentStore(tupleStore store.TupleStore) store.TupleStore {
+	if m == nil {
+		return tupleStore
+	}
+	return &instrumentedStore{TupleStore: tupleStore, duration: m.openFGADuration}
+}
+
+// instrumentedStore times the requests of a tuple store
+type instrumentedStore struct {
+	store.TupleStore
+	duration *prometheus.HistogramVec
+}
+
+func (s *instrumentedStore) Read(ctx context.Context, filter store.Filter, continuationToken string) (*store.ReadPage, error) {
+	start := time.Now()
+	page, err := s.TupleStore.Read(ctx, filter, continuationToken)
+	s.observe("read", start, err)
+	return page, err
+}
+
+func (s *instrumentedStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	start := time.Now()
+	err := s.TupleStore.Write(ctx, writes, deletes)
+	s.observe("write", start, err)
+	return err
+}
+
+func (s *instrumentedStore) observe(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	s.duration.WithLabelValues(operation, result).Observe(time.Since(start).Seconds())
+}
@@ -0,0 +1,98 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/types"
+)
+
+// failingStore rejects every write
+type failingStore struct {
+	*store.MemoryStore
+}
+
+func (fs failingStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	return errors.New("openfga unavailable")
+}
+
+func scrape(t *testing.T, m *Metrics) string {
+	rr := httptest.NewRecorder()
+	m.Handler().ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rr.Code)
+	return rr.Body.String()
+}
+
+func TestInstrumentStore(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	tuple := types.ProcessedTuple{User: "user:1", Relation: "member", Object: "group:1"}
+
+	memoryStore := m.InstrumentStore(store.NewMemoryStore())
+	require.NoError(t, memoryStore.Write(ctx, []types.ProcessedTuple{tuple}, nil))
+	_, err := memoryStore.Read(ctx, store.Filter{User: tuple.User}, "")
+	require.NoError(t, err)
+
+	assert.Error(t, m.InstrumentStore(failingStore{store.NewMemoryStore()}).Write(ctx, nil, []types.ProcessedTuple{tuple}))
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `mapping_engine_openfga_request_duration_seconds_count{operation="read",result="success"} 1`)
+	assert.Contains(t, body, `mapping_engine_openfga_request_duration_seconds_count{operation="write",result="success"} 1`)
+	assert.Contains(t, body, `mapping_engine_openfga_request_duration_seconds_count{operation="write",result="error"} 1`)
+}
+
+func TestTuplesChanged(t *testing.T) {
+	m := New()
+	m.TuplesChanged(
+		[]types.ProcessedTuple{{Relation: "member"}, {Relation: "member"}, {Relation: "admin"}, {Relation: "role_1a2b"}},
+		[]types.ProcessedTuple{{Relation: "member"}, {Relation: "role_3c4d"}},
+		map[string]bool{"member": true, "admin": true},
+	)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `mapping_engine_tuples_written_total{relation="member"} 2`)
+	assert.Contains(t, body, `mapping_engine_tuples_written_total{relation="admin"} 1`)
+	assert.Contains(t, body, `mapping_engine_tuples_deleted_total{relation="member"} 1`)
+	assert.Contains(t, body, `mapping_engine_tuples_written_total{relation="other"} 1`)
+	assert.Contains(t, body, `mapping_engine_tuples_deleted_total{relation="other"} 1`)
+	assert.NotContains(t, body, "role_")
+}
+
+func TestMappingConfig(t *testing.T) {
+	m := New()
+	m.MappingConfigActivated(1, "0123456789ab")
+	m.MappingConfigActivated(2, "ba9876543210")
+	m.MappingConfigReloaded(ReloadSuccess)
+	m.MappingConfigReloaded(ReloadFailure)
+
+	body := scrape(t, m)
+	assert.Contains(t, body, `mapping_engine_mapping_config_info{hash="ba9876543210",version="2"} 1`)
+	assert.NotContains(t, body, `version="1"`, "only the active version is reported")
+	assert.Contains(t, body, `mapping_engine_mapping_config_reloads_total{result="success"} 1`)
+	assert.Contains(t, body, `mapping_engine_mapping_config_reloads_total{result="failure"} 1`)
+}
+
+func TestNilMetrics(t *testing.T) {
+	var m *Metrics
+	memoryStore := store.NewMemoryStore()
+
+	assert.NotPanics(t, func() {
+		m.EventReceived("user.created")
+		m.EventProcessed("user.created")
+		m.EventFailed("user.created")
+		m.EventIgnored("user.created", ReasonDuplicate)
+		m.TuplesChanged([]types.ProcessedTuple{{Relation: "member"}}, nil, nil)
+		m.SignatureFailure()
+		m.RegisterQueueDepth(func() int { return 0 })
+		m.MappingConfigActivated(1, "0123456789ab")
+		m.MappingConfigReloaded(ReloadSuccess)
+	})
+	assert.Same(t, memoryStore, m.InstrumentStore(memoryStore))
+}
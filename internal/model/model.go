@@ -0,0 +1,99 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+// Model is an OpenFGA authorization model in its JSON form, as in configs/model.json
+type Model struct {
+	SchemaVersion   string           `json:"schema_version"`
+	TypeDefinitions []TypeDefinition `json:"type_definitions"`
+}
+
+// TypeDefinition declares an object type and its relations
+type TypeDefinition struct {
+	Type      string                     `json:"type"`
+	Relations map[string]json.RawMessage `json:"relations,omitempty"` // Rewrites are not interpreted, only the relation names
+	Metadata  *Metadata                  `json:"metadata,omitempty"`
+}
+
+// Metadata holds the type restrictions of a type's relations
+type Metadata struct {
+	Relations map[string]RelationMetadata `json:"relations,omitempty"`
+}
+
+// RelationMetadata lists the user types that may be written directly to a relation
+type RelationMetadata struct {
+	DirectlyRelatedUserTypes []RelationReference `json:"directly_related_user_types,omitempty"`
+}
+
+// RelationReference is one allowed user type: a plain type ("user"), a wildcard ("user:*")
+// or a userset ("group#member")
+type RelationReference struct {
+	Type      string    `json:"type"`
+	Relation  string    `json:"relation,omitempty"`
+	Wildcard  *struct{} `json:"wildcard,omitempty"`
+	Condition string    `json:"condition,omitempty"`
+}
+
+// Load reads an authorization model from a JSON file
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read authorization model: %w", err)
+	}
+
+	m, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse authorization model %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// Parse decodes an authorization model from JSON
+func Parse(data []byte) (*Model, error) {
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	if len(m.TypeDefinitions) == 0 {
+		return nil, fmt.Errorf("model declares no types")
+	}
+	return &m, nil
+}
+
+// typeDefinition returns the definition of an object type, or nil if the model does not declare it
+func (m *Model) typeDefinition(objectType string) *TypeDefinition {
+	for i := range m.TypeDefinitions {
+		if m.TypeDefinitions[i].Type == objectType {
+			return &m.TypeDefinitions[i]
+		}
+	}
+	return nil
+}
+
+// HasType reports whether the model declares the type
+func (m *Model) HasType(objectType string) bool {
+	return m.typeDefinition(objectType) != nil
+}
+
+// HasRelation reports whether the model declares the relation on the type
+func (m *Model) HasRelation(objectType, relation string) bool {
+	td := m.typeDefinition(objectType)
+	if td == nil {
+		return false
+	}
+	_, ok := td.Relations[relation]
+	return ok
+}
+
+// DirectlyRelatedUserTypes returns the user types that may be written to a relation of a type
+func (m *Model) DirectlyRelatedUserTypes(objectType, relation string) []RelationReference {
+	td := m.typeDefinition(objectType)
+	if td == nil || td.Metadata == nil {
+		return nil
+	}
+	return td.Metadata.Relations[relation].DirectlyRelatedUserTypes
+}
@@ -0,0 +1,151 @@
+package model
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mapping-engine/internal/config"
+	"mapping-engine/internal/types"
+)
+
+const testModel = `{
+  "schema_version": "1.1",
+  "type_definitions": [
+    {"type": "user"},
+    {"type": "group", "relations": {"member": {"this": {}}}, "metadata": {"relations": {
+      "member": {"directly_related_user_types": [{"type": "user"}, {"type": "user", "wildcard": {}}]}
+    }}},
+    {"type": "folder", "relations": {"viewer": {"this": {}}, "can_view": {"computedUserset": {"relation": "viewer"}}}, "metadata": {"relations": {
+      "viewer": {"directly_related_user_types": [{"type": "user"}, {"type": "group", "relation": "member"}, {"type": "user", "condition": "not_expired"}]}
+    }}}
+  ]
+}`
+
+func writeMappingFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "test-mappings.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestValidate_ShippedConfigs(t *testing.T) {
+	m, err := Load("../../configs/model.json")
+	require.NoError(t, err)
+
+	configs, err := config.LoadMappingConfigPaths([]string{"../../configs/*-mappings.yaml"})
+	require.NoError(t, err)
+
+	assert.NoError(t, m.Validate(configs))
+}
+
+func TestValidate(t *testing.T) {
+	m, err := Parse([]byte(testModel))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		tuple string
+		err   string
+	}{
+		{"plain user", `{user: "user:{{ .id }}", relation: viewer, object: "folder:{{ .folder }}"}`, ""},
+		{"userset", `{user: "group:{{ .group }}#member", relation: viewer, object: "folder:{{ .folder }}"}`, ""},
+		{"wildcard", `{user: "user:*", relation: member, object: "group:{{ .group }}"}`, ""},
+		{"templated relation", `{user: "user:{{ .id }}", relation: "{{ .relation }}", object: "folder:{{ .folder }}"}`, ""},
+		{"templated object type", `{user: "user:{{ .id }}", relation: viewer, object: "{{ .type }}:{{ .id }}"}`, ""},
+		{"unknown object type", `{user: "user:{{ .id }}", relation: viewer, object: "document:{{ .id }}"}`,
+			`:12: mappings[0].tuple.object: type "document" is not defined in the authorization model`},
+		{"unknown user type", `{user: "usr:{{ .id }}", relation: viewer, object: "folder:{{ .id }}"}`,
+			`:12: mappings[0].tuple.user: type "usr" is not defined in the authorization model`},
+		{"unknown relation", `{user: "user:{{ .id }}", relation: editor, object: "folder:{{ .id }}"}`,
+			`:12: mappings[0].tuple.relation: relation "editor" is not defined on type "folder"`},
+		{"computed relation", `{user: "user:{{ .id }}", relation: can_view, object: "folder:{{ .id }}"}`,
+			`relation "can_view" on type "folder" cannot be written directly`},
+		{"disallowed user type", `{user: "group:{{ .id }}", relation: viewer, object: "folder:{{ .id }}"}`,
+			`mappings[0].tuple.user: "group" may not be related to folder#viewer; allowed: [user, group#member, user with not_expired]`},
+		{"disallowed wildcard", `{user: "user:*", relation: viewer, object: "folder:{{ .id }}"}`,
+			`"user:*" may not be related to folder#viewer`},
+		{"conditional tuple", `{user: "user:{{ .id }}", relation: viewer, object: "folder:{{ .id }}", condition: {name: not_expired}}`, ""},
+		{"disallowed condition", `{user: "user:{{ .id }}", relation: member, object: "group:{{ .id }}", condition: {name: not_expired}}`,
+			`"user with not_expired" may not be related to group#member`},
+		{"disallowed userset", `{user: "group:{{ .id }}#owner", relation: viewer, object: "folder:{{ .id }}"}`,
+			`"group#owner" may not be related to folder#viewer`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeMappingFile(t, `entity:
+  type: user
+  id: "{{ .id }}"
+
+events:
+  - type: test.event
+    action: create
+
+mappings:
+  - condition: "true"
+    tuple:
+      `+tt.tuple+"\n")
+			cfg, err := config.LoadMappingConfig(path)
+			require.NoError(t, err)
+
+			err = m.Validate([]*types.MappingConfig{cfg})
+			if tt.err == "" {
+				assert.NoError(t, err)
+				return
+			}
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), tt.err)
+			assert.Contains(t, err.Error(), path)
+		})
+	}
+}
+
+func TestValidate_EntityAndOwns(t *testing.T) {
+	m, err := Parse([]byte(testModel))
+	require.NoError(t, err)
+
+	path := writeMappingFile(t, `entity:
+  type: account
+  id: "{{ .id }}"
+
+events:
+  - type: test.event
+
+mappings:
+  - tuple:
+      user: "user:{{ .id }}"
+      relation: viewer
+      object: "folder:{{ .folder }}"
+    owns:
+      user: "member:"
+      object: "directory:"
+`)
+	cfg, err := config.LoadMappingConfig(path)
+	require.NoError(t, err)
+
+	// Errors are reported in a stable order
+	for i := 0; i < 10; i++ {
+		err = m.Validate([]*types.MappingConfig{cfg})
+		require.Error(t, err)
+		lines := strings.Split(err.Error(), "\n")
+		require.Len(t, lines, 3)
+		assert.Contains(t, lines[0], `entity.type: type "account" is not defined`)
+		assert.Contains(t, lines[1], `mappings[0].owns.user: type "member" is not defined`)
+		assert.Contains(t, lines[2], `mappings[0].owns.object: type "directory" is not defined`)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	_, err := Parse([]byte(`{"schema_version": "1.1", "type_definitions": []}`))
+	assert.Error(t, err)
+
+	_, err = Parse([]byte(`not json`))
+	assert.Error(t, err)
+
+	_, err = Load(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
@@ -0,0 +1,171 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"mapping-engine/internal/types"
+)
+
+// Validate checks every tuple a set of mapping configurations can write against the model:
+// literal types must exist, literal relations must exist on the object type, and the user type
+// (or userset), with the tuple's condition if any, must be one of the relation's directly related user types.
+// Parts that are templated are only checked as far as they are literal.
+// All problems are returned together, each prefixed with the file and line it was found at.
+func (m *Model) Validate(configs []*types.MappingConfig) error {
+	var errs []error
+	for _, config := range configs {
+		errs = append(errs, m.validateConfig(config)...)
+	}
+	return errors.Join(errs...)
+}
+
+// validateConfig checks the entity, tuples and owned scopes of one mapping configuration
+func (m *Model) validateConfig(config *types.MappingConfig) []error {
+	var errs []error
+	report := func(path, format string, args ...interface{}) {
+		errs = append(errs, fmt.Errorf("%s: %s: %s", config.Position(path), path, fmt.Sprintf(format, args...)))
+	}
+
+	if config.Entity != nil && config.Entity.Type != "" && !m.HasType(config.Entity.Type) {
+		report("entity.type", "type %q is not defined in the authorization model", config.Entity.Type)
+	}
+
+	for i, mapping := range config.Mappings {
+		path := fmt.Sprintf("mappings[%d]", i)
+		m.validateTuple(path+".tuple", mapping.Tuple, report)
+
+		if mapping.Owns != nil {
+			owned := []struct{ field, value string }{{"user", mapping.Owns.User}, {"object", mapping.Owns.Object}}
+			for _, scope := range owned {
+				if objectType, ok := literalType(scope.value); ok && !m.HasType(objectType) {
+					report(path+".owns."+scope.field, "type %q is not defined in the authorization model", objectType)
+				}
+			}
+		}
+	}
+
+	return errs
+}
+
+// validateTuple checks a single tuple definition
+func (m *Model) validateTuple(path string, tuple types.TupleDefinition, report func(path, format string, args ...interface{})) {
+	objectType, objectKnown := literalType(tuple.Object)
+	if objectKnown && !m.HasType(objectType) {
+		report(path+".object", "type %q is not defined in the authorization model", objectType)
+		objectKnown = false
+	}
+
+	userType, userKnown := literalType(tuple.User)
+	if userKnown && !m.HasType(userType) {
+		report(path+".user", "type %q is not defined in the authorization model", userType)
+		userKnown = false
+	}
+
+	relation := tuple.Relation
+	if !objectKnown || strings.Contains(relation, "{{") {
+		return
+	}
+
+	if !m.HasRelation(objectType, relation) {
+		report(path+".relation", "relation %q is not defined on type %q", relation, objectType)
+		return
+	}
+
+	allowed := m.DirectlyRelatedUserTypes(objectType, relation)
+	if len(allowed) == 0 {
+		report(path+".relation", "relation %q on type %q cannot be written directly", relation, objectType)
+		return
+	}
+
+	if !userKnown {
+		return
+	}
+
+	userRelation, relationKnown := literalUsersetRelation(tuple.User)
+	if !relationKnown {
+		// The userset relation is templated, so any reference of the user type may match
+		for _, ref := range allowed {
+			if ref.Type == userType {
+				return
+			}
+		}
+		report(path+".user", "type %q may not be related to %s#%s; allowed: %s", userType, objectType, relation, describe(allowed))
+		return
+	}
+
+	var condition string
+	if tuple.Condition != nil {
+		condition = tuple.Condition.Name
+	}
+
+	wildcard := tuple.User == userType+":*"
+	for _, ref := range allowed {
+		if ref.Type != userType || ref.Condition != condition {
+			continue
+		}
+		if wildcard && ref.Wildcard != nil {
+			return
+		}
+		if !wildcard && ref.Wildcard == nil && ref.Relation == userRelation {
+			return
+		}
+	}
+
+	user := userType
+	if wildcard {
+		user += ":*"
+	} else if userRelation != "" {
+		user += "#" + userRelation
+	}
+	if condition != "" {
+		user += " with " + condition
+	}
+	report(path+".user", "%q may not be related to %s#%s; allowed: %s", user, objectType, relation, describe(allowed))
+}
+
+// literalType returns the type at the start of a "type:id" template, unless the type itself is templated
+func literalType(value string) (string, bool) {
+	idx := strings.Index(value, ":")
+	if idx <= 0 || strings.Contains(value[:idx], "{{") {
+		return "", false
+	}
+	return value[:idx], true
+}
+
+// literalUsersetRelation returns the relation of a "type:id#relation" userset template, or "" for a plain user.
+// It reports false when the relation is templated.
+func literalUsersetRelation(value string) (string, bool) {
+	hash := strings.LastIndex(value, "#")
+	if hash < 0 {
+		return "", true
+	}
+	if strings.Contains(value[hash+1:], "{{") {
+		return "", false
+	}
+	if hash < strings.LastIndex(value, "}}") {
+		// The '#' is inside a template action, not a userset separator
+		return "", true
+	}
+	return value[hash+1:], true
+}
+
+// describe formats the allowed user types of a relation as in the OpenFGA DSL, e.g. "[user, group#member, user:* with expiry]"
+func describe(refs []RelationReference) string {
+	names := make([]string, 0, len(refs))
+	for _, ref := range refs {
+		name := ref.Type
+		switch {
+		case ref.Wildcard != nil:
+			name += ":*"
+		case ref.Relation != "":
+			name += "#" + ref.Relation
+		}
+		if ref.Condition != "" {
+			name += " with " + ref.Condition
+		}
+		names = append(names, name)
+	}
+	return "[" + strings.Join(names, ", ") + "]"
+}
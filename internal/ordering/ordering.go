@@ -0,0 +1,116 @@
+package ordering
+
+import (
+	"sync"
+	"time"
+)
+
+// Tracker orders the events applied to each entity by their event time. It serializes the
+// processing of events for the same entity and remembers, for a TTL, the time of the latest
+// event applied to each one, so that older events arriving late can be dropped.
+type Tracker struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	applied map[string]appliedEvent
+	locks   map[string]*entityLock
+	now     func() time.Time
+}
+
+// appliedEvent is the latest event applied to an entity
+type appliedEvent struct {
+	eventTime  time.Time
+	recordedAt time.Time
+}
+
+// entityLock serializes the events of one entity; refs counts its holders and waiters
+type entityLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// NewTracker creates a tracker that remembers the latest event time of each entity for ttl
+func NewTracker(ttl time.Duration) *Tracker {
+	return &Tracker{
+		ttl:     ttl,
+		applied: make(map[string]appliedEvent),
+		locks:   make(map[string]*entityLock),
+		now:     time.Now,
+	}
+}
+
+// Lock blocks until no other event for the entity is being processed, and returns the function
+// that releases it
+func (t *Tracker) Lock(key string) func() {
+	t.mu.Lock()
+	lock, ok := t.locks[key]
+	if !ok {
+		lock = &entityLock{}
+		t.locks[key] = lock
+	}
+	lock.refs++
+	t.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		t.mu.Lock()
+		lock.refs--
+		if lock.refs == 0 {
+			delete(t.locks, key)
+		}
+		t.mu.Unlock()
+	}
+}
+
+// IsStale reports whether an event is older than the latest event applied to the entity
+func (t *Tracker) IsStale(key string, eventTime time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	applied, ok := t.applied[key]
+	if !ok || t.now().Sub(applied.recordedAt) >= t.ttl {
+		return false
+	}
+	return eventTime.Before(applied.eventTime)
+}
+
+// Record notes that an event was applied to the entity
+func (t *Tracker) Record(key string, eventTime time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	if applied, ok := t.applied[key]; ok && applied.eventTime.After(eventTime) && now.Sub(applied.recordedAt) < t.ttl {
+		return
+	}
+
+	if len(t.applied) > 0 && len(t.applied)%1024 == 0 {
+		t.expire(now)
+	}
+	t.applied[key] = appliedEvent{eventTime: eventTime, recordedAt: now}
+}
+
+// expire forgets entities whose latest event was recorded more than the TTL ago; the caller must hold the lock
+func (t *Tracker) expire(now time.Time) {
+	for key, applied := range t.applied {
+		if now.Sub(applied.recordedAt) >= t.ttl {
+			delete(t.applied, key)
+		}
+	}
+}
+
+// EventTime returns the time an Auth0 event occurred, from its "time" field
+func EventTime(event map[string]interface{}) (time.Time, bool) {
+	value, ok := event["time"].(string)
+	if !ok || value == "" {
+		return time.Time{}, false
+	}
+
+	eventTime, err := time.Parse(time.RFC3339Nano, value)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return eventTime, true
+}
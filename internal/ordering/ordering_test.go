@@ -0,0 +1,79 @@
+package ordering
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTracker_IsStale(t *testing.T) {
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base
+	tracker := NewTracker(time.Hour)
+	tracker.now = func() time.Time { return now }
+
+	assert.False(t, tracker.IsStale("user:1", base))
+
+	tracker.Record("user:1", base.Add(time.Minute))
+	assert.True(t, tracker.IsStale("user:1", base))
+	assert.False(t, tracker.IsStale("user:1", base.Add(time.Minute)))
+	assert.False(t, tracker.IsStale("user:1", base.Add(2*time.Minute)))
+	assert.False(t, tracker.IsStale("user:2", base))
+
+	// Recording an older event keeps the latest time
+	tracker.Record("user:1", base)
+	assert.True(t, tracker.IsStale("user:1", base))
+
+	// Entities are forgotten after the TTL
+	now = now.Add(time.Hour)
+	assert.False(t, tracker.IsStale("user:1", base))
+}
+
+func TestTracker_LockSerializesEntity(t *testing.T) {
+	tracker := NewTracker(time.Hour)
+
+	var mu sync.Mutex
+	active := map[string]int{}
+	maxActive := 0
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			unlock := tracker.Lock("user:1")
+			defer unlock()
+
+			mu.Lock()
+			active["user:1"]++
+			if active["user:1"] > maxActive {
+				maxActive = active["user:1"]
+			}
+			mu.Unlock()
+
+			time.Sleep(time.Millisecond)
+
+			mu.Lock()
+			active["user:1"]--
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 1, maxActive)
+	assert.Empty(t, tracker.locks)
+}
+
+func TestEventTime(t *testing.T) {
+	eventTime, ok := EventTime(map[string]interface{}{"time": "2024-01-01T12:00:00.123Z"})
+	assert.True(t, ok)
+	assert.Equal(t, time.Date(2024, 1, 1, 12, 0, 0, 123000000, time.UTC), eventTime)
+
+	_, ok = EventTime(map[string]interface{}{"time": "yesterday"})
+	assert.False(t, ok)
+
+	_, ok = EventTime(map[string]interface{}{})
+	assert.False(t, ok)
+}
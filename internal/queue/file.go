@@ -0,0 +1,398 @@
+package queue
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+const (
+	eventsFileName = "events.log"
+	acksFileName   = "acks.log"
+
+	// defaultCompactAfter is the number of acknowledgements after which the files are compacted
+	defaultCompactAfter = 1024
+)
+
+// FileQueue is a Queue persisted as two append-only files in a directory: one holding
+// every enqueued message and one holding the IDs of acknowledged messages. Both are
+// truncated whenever the queue drains completely, and compacted down to the unacknowledged
+// messages after every defaultCompactAfter acknowledgements, so they stay small under steady load.
+type FileQueue struct {
+	mu           sync.Mutex
+	dir          string
+	events       *os.File
+	acks         *os.File
+	nextID       uint64
+	pending      []*Message
+	inflight     map[uint64]*Message
+	acked        int // Acknowledgements appended since the files were last compacted
+	compactAfter int
+	notify       chan struct{}
+	done         chan struct{}
+	closed       bool
+}
+
+// OpenFileQueue opens the queue stored in dir, creating it if needed, and recovers
+// every message that was not acknowledged before the last shutdown
+func OpenFileQueue(dir string) (*FileQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create queue directory: %w", err)
+	}
+
+	fq := &FileQueue{
+		dir:          dir,
+		inflight:     make(map[uint64]*Message),
+		compactAfter: defaultCompactAfter,
+		notify:       make(chan struct{}, 1),
+		done:         make(chan struct{}),
+	}
+
+	for _, name := range []string{eventsFileName, acksFileName} {
+		if err := trimPartialLine(filepath.Join(dir, name)); err != nil {
+			return nil, err
+		}
+	}
+
+	messages, err := readMessages(filepath.Join(dir, eventsFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	acked, err := readAcks(filepath.Join(dir, acksFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, msg := range messages {
+		if msg.ID >= fq.nextID {
+			fq.nextID = msg.ID + 1
+		}
+		if !acked[msg.ID] {
+			fq.pending = append(fq.pending, msg)
+		}
+	}
+
+	fq.events, err = os.OpenFile(filepath.Join(dir, eventsFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue events file: %w", err)
+	}
+
+	fq.acks, err = os.OpenFile(filepath.Join(dir, acksFileName), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		fq.events.Close()
+		return nil, fmt.Errorf("failed to open queue acks file: %w", err)
+	}
+
+	fq.acked = len(acked)
+	if len(fq.pending) == 0 {
+		err = fq.truncate()
+	} else if fq.acked >= fq.compactAfter {
+		err = fq.compact()
+	}
+	if err != nil {
+		fq.Close()
+		return nil, err
+	}
+	if len(fq.pending) > 0 {
+		fq.signal()
+	}
+
+	return fq, nil
+}
+
+// Enqueue durably stores a JSON payload together with its headers, which may be nil
+func (fq *FileQueue) Enqueue(payload []byte, headers map[string]string) error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	if fq.closed {
+		return ErrClosed
+	}
+
+	msg := &Message{ID: fq.nextID, Payload: append(json.RawMessage(nil), payload...), Headers: headers}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("failed to encode queued message: %w", err)
+	}
+
+	if err := appendLine(fq.events, line); err != nil {
+		return fmt.Errorf("failed to persist queued message: %w", err)
+	}
+
+	fq.nextID++
+	fq.pending = append(fq.pending, msg)
+	fq.signal()
+
+	return nil
+}
+
+// Dequeue blocks until a message is available, the context is done, or the queue is closed
+func (fq *FileQueue) Dequeue(ctx context.Context) (*Message, error) {
+	for {
+		fq.mu.Lock()
+		if fq.closed {
+			fq.mu.Unlock()
+			return nil, ErrClosed
+		}
+		if len(fq.pending) > 0 {
+			msg := fq.pending[0]
+			fq.pending = fq.pending[1:]
+			fq.inflight[msg.ID] = msg
+			if len(fq.pending) > 0 {
+				// Wake another waiting consumer for the remaining messages
+				fq.signal()
+			}
+			fq.mu.Unlock()
+			return msg, nil
+		}
+		fq.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-fq.done:
+			return nil, ErrClosed
+		case <-fq.notify:
+		}
+	}
+}
+
+// Ack removes a processed message from the queue
+func (fq *FileQueue) Ack(msg *Message) error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	if fq.closed {
+		return ErrClosed
+	}
+
+	if _, ok := fq.inflight[msg.ID]; !ok {
+		return fmt.Errorf("message %d is not in flight", msg.ID)
+	}
+
+	if err := appendLine(fq.acks, []byte(strconv.FormatUint(msg.ID, 10))); err != nil {
+		return fmt.Errorf("failed to persist acknowledgement: %w", err)
+	}
+	delete(fq.inflight, msg.ID)
+	fq.acked++
+
+	if len(fq.pending) == 0 && len(fq.inflight) == 0 {
+		return fq.truncate()
+	}
+	if fq.acked >= fq.compactAfter {
+		return fq.compact()
+	}
+
+	return nil
+}
+
+// Depth returns the number of messages not yet acknowledged
+func (fq *FileQueue) Depth() int {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	return len(fq.pending) + len(fq.inflight)
+}
+
+// Check verifies that the queue is open and its directory is writable, by creating and removing
+// a temporary file
+func (fq *FileQueue) Check() error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	if fq.closed {
+		return ErrClosed
+	}
+
+	probe, err := os.CreateTemp(fq.dir, ".check.*.tmp")
+	if err != nil {
+		return fmt.Errorf("queue directory is not writable: %w", err)
+	}
+	probe.Close()
+	if err := os.Remove(probe.Name()); err != nil {
+		return fmt.Errorf("failed to remove queue check file: %w", err)
+	}
+	return nil
+}
+
+// Close releases the queue's files. Unacknowledged messages are kept for the next open.
+func (fq *FileQueue) Close() error {
+	fq.mu.Lock()
+	defer fq.mu.Unlock()
+
+	if fq.closed {
+		return nil
+	}
+	fq.closed = true
+	close(fq.done)
+
+	eventsErr := fq.events.Close()
+	acksErr := fq.acks.Close()
+	if eventsErr != nil {
+		return eventsErr
+	}
+	return acksErr
+}
+
+// signal wakes one waiting consumer; the caller must hold the lock
+func (fq *FileQueue) signal() {
+	select {
+	case fq.notify <- struct{}{}:
+	default:
+	}
+}
+
+// truncate empties both files once every message is acknowledged; the caller must hold the lock
+func (fq *FileQueue) truncate() error {
+	if err := fq.events.Truncate(0); err != nil {
+		return fmt.Errorf("failed to compact queue events file: %w", err)
+	}
+	if err := fq.acks.Truncate(0); err != nil {
+		return fmt.Errorf("failed to compact queue acks file: %w", err)
+	}
+	fq.acked = 0
+	return nil
+}
+
+// compact rewrites the events file with only the unacknowledged messages, replacing it atomically,
+// and then empties the acks file; the caller must hold the lock. A crash in between leaves
+// acknowledgements of messages that are no longer in the events file, which are ignored on open.
+func (fq *FileQueue) compact() error {
+	messages := make([]*Message, 0, len(fq.inflight)+len(fq.pending))
+	for _, msg := range fq.inflight {
+		messages = append(messages, msg)
+	}
+	messages = append(messages, fq.pending...)
+	sort.Slice(messages, func(i, j int) bool { return messages[i].ID < messages[j].ID })
+
+	var buf bytes.Buffer
+	for _, msg := range messages {
+		line, err := json.Marshal(msg)
+		if err != nil {
+			return fmt.Errorf("failed to encode queued message: %w", err)
+		}
+		buf.Write(append(line, '\n'))
+	}
+
+	path := filepath.Join(fq.dir, eventsFileName)
+	tmp, err := os.CreateTemp(fq.dir, eventsFileName+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to compact queue events file: %w", err)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to compact queue events file: %w", err)
+	}
+
+	events, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to reopen queue events file: %w", err)
+	}
+	fq.events.Close()
+	fq.events = events
+
+	if err := fq.acks.Truncate(0); err != nil {
+		return fmt.Errorf("failed to compact queue acks file: %w", err)
+	}
+	fq.acked = 0
+	return nil
+}
+
+// appendLine writes a line and flushes it to disk
+func appendLine(f *os.File, line []byte) error {
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// readMessages reads every complete message from the events file
+func readMessages(path string) ([]*Message, error) {
+	var messages []*Message
+	err := readLines(path, func(line []byte) error {
+		var msg Message
+		if err := json.Unmarshal(line, &msg); err != nil {
+			return fmt.Errorf("corrupt queue events file %s: %w", path, err)
+		}
+		messages = append(messages, &msg)
+		return nil
+	})
+	return messages, err
+}
+
+// readAcks reads the IDs of every acknowledged message from the acks file
+func readAcks(path string) (map[uint64]bool, error) {
+	acked := make(map[uint64]bool)
+	err := readLines(path, func(line []byte) error {
+		id, err := strconv.ParseUint(string(line), 10, 64)
+		if err != nil {
+			return fmt.Errorf("corrupt queue acks file %s: %w", path, err)
+		}
+		acked[id] = true
+		return nil
+	})
+	return acked, err
+}
+
+// trimPartialLine drops a trailing line without a newline, left behind by an interrupted write
+func trimPartialLine(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	size := bytes.LastIndexByte(data, '\n') + 1
+	if size == len(data) {
+		return nil
+	}
+
+	if err := os.Truncate(path, int64(size)); err != nil {
+		return fmt.Errorf("failed to repair %s: %w", path, err)
+	}
+	return nil
+}
+
+// readLines calls fn for every line in a file
+func readLines(path string, fn func(line []byte) error) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
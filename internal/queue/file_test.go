@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileQueue_EnqueueDequeueAck(t *testing.T) {
+	ctx := context.Background()
+	fq, err := OpenFileQueue(t.TempDir())
+	require.NoError(t, err)
+	defer fq.Close()
+
+	require.NoError(t, fq.Enqueue([]byte(`{"type":"user.created"}`), nil))
+	require.NoError(t, fq.Enqueue([]byte(`{"type":"user.updated"}`), nil))
+	assert.Equal(t, 2, fq.Depth())
+
+	first, err := fq.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"user.created"}`, string(first.Payload))
+
+	second, err := fq.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"type":"user.updated"}`, string(second.Payload))
+
+	// In-flight messages still count towards the depth until acknowledged
+	assert.Equal(t, 2, fq.Depth())
+	require.NoError(t, fq.Ack(first))
+	require.NoError(t, fq.Ack(second))
+	assert.Equal(t, 0, fq.Depth())
+	assert.Error(t, fq.Ack(second))
+}
+
+func TestFileQueue_RecoversUnacknowledgedMessages(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	fq, err := OpenFileQueue(dir)
+	require.NoError(t, err)
+	require.NoError(t, fq.Enqueue([]byte(`{"id":"evt_1"}`), nil))
+	require.NoError(t, fq.Enqueue([]byte(`{"id":"evt_2"}`), map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}))
+	require.NoError(t, fq.Enqueue([]byte(`{"id":"evt_3"}`), nil))
+
+	msg, err := fq.Dequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, fq.Ack(msg))
+
+	// Dequeued but never acknowledged, e.g. the process stopped mid-processing
+	_, err = fq.Dequeue(ctx)
+	require.NoError(t, err)
+	require.NoError(t, fq.Close())
+
+	// Simulate a write interrupted by a crash
+	events, err := os.OpenFile(filepath.Join(dir, eventsFileName), os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = events.WriteString(`{"id":99,"payl`)
+	require.NoError(t, err)
+	require.NoError(t, events.Close())
+
+	fq, err = OpenFileQueue(dir)
+	require.NoError(t, err)
+	defer fq.Close()
+	assert.Equal(t, 2, fq.Depth())
+
+	msg, err = fq.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"evt_2"}`, string(msg.Payload))
+	assert.Equal(t, map[string]string{"traceparent": "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"}, msg.Headers)
+
+	require.NoError(t, fq.Enqueue([]byte(`{"id":"evt_4"}`), nil))
+	assert.Equal(t, 3, fq.Depth())
+}
+
+func TestFileQueue_DequeueBlocks(t *testing.T) {
+	fq, err := OpenFileQueue(t.TempDir())
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err = fq.Dequeue(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	received := make(chan *Message)
+	go func() {
+		msg, _ := fq.Dequeue(context.Background())
+		received <- msg
+	}()
+
+	require.NoError(t, fq.Enqueue([]byte(`{}`), nil))
+	select {
+	case msg := <-received:
+		assert.NotNil(t, msg)
+	case <-time.After(time.Second):
+		t.Fatal("Dequeue did not return after Enqueue")
+	}
+
+	require.NoError(t, fq.Close())
+	_, err = fq.Dequeue(context.Background())
+	assert.ErrorIs(t, err, ErrClosed)
+}
+
+func TestFileQueue_CompactsUnderSteadyLoad(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	fq, err := OpenFileQueue(dir)
+	require.NoError(t, err)
+	fq.compactAfter = 10
+
+	// Keep one message queued at all times, so the queue never drains completely
+	require.NoError(t, fq.Enqueue([]byte(`{"n":0}`), nil))
+	for i := 1; i <= 25; i++ {
+		require.NoError(t, fq.Enqueue([]byte(fmt.Sprintf(`{"n":%d}`, i)), nil))
+		msg, err := fq.Dequeue(ctx)
+		require.NoError(t, err)
+		require.NoError(t, fq.Ack(msg))
+	}
+	assert.Equal(t, 1, fq.Depth())
+
+	// 25 acknowledgements were compacted twice, leaving 5 in the acks file
+	acks, err := readAcks(filepath.Join(dir, acksFileName))
+	require.NoError(t, err)
+	assert.Len(t, acks, 5)
+	messages, err := readMessages(filepath.Join(dir, eventsFileName))
+	require.NoError(t, err)
+	assert.Len(t, messages, 6)
+
+	// Messages enqueued after a compaction survive a restart
+	require.NoError(t, fq.Enqueue([]byte(`{"n":26}`), nil))
+	require.NoError(t, fq.Close())
+
+	fq, err = OpenFileQueue(dir)
+	require.NoError(t, err)
+	defer fq.Close()
+	assert.Equal(t, 2, fq.Depth())
+
+	msg, err := fq.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"n":25}`, string(msg.Payload))
+	msg, err = fq.Dequeue(ctx)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"n":26}`, string(msg.Payload))
+}
+
+func TestFileQueue_Check(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "queue")
+	fq, err := OpenFileQueue(dir)
+	require.NoError(t, err)
+
+	require.NoError(t, fq.Check())
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, entries, 2, "the check file is removed")
+
+	require.NoError(t, os.RemoveAll(dir))
+	assert.ErrorContains(t, fq.Check(), "queue directory is not writable")
+
+	require.NoError(t, fq.Close())
+	assert.ErrorIs(t, fq.Check(), ErrClosed)
+}
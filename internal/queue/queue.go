@@ -0,0 +1,40 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+)
+
+// ErrClosed is returned by queue operations after the queue has been closed
+var ErrClosed = errors.New("queue is closed")
+
+// Message is a queued event
+type Message struct {
+	ID      uint64            `json:"id"`
+	Payload json.RawMessage   `json:"payload"`
+	Headers map[string]string `json:"headers,omitempty"` // e.g. the W3C trace context of the request that accepted the event
+}
+
+// Queue is a durable FIFO of accepted webhook events waiting to be processed.
+// A dequeued message stays in the queue until it is acknowledged, so messages
+// still in flight when the process stops are delivered again on restart.
+type Queue interface {
+	// Enqueue durably stores a JSON payload together with its headers, which may be nil
+	Enqueue(payload []byte, headers map[string]string) error
+
+	// Dequeue blocks until a message is available, the context is done, or the queue is closed
+	Dequeue(ctx context.Context) (*Message, error)
+
+	// Ack removes a processed message from the queue
+	Ack(msg *Message) error
+
+	// Depth returns the number of messages not yet acknowledged
+	Depth() int
+
+	// Check verifies that messages can still be enqueued
+	Check() error
+
+	// Close releases the queue's resources
+	Close() error
+}
@@ -0,0 +1,164 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+
+	fgaSdk "github.com/openfga/go-sdk"
+)
+
+// MinInterval is the shortest delay between attempts, so a misconfigured policy cannot spin against OpenFGA
+const MinInterval = 10 * time.Millisecond
+
+// Policy configures retries with exponential backoff and jitter.
+// Zero fields other than Jitter fall back to DefaultPolicy.
+type Policy struct {
+	MaxAttempts     int           // Total attempts including the first; negative retries retryable errors indefinitely
+	InitialInterval time.Duration // Delay before the first retry
+	MaxInterval     time.Duration // Upper bound for any single delay
+	Multiplier      float64       // Growth factor between consecutive delays
+	Jitter          float64       // Randomization factor in [0, 1]; 0.2 spreads each delay by ±20%
+}
+
+// DefaultPolicy returns the policy used when none is configured
+func DefaultPolicy() Policy {
+	return Policy{
+		MaxAttempts:     5,
+		InitialInterval: 500 * time.Millisecond,
+		MaxInterval:     30 * time.Second,
+		Multiplier:      2,
+		Jitter:          0.2,
+	}
+}
+
+// withDefaults fills the zero fields of a policy from DefaultPolicy
+func (p Policy) withDefaults() Policy {
+	defaults := DefaultPolicy()
+	if p.MaxAttempts == 0 {
+		p.MaxAttempts = defaults.MaxAttempts
+	}
+	if p.InitialInterval == 0 {
+		p.InitialInterval = defaults.InitialInterval
+	}
+	if p.MaxInterval == 0 {
+		p.MaxInterval = defaults.MaxInterval
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = defaults.Multiplier
+	}
+	return p
+}
+
+// Backoff returns the delay to wait after the given failed attempt (starting at 1), never less than MinInterval
+func (p Policy) Backoff(attempt int) time.Duration {
+	p = p.withDefaults()
+
+	multiplier := p.Multiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(p.InitialInterval) * math.Pow(multiplier, float64(attempt-1))
+	if delay > float64(p.MaxInterval) {
+		delay = float64(p.MaxInterval)
+	}
+
+	if p.Jitter > 0 {
+		delay += delay * p.Jitter * (2*rand.Float64() - 1)
+	}
+
+	if delay < float64(MinInterval) {
+		return MinInterval
+	}
+	return time.Duration(delay)
+}
+
+// Do calls fn until it succeeds, fails with a permanent error, or the policy runs out of attempts.
+// It returns the number of attempts made and the last error. Retries also stop, returning the last
+// error, when the next attempt would start after the context's deadline. If the context is done
+// while waiting between attempts, the context's error is returned.
+func Do(ctx context.Context, policy Policy, fn func(ctx context.Context) error) (int, error) {
+	policy = policy.withDefaults()
+
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return attempt, nil
+		}
+
+		if !IsRetryable(err) || (policy.MaxAttempts > 0 && attempt >= policy.MaxAttempts) {
+			return attempt, err
+		}
+
+		backoff := policy.Backoff(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(backoff).After(deadline) {
+			return attempt, err
+		}
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return attempt, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// statusCoder is implemented by the OpenFGA SDK's API errors
+type statusCoder interface {
+	ResponseStatusCode() int
+}
+
+// retryableError marks an error as transient regardless of its type
+type retryableError struct {
+	err error
+}
+
+func (e retryableError) Error() string { return e.err.Error() }
+func (e retryableError) Unwrap() error { return e.err }
+
+// Retryable marks an error as transient so that IsRetryable reports true for it
+func Retryable(err error) error {
+	if err == nil {
+		return nil
+	}
+	return retryableError{err: err}
+}
+
+// IsRetryable reports whether an error is transient: network failures, rate limiting (429)
+// and server errors (5xx). Everything else, such as validation errors or unknown types, is permanent.
+func IsRetryable(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var marked retryableError
+	if errors.As(err, &marked) {
+		return true
+	}
+
+	var rateLimitErr fgaSdk.FgaApiRateLimitExceededError
+	var internalErr fgaSdk.FgaApiInternalError
+	if errors.As(err, &rateLimitErr) || errors.As(err, &internalErr) {
+		return true
+	}
+
+	var apiErr statusCoder
+	if errors.As(err, &apiErr) {
+		status := apiErr.ResponseStatusCode()
+		return status == 429 || status >= 500
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return true
+	}
+
+	return errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF)
+}
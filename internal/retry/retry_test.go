@@ -0,0 +1,120 @@
+package retry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+
+	fgaSdk "github.com/openfga/go-sdk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		expected bool
+	}{
+		{name: "rate limited", err: fgaSdk.FgaApiRateLimitExceededError{}, expected: true},
+		{name: "server error", err: fmt.Errorf("failed to write: %w", fgaSdk.FgaApiInternalError{}), expected: true},
+		{name: "network error", err: fmt.Errorf("failed to write: %w", &net.OpError{Op: "dial", Err: errors.New("connection refused")}), expected: true},
+		{name: "validation error", err: fmt.Errorf("failed to write: %w", fgaSdk.FgaApiValidationError{}), expected: false},
+		{name: "marked retryable", err: fmt.Errorf("wrapped: %w", Retryable(errors.New("busy"))), expected: true},
+		{name: "plain error", err: errors.New("no action found for event type"), expected: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, IsRetryable(tt.err))
+		})
+	}
+}
+
+func TestPolicy_Backoff(t *testing.T) {
+	policy := Policy{InitialInterval: 100 * time.Millisecond, MaxInterval: time.Second, Multiplier: 2}
+
+	assert.Equal(t, 100*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 400*time.Millisecond, policy.Backoff(3))
+	assert.Equal(t, time.Second, policy.Backoff(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		delay := policy.Backoff(1)
+		assert.GreaterOrEqual(t, delay, 50*time.Millisecond)
+		assert.LessOrEqual(t, delay, 150*time.Millisecond)
+	}
+
+	// Unset fields use the defaults, and delays never drop below MinInterval
+	assert.Equal(t, DefaultPolicy().InitialInterval, Policy{}.Backoff(1))
+	assert.Equal(t, MinInterval, Policy{InitialInterval: time.Microsecond}.Backoff(1))
+}
+
+func TestDo(t *testing.T) {
+	ctx := context.Background()
+	policy := Policy{MaxAttempts: 3, InitialInterval: time.Millisecond, Multiplier: 2}
+
+	t.Run("succeeds after transient failures", func(t *testing.T) {
+		calls := 0
+		attempts, err := Do(ctx, policy, func(ctx context.Context) error {
+			calls++
+			if calls < 3 {
+				return Retryable(errors.New("unavailable"))
+			}
+			return nil
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("stops on permanent errors", func(t *testing.T) {
+		attempts, err := Do(ctx, policy, func(ctx context.Context) error {
+			return errors.New("invalid tuple")
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts)
+	})
+
+	t.Run("gives up after max attempts", func(t *testing.T) {
+		attempts, err := Do(ctx, policy, func(ctx context.Context) error {
+			return Retryable(errors.New("unavailable"))
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 3, attempts)
+	})
+
+	t.Run("zero policy is bounded", func(t *testing.T) {
+		attempts := 0
+		timeout, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		defer cancel()
+		_, err := Do(timeout, Policy{}, func(ctx context.Context) error {
+			attempts++
+			return Retryable(errors.New("unavailable"))
+		})
+		assert.Error(t, err)
+		assert.Equal(t, 1, attempts) // the default 500ms backoff would pass the deadline
+	})
+
+	t.Run("stops before the deadline", func(t *testing.T) {
+		timeout, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		start := time.Now()
+		attempts, err := Do(timeout, Policy{MaxAttempts: -1, InitialInterval: 20 * time.Millisecond, Multiplier: 1}, func(ctx context.Context) error {
+			return Retryable(errors.New("unavailable"))
+		})
+		assert.EqualError(t, err, "unavailable")
+		assert.Less(t, time.Since(start), 50*time.Millisecond)
+		assert.GreaterOrEqual(t, attempts, 2)
+	})
+
+	t.Run("stops when the context is done", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		_, err := Do(cancelled, Policy{InitialInterval: time.Hour}, func(ctx context.Context) error {
+			return Retryable(errors.New("unavailable"))
+		})
+		assert.ErrorIs(t, err, context.Canceled)
+	})
+}
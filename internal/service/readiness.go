@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"mapping-engine/internal/store"
+)
+
+// readinessTimeout bounds all the checks of a /readyz request
+const readinessTimeout = 5 * time.Second
+
+// Readiness check statuses
+const (
+	checkOK     = "ok"
+	checkFailed = "failed"
+)
+
+// readinessCheck is a dependency the service needs to process events
+type readinessCheck struct {
+	name  string
+	check func(ctx context.Context) error
+}
+
+// checkResult is the outcome of a readiness check reported by /readyz
+type checkResult struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// readinessChecks returns the checks of the dependencies the service was configured with
+func (s *WebhookService) readinessChecks() []readinessCheck {
+	var checks []readinessCheck
+
+	if s.model != nil {
+		checks = append(checks,
+			readinessCheck{name: "openfga_store", check: func(ctx context.Context) error {
+				return store.CheckStore(ctx, s.fgaClient, s.cfg.OpenFGA.StoreID)
+			}},
+			readinessCheck{name: "openfga_model", check: func(ctx context.Context) error {
+				modelID, err := s.model.ModelID(ctx)
+				if err != nil {
+					return err
+				}
+				return store.CheckModel(ctx, s.fgaClient, s.cfg.OpenFGA.StoreID, modelID)
+			}},
+		)
+	}
+
+	checks = append(checks, readinessCheck{name: "mappings", check: func(ctx context.Context) error {
+		if len(s.activeMappings().configs) == 0 {
+			return fmt.Errorf("no mapping configurations are loaded")
+		}
+		return nil
+	}})
+
+	if s.queue != nil {
+		checks = append(checks, readinessCheck{name: "queue", check: func(ctx context.Context) error {
+			return s.queue.Check()
+		}})
+	}
+
+	return checks
+}
+
+// handleLiveness reports that the process is up and serving requests, without checking its dependencies
+func (s *WebhookService) handleLiveness(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(map[string]interface{}{"status": "alive"})
+}
+
+// handleReadiness runs the readiness checks and reports whether the service can process events.
+// It responds 503 Service Unavailable when any check fails.
+func (s *WebhookService) handleReadiness(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	status, code := "ready", http.StatusOK
+	checks := s.readinessChecks()
+	results := make([]checkResult, 0, len(checks))
+	for _, check := range checks {
+		start := time.Now()
+		err := check.check(ctx)
+		result := checkResult{Name: check.name, Status: checkOK, Duration: time.Since(start).String()}
+		if err != nil {
+			result.Status, result.Error = checkFailed, err.Error()
+			status, code = "not_ready", http.StatusServiceUnavailable
+			s.log().WarnContext(ctx, "Readiness check failed", "check", check.name, "error", err)
+		}
+		results = append(results, result)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":    status,
+		"timestamp": time.Now().UTC(),
+		"checks":    results,
+	})
+}
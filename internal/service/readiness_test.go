@@ -0,0 +1,131 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openfga/go-sdk/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mapping-engine/internal/config"
+	"mapping-engine/internal/store"
+)
+
+const readinessStoreID = "01HXDB9MNPQR1234567890ABCD"
+
+// readinessResponse is the body of a /readyz response
+type readinessResponse struct {
+	Status string        `json:"status"`
+	Checks []checkResult `json:"checks"`
+}
+
+func getReadiness(t *testing.T, svc *WebhookService) (int, readinessResponse) {
+	rr := httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, "application/json", rr.Header().Get("Content-Type"))
+
+	var response readinessResponse
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	return rr.Code, response
+}
+
+func checkStatuses(response readinessResponse) map[string]string {
+	statuses := make(map[string]string)
+	for _, check := range response.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestWebhookService_Liveness(t *testing.T) {
+	svc := newQueuedTestService(t, store.NewMemoryStore())
+
+	rr := httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/livez", nil))
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.JSONEq(t, `{"status": "alive"}`, rr.Body.String())
+}
+
+func TestWebhookService_Readiness(t *testing.T) {
+	svc := newQueuedTestService(t, store.NewMemoryStore())
+
+	code, response := getReadiness(t, svc)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "ready", response.Status)
+	assert.Equal(t, map[string]string{"mappings": checkOK, "queue": checkOK}, checkStatuses(response))
+
+	// A closed queue no longer accepts events
+	require.NoError(t, svc.queue.Close())
+	code, response = getReadiness(t, svc)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, "not_ready", response.Status)
+	assert.Equal(t, map[string]string{"mappings": checkOK, "queue": checkFailed}, checkStatuses(response))
+	assert.Equal(t, "queue is closed", response.Checks[1].Error)
+}
+
+func TestWebhookService_ReadinessChecksOpenFGA(t *testing.T) {
+	// The store exists, but the pinned model has since been deleted
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if r.URL.Path == "/stores/"+readinessStoreID {
+			json.NewEncoder(w).Encode(map[string]interface{}{"id": readinessStoreID, "name": "test"})
+			return
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": "authorization_model_not_found", "message": "not found"})
+	}))
+	defer server.Close()
+
+	svc := newQueuedTestService(t, store.NewMemoryStore())
+	fgaClient, err := client.NewSdkClient(&client.ClientConfiguration{ApiUrl: server.URL})
+	require.NoError(t, err)
+	svc.fgaClient = fgaClient
+	svc.cfg.OpenFGA.StoreID = readinessStoreID
+	svc.model = store.NewModelResolver(fgaClient, readinessStoreID, store.ModelResolution{ModelID: "01HXDB9MNPQR1234567890AAAA"})
+
+	code, response := getReadiness(t, svc)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]string{
+		"openfga_store": checkOK,
+		"openfga_model": checkFailed,
+		"mappings":      checkOK,
+		"queue":         checkOK,
+	}, checkStatuses(response))
+	assert.Contains(t, response.Checks[1].Error, "authorization model is not resolved: failed to read authorization model 01HXDB9MNPQR1234567890AAAA")
+
+	// An unconfigured store fails without a request
+	svc.cfg.OpenFGA.StoreID = ""
+	_, response = getReadiness(t, svc)
+	assert.Equal(t, "store ID is not configured", response.Checks[0].Error)
+}
+
+func TestNewWebhookService_StartsWhileOpenFGAIsDown(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	cfg := &config.ServiceConfig{
+		OpenFGA: config.OpenFGAConfig{
+			APIUrl:     server.URL,
+			StoreID:    readinessStoreID,
+			ModelID:    store.LatestModel,
+			AuthMethod: "none",
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+	}
+	svc, err := NewWebhookService(cfg, nil)
+	require.NoError(t, err)
+
+	code, response := getReadiness(t, svc)
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, map[string]string{
+		"openfga_store": checkFailed,
+		"openfga_model": checkFailed,
+		"mappings":      checkOK,
+	}, checkStatuses(response))
+	assert.Contains(t, response.Checks[1].Error, "authorization model is not resolved")
+}
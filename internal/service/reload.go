@@ -0,0 +1,179 @@
+package service
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"mapping-engine/internal/config"
+	"mapping-engine/internal/engine"
+	"mapping-engine/internal/metrics"
+)
+
+// Triggers of a mapping reload, logged with its outcome
+const (
+	TriggerStartup = "startup"
+	TriggerWatch   = "watch"  // The mapping files changed; see MappingsConfig.WatchInterval
+	TriggerAdmin   = "admin"  // POST /admin/reload-mappings
+	TriggerSignal  = "signal" // SIGHUP, handled by the caller of ReloadMappings
+)
+
+// mappingSet is an immutable set of compiled mapping configurations, activated as a whole
+type mappingSet struct {
+	configs []*engine.CompiledMappingConfig
+	version int    // Incremented every time a changed set is activated, starting at 1
+	hash    string // Hash of the mapping files the set was loaded from; see config.HashMappingFiles
+
+	relations map[string]bool // Relations the configurations name literally; see engine.LiteralRelations
+}
+
+// MappingVersion identifies the active set of mapping configurations
+type MappingVersion struct {
+	Version int    `json:"version"`
+	Hash    string `json:"hash"`
+	Configs int    `json:"configs"` // Number of mapping configurations in the set
+}
+
+// noMappings is the empty set active before the mapping files are first loaded
+var noMappings = &mappingSet{}
+
+// activeMappings returns the active set of mapping configurations
+func (s *WebhookService) activeMappings() *mappingSet {
+	if mappings := s.mappings.Load(); mappings != nil {
+		return mappings
+	}
+	return noMappings
+}
+
+// MappingVersion returns the version of the active set of mapping configurations
+func (s *WebhookService) MappingVersion() MappingVersion {
+	mappings := s.activeMappings()
+	return MappingVersion{Version: mappings.version, Hash: mappings.hash, Configs: len(mappings.configs)}
+}
+
+// ReloadMappings loads, compiles and validates the mapping files, and activates them if they changed
+// since the active version. Events already being processed finish with the version they started
+// with. If the files are invalid, the error is returned and the active version stays in use.
+// It reports whether a new version was activated; trigger says what requested the reload, for the logs.
+func (s *WebhookService) ReloadMappings(ctx context.Context, trigger string) (MappingVersion, bool, error) {
+	s.reloadMu.Lock()
+	defer s.reloadMu.Unlock()
+
+	active := s.activeMappings()
+	reload := active.version > 0
+	log := s.log().With("trigger", trigger)
+
+	hash, err := config.HashMappingFiles(s.cfg.Mappings.Paths)
+	if err == nil && hash == active.hash {
+		s.metrics.MappingConfigReloaded(metrics.ReloadUnchanged)
+		log.InfoContext(ctx, "Mapping configurations are unchanged", "mapping_version", active.version, "mapping_hash", active.hash)
+		return s.MappingVersion(), false, nil
+	}
+
+	var configs []*engine.CompiledMappingConfig
+	if err == nil {
+		configs, err = s.loadMappingConfigs()
+	}
+	if err != nil {
+		if reload {
+			s.metrics.MappingConfigReloaded(metrics.ReloadFailure)
+			log.ErrorContext(ctx, "Rejected mapping configurations; keeping the active version", "mapping_version", active.version, "mapping_hash", active.hash, "error", err)
+		}
+		return s.MappingVersion(), false, err
+	}
+
+	for _, mappingConfig := range configs {
+		log.InfoContext(ctx, "Loaded mapping configuration", "mapping_config", mappingConfig.Name, "event_types", len(mappingConfig.Events), "mappings", len(mappingConfig.Mappings))
+	}
+
+	next := &mappingSet{configs: configs, version: active.version + 1, hash: hash, relations: engine.LiteralRelations(configs)}
+	s.mappingEngine.SetObjectTypes(engine.ObjectTypes(configs))
+	s.mappings.Store(next)
+
+	if reload {
+		s.metrics.MappingConfigReloaded(metrics.ReloadSuccess)
+	}
+	s.metrics.MappingConfigActivated(next.version, next.hash)
+	log.InfoContext(ctx, "Activated mapping configurations", "mapping_version", next.version, "mapping_hash", next.hash, "mapping_configs", len(configs))
+
+	return s.MappingVersion(), true, nil
+}
+
+// startWatchingMappings reloads the mapping files whenever they change, checking them every interval
+func (s *WebhookService) startWatchingMappings(interval time.Duration) {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopWatching = cancel
+
+	s.log().Info("Watching mapping files for changes", "paths", s.cfg.Mappings.Paths, "interval", interval)
+	go s.watchMappings(ctx, interval)
+}
+
+// watchMappings polls the hash of the mapping files until the context is cancelled. Polling, unlike
+// file system notifications, also sees files replaced through symlinks, as in Kubernetes ConfigMaps.
+// A rejected version is not retried until the files change again.
+func (s *WebhookService) watchMappings(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var rejectedHash, lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		hash, err := config.HashMappingFiles(s.cfg.Mappings.Paths)
+		if err != nil {
+			if err.Error() != lastErr {
+				s.log().Error("Failed to read mapping files; keeping the active version", "error", err)
+			}
+			lastErr = err.Error()
+			continue
+		}
+		lastErr = ""
+
+		if hash == s.activeMappings().hash || hash == rejectedHash {
+			continue
+		}
+		if _, _, err := s.ReloadMappings(ctx, TriggerWatch); err != nil {
+			rejectedHash = hash
+		}
+	}
+}
+
+// handleReloadMappings reloads the mapping files on request of an operator authenticated with the
+// admin token. It responds 422 Unprocessable Entity, with the validation errors, when they are rejected.
+func (s *WebhookService) handleReloadMappings(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(r) {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	version, changed, err := s.ReloadMappings(r.Context(), TriggerAdmin)
+
+	response := map[string]interface{}{"active": version}
+	code := http.StatusOK
+	switch {
+	case err != nil:
+		response["status"] = "rejected"
+		response["error"] = err.Error()
+		code = http.StatusUnprocessableEntity
+	case changed:
+		response["status"] = "reloaded"
+	default:
+		response["status"] = "unchanged"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(response)
+}
+
+// authorizeAdmin checks that a request carries the admin token as a bearer token
+func (s *WebhookService) authorizeAdmin(r *http.Request) bool {
+	expected := "Bearer " + s.cfg.Admin.Token
+	return s.cfg.Admin.Token != "" && subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), []byte(expected)) == 1
+}
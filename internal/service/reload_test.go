@@ -0,0 +1,189 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mapping-engine/internal/config"
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/types"
+)
+
+// teamMappings writes tuples of a type the shipped authorization model does not define
+const teamMappings = `events:
+  - type: team.member.added
+mappings:
+  - tuple:
+      user: "user:{{ .data.object.user_id }}"
+      relation: "member"
+      object: "team:{{ .data.object.team_id }}"
+`
+
+// copyMappingFile copies a shipped mapping file into dir
+func copyMappingFile(t *testing.T, dir, name string) {
+	data, err := os.ReadFile(filepath.Join("../../configs", name))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0o644))
+}
+
+// newReloadTestService creates a service loading the mapping files in a directory of its own
+func newReloadTestService(t *testing.T, memoryStore *store.MemoryStore) (*WebhookService, string) {
+	dir := t.TempDir()
+	copyMappingFile(t, dir, "organization-member-mappings.yaml")
+
+	cfg := &config.ServiceConfig{
+		OpenFGA: config.OpenFGAConfig{
+			ModelFile: "../../configs/model.json",
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{dir},
+		},
+		Admin: config.AdminConfig{
+			Token: "admin-secret",
+		},
+	}
+
+	svc, err := NewWebhookServiceWithStore(cfg, memoryStore, nil)
+	require.NoError(t, err)
+	return svc, dir
+}
+
+func memberAddedEvent(userID string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": userID},
+				"organization": map[string]interface{}{"id": "org_123"},
+			},
+		},
+	}
+}
+
+func TestWebhookService_ReloadMappings(t *testing.T) {
+	ctx := context.Background()
+	memoryStore := store.NewMemoryStore()
+	svc, dir := newReloadTestService(t, memoryStore)
+
+	initial := svc.MappingVersion()
+	assert.Equal(t, 1, initial.Version)
+	assert.Equal(t, 1, initial.Configs)
+	assert.Len(t, initial.Hash, 12)
+
+	// Unchanged files keep the active version
+	version, changed, err := svc.ReloadMappings(ctx, TriggerSignal)
+	require.NoError(t, err)
+	assert.False(t, changed)
+	assert.Equal(t, initial, version)
+
+	// Mappings the authorization model does not allow are rejected, and the active version stays in use
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team-mappings.yaml"), []byte(teamMappings), 0o644))
+	version, changed, err = svc.ReloadMappings(ctx, TriggerSignal)
+	assert.ErrorContains(t, err, `type "team" is not defined in the authorization model`)
+	assert.False(t, changed)
+	assert.Equal(t, initial, version)
+
+	assert.Equal(t, http.StatusOK, postEvent(t, svc, memberAddedEvent("auth0|first")).Code)
+	assert.Equal(t, "other", svc.eventTypeLabel(map[string]interface{}{"type": "organization.created"}))
+
+	// A valid change is swapped in as a new version
+	require.NoError(t, os.Remove(filepath.Join(dir, "team-mappings.yaml")))
+	copyMappingFile(t, dir, "organization-mappings.yaml")
+	version, changed, err = svc.ReloadMappings(ctx, TriggerSignal)
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, 2, version.Version)
+	assert.Equal(t, 2, version.Configs)
+	assert.NotEqual(t, initial.Hash, version.Hash)
+
+	assert.Equal(t, http.StatusOK, postEvent(t, svc, memberAddedEvent("auth0|second")).Code)
+	assert.Equal(t, "organization.created", svc.eventTypeLabel(map[string]interface{}{"type": "organization.created"}))
+	assert.Equal(t, []types.ProcessedTuple{
+		{User: "user:auth0|first", Relation: "member", Object: "organization:org_123"},
+		{User: "user:auth0|second", Relation: "member", Object: "organization:org_123"},
+	}, memoryStore.Tuples())
+
+	rr := httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	body := rr.Body.String()
+	assert.Contains(t, body, `mapping_engine_mapping_config_info{hash="`+version.Hash+`",version="2"} 1`)
+	assert.Contains(t, body, `mapping_engine_mapping_config_reloads_total{result="success"} 1`)
+	assert.Contains(t, body, `mapping_engine_mapping_config_reloads_total{result="failure"} 1`)
+	assert.Contains(t, body, `mapping_engine_mapping_config_reloads_total{result="unchanged"} 1`)
+}
+
+func TestWebhookService_WatchesMappingFiles(t *testing.T) {
+	svc, dir := newReloadTestService(t, store.NewMemoryStore())
+
+	svc.startWatchingMappings(10 * time.Millisecond)
+	defer svc.stopWatching()
+
+	copyMappingFile(t, dir, "organization-mappings.yaml")
+	require.Eventually(t, func() bool { return svc.MappingVersion().Version == 2 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 2, svc.MappingVersion().Configs)
+
+	// A rejected change is not swapped in
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team-mappings.yaml"), []byte(teamMappings), 0o644))
+	time.Sleep(100 * time.Millisecond)
+	assert.Equal(t, 2, svc.MappingVersion().Version)
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "team-mappings.yaml")))
+	copyMappingFile(t, dir, "user-mappings.yaml")
+	require.Eventually(t, func() bool { return svc.MappingVersion().Version == 3 }, 5*time.Second, 10*time.Millisecond)
+	assert.Equal(t, 3, svc.MappingVersion().Configs)
+}
+
+func TestWebhookService_AdminReloadMappings(t *testing.T) {
+	svc, dir := newReloadTestService(t, store.NewMemoryStore())
+
+	reload := func(token string) (int, map[string]interface{}) {
+		req := httptest.NewRequest(http.MethodPost, "/admin/reload-mappings", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rr := httptest.NewRecorder()
+		svc.router.ServeHTTP(rr, req)
+
+		var response map[string]interface{}
+		json.Unmarshal(rr.Body.Bytes(), &response)
+		return rr.Code, response
+	}
+
+	code, _ := reload("")
+	assert.Equal(t, http.StatusUnauthorized, code)
+	code, _ = reload("wrong")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, response := reload("admin-secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "unchanged", response["status"])
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "team-mappings.yaml"), []byte(teamMappings), 0o644))
+	code, response = reload("admin-secret")
+	assert.Equal(t, http.StatusUnprocessableEntity, code)
+	assert.Equal(t, "rejected", response["status"])
+	assert.Contains(t, response["error"], `type "team" is not defined`)
+	assert.EqualValues(t, 1, response["active"].(map[string]interface{})["version"])
+
+	require.NoError(t, os.Remove(filepath.Join(dir, "team-mappings.yaml")))
+	copyMappingFile(t, dir, "organization-mappings.yaml")
+	code, response = reload("admin-secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "reloaded", response["status"])
+	assert.EqualValues(t, 2, response["active"].(map[string]interface{})["version"])
+
+	// Without an admin token the endpoint is not served
+	unauthenticated := newQueuedTestService(t, store.NewMemoryStore())
+	rr := httptest.NewRecorder()
+	unauthenticated.router.ServeHTTP(rr, httptest.NewRequest(http.MethodPost, "/admin/reload-mappings", nil))
+	assert.Equal(t, http.StatusNotFound, rr.Code)
+}
@@ -3,23 +3,41 @@ package service
 import (
 	"context"
 	"crypto/hmac"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
 	"github.com/openfga/go-sdk/client"
 	"github.com/openfga/go-sdk/credentials"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 
 	"mapping-engine/internal/config"
+	"mapping-engine/internal/deadletter"
+	"mapping-engine/internal/dedup"
 	"mapping-engine/internal/engine"
-	"mapping-engine/internal/types"
+	"mapping-engine/internal/logging"
+	"mapping-engine/internal/metrics"
+	"mapping-engine/internal/model"
+	"mapping-engine/internal/ordering"
+	"mapping-engine/internal/queue"
+	"mapping-engine/internal/retry"
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/tracing"
 )
 
 // WebhookService handles Auth0 webhook events and processes them through the mapping engine
@@ -30,18 +48,41 @@ type WebhookService struct {
 	mappingEngine *engine.MappingEngine
 	fgaClient     *client.OpenFgaClient
 
-	// Loaded mapping configurations
-	userConfig      *types.MappingConfig
-	orgConfig       *types.MappingConfig
-	orgMemberConfig *types.MappingConfig
-	orgRoleConfig   *types.MappingConfig
+	// Resolves the authorization model writes are pinned to; nil when not writing to OpenFGA
+	model         *store.ModelResolver
+	stopResolving context.CancelFunc // Stops resolving the model in the background
+
+	// Durable event queue drained by the worker pool; nil when events are processed synchronously
+	queue       queue.Queue
+	workers     sync.WaitGroup
+	stopWorkers context.CancelFunc
+
+	// Events that still fail after retries; nil when dead-lettering is disabled
+	deadLetters *deadletter.File
+
+	// IDs of processed events, used to skip redeliveries; nil when deduplication is disabled
+	processedEvents dedup.Store
+
+	// Latest event time applied to each entity; nil when event ordering is disabled
+	ordering *ordering.Tracker
+
+	// Active set of compiled mapping configurations, swapped as a whole when the mapping files are
+	// reloaded; events are routed by each configuration's events list
+	mappings     atomic.Pointer[mappingSet]
+	reloadMu     sync.Mutex         // Serializes reloads
+	stopWatching context.CancelFunc // Stops watching the mapping files; nil when they are not watched
+
+	// Prometheus metrics served on /metrics; nil records nothing
+	metrics *metrics.Metrics
+
+	logger *slog.Logger // nil discards logs
 }
 
-// NewWebhookService creates a new webhook service instance
-func NewWebhookService(cfg *config.ServiceConfig) (*WebhookService, error) {
+// NewWebhookService creates a new webhook service instance that logs to logger; nil discards logs
+func NewWebhookService(cfg *config.ServiceConfig, logger *slog.Logger) (*WebhookService, error) {
 	svc := &WebhookService{
 		cfg:    cfg,
-		router: mux.NewRouter(),
+		logger: logger,
 	}
 
 	// Initialize OpenFGA client
@@ -49,27 +90,100 @@ func NewWebhookService(cfg *config.ServiceConfig) (*WebhookService, error) {
 		return nil, fmt.Errorf("failed to initialize OpenFGA client: %w", err)
 	}
 
+	// Pin writes to the authorization model the mappings are validated against. It is resolved in
+	// the background once the service starts, so OpenFGA being down does not stop the service.
+	svc.model = store.NewModelResolver(svc.fgaClient, cfg.OpenFGA.StoreID, store.ModelResolution{
+		ModelID:    cfg.OpenFGA.ModelID,
+		ModelFile:  cfg.OpenFGA.ModelFile,
+		WriteModel: cfg.OpenFGA.WriteModel,
+		Logger:     svc.log(),
+	})
+
+	if err := svc.init(store.NewResolvingOpenFGAStore(svc.fgaClient, cfg.OpenFGA.StoreID, svc.model)); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// NewWebhookServiceWithStore creates a new webhook service instance that writes to the given tuple store
+// instead of connecting to OpenFGA, and logs to logger; nil discards logs
+func NewWebhookServiceWithStore(cfg *config.ServiceConfig, tupleStore store.TupleStore, logger *slog.Logger) (*WebhookService, error) {
+	svc := &WebhookService{
+		cfg:    cfg,
+		logger: logger,
+	}
+
+	if err := svc.init(tupleStore); err != nil {
+		return nil, err
+	}
+
+	return svc, nil
+}
+
+// init wires the mapping engine, mapping configurations, routes and HTTP server around a tuple store
+func (s *WebhookService) init(tupleStore store.TupleStore) error {
+	s.router = mux.NewRouter()
+	s.metrics = metrics.New()
+
 	// Initialize mapping engine
-	svc.mappingEngine = engine.NewMappingEngineWithClient(svc.fgaClient, cfg.OpenFGA.StoreID, cfg.OpenFGA.ModelFile)
+	s.mappingEngine = engine.NewMappingEngineWithStore(s.metrics.InstrumentStore(tracing.InstrumentStore(tupleStore)), "")
+	s.mappingEngine.SetWriteOptions(engine.WriteOptions{
+		Mode:                engine.WriteMode(s.cfg.OpenFGA.WriteMode),
+		MaxTuplesPerWrite:   s.cfg.OpenFGA.MaxTuplesPerWrite,
+		MaxParallelRequests: s.cfg.OpenFGA.MaxParallelWrites,
+	})
+	s.mappingEngine.SetLogger(s.logger)
 
 	// Load mapping configurations
-	if err := svc.loadMappingConfigs(); err != nil {
-		return nil, fmt.Errorf("failed to load mapping configurations: %w", err)
+	if _, _, err := s.ReloadMappings(context.Background(), TriggerStartup); err != nil {
+		return fmt.Errorf("failed to load mapping configurations: %w", err)
+	}
+
+	// Open the durable event queue
+	if s.cfg.Queue.Dir != "" {
+		eventQueue, err := queue.OpenFileQueue(s.cfg.Queue.Dir)
+		if err != nil {
+			return fmt.Errorf("failed to open event queue: %w", err)
+		}
+		s.queue = eventQueue
+		s.metrics.RegisterQueueDepth(eventQueue.Depth)
+	}
+
+	if s.cfg.DeadLetter.File != "" {
+		s.deadLetters = deadletter.NewFile(s.cfg.DeadLetter.File)
+	}
+
+	// Open the store of processed event IDs
+	if s.cfg.Dedup.TTL > 0 {
+		if s.cfg.Dedup.File != "" {
+			processedEvents, err := dedup.OpenFileStore(s.cfg.Dedup.File, s.cfg.Dedup.TTL)
+			if err != nil {
+				return fmt.Errorf("failed to open dedup store: %w", err)
+			}
+			s.processedEvents = processedEvents
+		} else {
+			s.processedEvents = dedup.NewMemoryStore(s.cfg.Dedup.TTL)
+		}
+	}
+
+	if s.cfg.Ordering.TTL > 0 {
+		s.ordering = ordering.NewTracker(s.cfg.Ordering.TTL)
 	}
 
 	// Setup routes
-	svc.setupRoutes()
+	s.setupRoutes()
 
 	// Create HTTP server
-	svc.server = &http.Server{
-		Addr:         fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler:      svc.router,
-		ReadTimeout:  cfg.Server.ReadTimeout,
-		WriteTimeout: cfg.Server.WriteTimeout,
-		IdleTimeout:  cfg.Server.IdleTimeout,
+	s.server = &http.Server{
+		Addr:         fmt.Sprintf("%s:%d", s.cfg.Server.Host, s.cfg.Server.Port),
+		Handler:      s.router,
+		ReadTimeout:  s.cfg.Server.ReadTimeout,
+		WriteTimeout: s.cfg.Server.WriteTimeout,
+		IdleTimeout:  s.cfg.Server.IdleTimeout,
 	}
 
-	return svc, nil
+	return nil
 }
 
 // initOpenFGAClient initializes the OpenFGA client with the configured authentication
@@ -127,35 +241,58 @@ func (s *WebhookService) initOpenFGAClient() error {
 	return nil
 }
 
-// loadMappingConfigs loads all mapping configuration files
-func (s *WebhookService) loadMappingConfigs() error {
-	var err error
+// modelResolutionTimeout bounds each attempt to resolve the authorization model
+const modelResolutionTimeout = 30 * time.Second
+
+// startResolvingModel resolves the authorization model in the background, retrying with the
+// configured backoff until it succeeds or the service shuts down. Until then, writes and /readyz
+// try to resolve it themselves.
+func (s *WebhookService) startResolvingModel() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopResolving = cancel
+
+	policy := s.retryPolicy()
+	policy.MaxAttempts = -1
+	go retry.Do(ctx, policy, func(ctx context.Context) error {
+		attemptCtx, cancel := context.WithTimeout(ctx, modelResolutionTimeout)
+		defer cancel()
+		if _, err := s.model.ModelID(attemptCtx); err != nil {
+			s.log().WarnContext(ctx, "Failed to resolve authorization model; retrying", "error", err)
+			return retry.Retryable(err)
+		}
+		return nil
+	})
+}
 
-	// Load user mappings
-	s.userConfig, err = config.LoadMappingConfig(s.cfg.Mappings.UserMappings)
+// loadMappingConfigs loads and compiles the mapping configuration files from the configured paths
+func (s *WebhookService) loadMappingConfigs() ([]*engine.CompiledMappingConfig, error) {
+	rawConfigs, err := config.LoadMappingConfigPaths(s.cfg.Mappings.Paths)
 	if err != nil {
-		return fmt.Errorf("failed to load user mappings: %w", err)
+		return nil, err
 	}
 
-	// Load organization mappings
-	s.orgConfig, err = config.LoadMappingConfig(s.cfg.Mappings.OrgMappings)
+	configs, err := engine.CompileAll(rawConfigs)
 	if err != nil {
-		return fmt.Errorf("failed to load organization mappings: %w", err)
+		return nil, err
 	}
 
-	// Load organization member mappings
-	s.orgMemberConfig, err = config.LoadMappingConfig(s.cfg.Mappings.OrgMemberMappings)
-	if err != nil {
-		return fmt.Errorf("failed to load organization member mappings: %w", err)
+	// Reject mappings that would write tuples the authorization model does not allow
+	if s.cfg.OpenFGA.ModelFile != "" {
+		authModel, err := model.Load(s.cfg.OpenFGA.ModelFile)
+		if err != nil {
+			return nil, err
+		}
+		if err := authModel.Validate(rawConfigs); err != nil {
+			return nil, fmt.Errorf("mappings do not match the authorization model in %s:\n%w", s.cfg.OpenFGA.ModelFile, err)
+		}
 	}
 
-	// Load organization role mappings
-	s.orgRoleConfig, err = config.LoadMappingConfig(s.cfg.Mappings.OrgRoleMappings)
-	if err != nil {
-		return fmt.Errorf("failed to load organization role mappings: %w", err)
-	}
+	return configs, nil
+}
 
-	return nil
+// log returns the service's logger
+func (s *WebhookService) log() *slog.Logger {
+	return logging.OrDiscard(s.logger)
 }
 
 // setupRoutes configures the HTTP routes
@@ -163,8 +300,22 @@ func (s *WebhookService) setupRoutes() {
 	// Health check endpoint
 	s.router.HandleFunc("/health", s.handleHealth).Methods("GET")
 
+	// Kubernetes liveness and readiness probes
+	s.router.HandleFunc("/livez", s.handleLiveness).Methods("GET")
+	s.router.HandleFunc("/readyz", s.handleReadiness).Methods("GET")
+
+	// Prometheus metrics endpoint
+	if s.metrics != nil {
+		s.router.Handle("/metrics", s.metrics.Handler()).Methods("GET")
+	}
+
+	// Admin endpoints, enabled by setting an admin token
+	if s.cfg.Admin.Token != "" {
+		s.router.HandleFunc("/admin/reload-mappings", s.handleReloadMappings).Methods("POST")
+	}
+
 	// Auth0 webhook endpoint
-	s.router.HandleFunc("/webhook/auth0", s.handleAuth0Webhook).Methods("POST")
+	s.router.Handle("/webhook/auth0", s.tracingHandler("webhook.handleAuth0Webhook", s.handleAuth0Webhook)).Methods("POST")
 
 	// Add middleware
 	s.router.Use(s.loggingMiddleware)
@@ -173,14 +324,48 @@ func (s *WebhookService) setupRoutes() {
 
 // Start starts the webhook service
 func (s *WebhookService) Start() error {
-	log.Printf("Starting webhook service on %s", s.server.Addr)
+	if s.model != nil {
+		s.startResolvingModel()
+	}
+	if s.queue != nil {
+		s.startWorkers()
+	}
+	if s.cfg.Mappings.WatchInterval > 0 {
+		s.startWatchingMappings(s.cfg.Mappings.WatchInterval)
+	}
+
+	s.log().Info("Starting webhook service", "addr", s.server.Addr)
 	return s.server.ListenAndServe()
 }
 
 // Shutdown gracefully shuts down the webhook service
 func (s *WebhookService) Shutdown(ctx context.Context) error {
-	log.Println("Shutting down webhook service...")
-	return s.server.Shutdown(ctx)
+	s.log().Info("Shutting down webhook service")
+	if s.stopWatching != nil {
+		s.stopWatching()
+	}
+	if s.stopResolving != nil {
+		s.stopResolving()
+	}
+	if err := s.server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	if s.queue != nil {
+		// Events still queued or in flight are kept on disk and processed after restart
+		if err := s.drainWorkers(ctx); err != nil {
+			return err
+		}
+		if err := s.queue.Close(); err != nil {
+			return err
+		}
+	}
+
+	if closer, ok := s.processedEvents.(io.Closer); ok {
+		return closer.Close()
+	}
+
+	return nil
 }
 
 // handleHealth handles health check requests
@@ -198,10 +383,12 @@ func (s *WebhookService) handleHealth(w http.ResponseWriter, r *http.Request) {
 
 // handleAuth0Webhook handles Auth0 webhook events
 func (s *WebhookService) handleAuth0Webhook(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
 	// Read the request body
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		log.Printf("Failed to read request body: %v", err)
+		s.log().WarnContext(ctx, "Failed to read request body", "error", err)
 		http.Error(w, "Failed to read request body", http.StatusBadRequest)
 		return
 	}
@@ -210,7 +397,8 @@ func (s *WebhookService) handleAuth0Webhook(w http.ResponseWriter, r *http.Reque
 	// Verify webhook signature if configured
 	if s.cfg.Auth0.VerifySignature && s.cfg.Auth0.WebhookSecret != "" {
 		if !s.verifyWebhookSignature(r, body) {
-			log.Println("Invalid webhook signature")
+			s.metrics.SignatureFailure()
+			s.log().WarnContext(ctx, "Invalid webhook signature")
 			http.Error(w, "Invalid signature", http.StatusUnauthorized)
 			return
 		}
@@ -219,21 +407,70 @@ func (s *WebhookService) handleAuth0Webhook(w http.ResponseWriter, r *http.Reque
 	// Parse the webhook event
 	var event map[string]interface{}
 	if err := json.Unmarshal(body, &event); err != nil {
-		log.Printf("Failed to parse webhook event: %v", err)
+		s.log().WarnContext(ctx, "Failed to parse webhook event", "error", err)
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	s.metrics.EventReceived(s.eventTypeLabel(event))
+	span := trace.SpanFromContext(ctx)
+	span.SetAttributes(eventAttributes(event)...)
+	ctx = logging.WithAttrs(ctx, logging.EventAttrs(event)...)
+
+	// Hand the event to the durable queue when one is configured
+	if s.queue != nil {
+		if _, ok := event["type"].(string); !ok {
+			s.log().WarnContext(ctx, "Rejecting webhook event without a type")
+			http.Error(w, "Missing event type", http.StatusBadRequest)
+			return
+		}
+
+		// Carry the trace context and request ID through the queue, so that processing continues the request's trace and logs
+		if err := s.queue.Enqueue(body, queueHeaders(ctx)); err != nil {
+			s.log().ErrorContext(ctx, "Failed to enqueue webhook event", "error", err)
+			http.Error(w, "Failed to accept event", http.StatusServiceUnavailable)
+			return
+		}
+
+		response := map[string]interface{}{
+			"status":     "accepted",
+			"timestamp":  time.Now().UTC(),
+			"event_type": event["type"],
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(response)
+		return
+	}
+
+	// Retry only as long as the response can still be written before the server's write timeout
+	if s.cfg.Server.WriteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.cfg.Server.WriteTimeout*4/5)
+		defer cancel()
+	}
 
-	// Process the event
-	if err := s.processEvent(r.Context(), event); err != nil {
-		log.Printf("Failed to process webhook event: %v", err)
+	// Process the event unless it was already processed or is older than its entity's state.
+	// A dead-lettered event is acknowledged so Auth0 does not redeliver it; it is replayed from the file.
+	status := "processed"
+	if s.isDuplicateEvent(ctx, event) {
+		status = "duplicate"
+	} else if err := s.processEventWithRetry(ctx, event); errors.Is(err, errStaleEvent) {
+		status = "stale"
+	} else if errors.Is(err, errDeadLettered) {
+		status = "dead_lettered"
+		tracing.Fail(span, err)
+	} else if err != nil {
+		s.log().ErrorContext(ctx, "Failed to process webhook event", "error", err)
+		tracing.Fail(span, err)
 		http.Error(w, "Failed to process event", http.StatusInternalServerError)
 		return
 	}
+	span.SetAttributes(attribute.String("event.status", status))
 
 	// Return success response
 	response := map[string]interface{}{
-		"status":     "processed",
+		"status":     status,
 		"timestamp":  time.Now().UTC(),
 		"event_type": event["type"],
 	}
@@ -262,50 +499,280 @@ func (s *WebhookService) verifyWebhookSignature(r *http.Request, body []byte) bo
 	return hmac.Equal([]byte(signature), []byte(expectedSignature))
 }
 
-// processEvent processes a webhook event using the appropriate mapping configuration
+// errStaleEvent is returned for events older than the latest event applied to their entity
+var errStaleEvent = errors.New("event is older than the latest event applied to its entity")
+
+// errDeadLettered wraps the error of an event that was recorded in the dead-letter file, so
+// redelivering it cannot succeed where replaying it from the file would
+var errDeadLettered = errors.New("event was dead-lettered")
+
+// errUnmappedEvent is returned by processEvent for event types no mapping configuration declares
+var errUnmappedEvent = errors.New("no mapping configuration declares the event type")
+
+// eventTypeLabel returns the event type to label metrics with. Types no mapping configuration
+// declares are labelled "other", so that arbitrary payloads can't create unbounded label values.
+func (s *WebhookService) eventTypeLabel(event map[string]interface{}) string {
+	eventType, _ := event["type"].(string)
+	if len(engine.ConfigsForEvent(s.activeMappings().configs, eventType)) == 0 {
+		return "other"
+	}
+	return eventType
+}
+
+// processEvent processes a webhook event with every mapping configuration that declares its type.
+// Events for the same entity are processed one at a time, and events older than the latest one
+// applied to the entity are dropped with errStaleEvent. Events of types no configuration declares
+// are ignored with errUnmappedEvent. The whole event is processed with the mapping configurations
+// active when it starts, even if they are reloaded meanwhile.
 func (s *WebhookService) processEvent(ctx context.Context, event map[string]interface{}) error {
 	eventType, ok := event["type"].(string)
 	if !ok {
 		return fmt.Errorf("event type not found or not a string")
 	}
 
-	log.Printf("Processing event: %s", eventType)
+	mappings := s.activeMappings()
+	s.log().InfoContext(ctx, "Processing event", "mapping_version", mappings.version)
 
-	// Determine which mapping configuration to use based on event type
-	var mappingConfig *types.MappingConfig
-	switch {
-	case strings.HasPrefix(eventType, "user."):
-		mappingConfig = s.userConfig
-	case strings.HasPrefix(eventType, "organization.") && !strings.Contains(eventType, "member"):
-		mappingConfig = s.orgConfig
-	case strings.Contains(eventType, "organization.member.role"):
-		mappingConfig = s.orgRoleConfig
-	case strings.Contains(eventType, "organization.member"):
-		mappingConfig = s.orgMemberConfig
-	default:
-		log.Printf("No mapping configuration found for event type: %s", eventType)
-		return nil // Not an error, just ignore unknown event types
+	mappingConfigs := s.routeEvent(ctx, mappings, eventType)
+	if len(mappingConfigs) == 0 {
+		s.log().InfoContext(ctx, "No mapping configuration declares the event type")
+		return errUnmappedEvent
+	}
+
+	stale := 0
+	for _, mappingConfig := range mappingConfigs {
+		err := s.processEventWithConfig(ctx, event, mappingConfig)
+		if errors.Is(err, errStaleEvent) {
+			stale++
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", mappingConfig.Name, err)
+		}
+	}
+
+	if stale == len(mappingConfigs) {
+		return errStaleEvent
+	}
+	return nil
+}
+
+// routeEvent selects the mapping configurations of a set that declare an event type
+func (s *WebhookService) routeEvent(ctx context.Context, mappings *mappingSet, eventType string) []*engine.CompiledMappingConfig {
+	_, span := tracing.Tracer().Start(ctx, "webhook.routeEvent", trace.WithAttributes(
+		tracing.AttrEventType.String(eventType),
+		tracing.AttrMappingVersion.Int(mappings.version),
+	))
+	defer span.End()
+
+	mappingConfigs := engine.ConfigsForEvent(mappings.configs, eventType)
+	names := make([]string, len(mappingConfigs))
+	for i, mappingConfig := range mappingConfigs {
+		names[i] = mappingConfig.Name
+	}
+	span.SetAttributes(attribute.StringSlice("mapping.configs", names))
+
+	return mappingConfigs
+}
+
+// eventAttributes returns the span attributes identifying an event
+func eventAttributes(event map[string]interface{}) []attribute.KeyValue {
+	eventID, _ := event["id"].(string)
+	eventType, _ := event["type"].(string)
+	return []attribute.KeyValue{tracing.AttrEventID.String(eventID), tracing.AttrEventType.String(eventType)}
+}
+
+// processEventWithConfig processes a webhook event with one mapping configuration
+func (s *WebhookService) processEventWithConfig(ctx context.Context, event map[string]interface{}, mappingConfig *engine.CompiledMappingConfig) error {
+	var orderingKey string
+	var eventTime time.Time
+	if s.ordering != nil {
+		entityKey, err := s.mappingEngine.EntityKey(event, mappingConfig)
+		if err != nil {
+			return fmt.Errorf("failed to identify event entity: %w", err)
+		}
+
+		// Order events per mapping configuration, since e.g. membership events don't supersede user events
+		orderingKey = mappingConfig.Name + "/" + entityKey
+		unlock := s.ordering.Lock(orderingKey)
+		defer unlock()
+
+		var hasTime bool
+		if eventTime, hasTime = ordering.EventTime(event); !hasTime {
+			orderingKey = ""
+		} else if s.ordering.IsStale(orderingKey, eventTime) {
+			s.log().InfoContext(ctx, "Dropping stale event", "mapping_config", mappingConfig.Name, "entity", entityKey, "event_time", eventTime.Format(time.RFC3339Nano))
+			return errStaleEvent
+		}
 	}
 
 	// Process the event through the mapping engine
-	if err := s.mappingEngine.ProcessEvent(ctx, event, mappingConfig); err != nil {
+	result, err := s.mappingEngine.ProcessEventWithDetails(ctx, event, mappingConfig)
+	if err != nil {
 		return fmt.Errorf("mapping engine failed to process event: %w", err)
 	}
+	s.metrics.TuplesChanged(result.TuplesAdded, result.TuplesDeleted, s.activeMappings().relations)
+
+	if orderingKey != "" {
+		s.ordering.Record(orderingKey, eventTime)
+	}
 
 	return nil
 }
 
-// loggingMiddleware logs all HTTP requests
+// processEventWithRetry processes an event under the configured retry policy. Events that fail
+// permanently or run out of attempts are dead-lettered, and their error wraps errDeadLettered once
+// recorded; stale events and events interrupted by ctx are not.
+func (s *WebhookService) processEventWithRetry(ctx context.Context, event map[string]interface{}) error {
+	attempts, err := retry.Do(ctx, s.retryPolicy(), func(ctx context.Context) error {
+		return s.processEvent(ctx, event)
+	})
+
+	eventType := s.eventTypeLabel(event)
+	switch {
+	case err == nil:
+		s.metrics.EventProcessed(eventType)
+		s.markEventProcessed(ctx, event)
+		return nil
+	case errors.Is(err, errUnmappedEvent):
+		// Not an error, just ignore unknown event types
+		s.metrics.EventIgnored(eventType, metrics.ReasonUnmapped)
+		s.markEventProcessed(ctx, event)
+		return nil
+	case errors.Is(err, errStaleEvent):
+		s.metrics.EventIgnored(eventType, metrics.ReasonStale)
+		return err
+	case ctx.Err() != nil:
+		return err
+	}
+
+	s.metrics.EventFailed(eventType)
+	if s.deadLetterEvent(ctx, event, attempts, err) {
+		return fmt.Errorf("%w: %w", errDeadLettered, err)
+	}
+	return err
+}
+
+// isDuplicateEvent reports whether an event with the same ID was processed within the dedup TTL
+func (s *WebhookService) isDuplicateEvent(ctx context.Context, event map[string]interface{}) bool {
+	eventID, _ := event["id"].(string)
+	if s.processedEvents == nil || eventID == "" {
+		return false
+	}
+
+	if s.processedEvents.Seen(eventID) {
+		s.metrics.EventIgnored(s.eventTypeLabel(event), metrics.ReasonDuplicate)
+		s.log().InfoContext(ctx, "Skipping duplicate event")
+		return true
+	}
+	return false
+}
+
+// markEventProcessed records an event's ID so redeliveries are skipped
+func (s *WebhookService) markEventProcessed(ctx context.Context, event map[string]interface{}) {
+	eventID, _ := event["id"].(string)
+	if s.processedEvents == nil || eventID == "" {
+		return
+	}
+
+	if err := s.processedEvents.Mark(eventID); err != nil {
+		s.log().ErrorContext(ctx, "Failed to record processed event", "error", err)
+	}
+}
+
+// retryPolicy returns the retry policy from the service configuration; unset fields use retry.DefaultPolicy
+func (s *WebhookService) retryPolicy() retry.Policy {
+	return retry.Policy{
+		MaxAttempts:     s.cfg.Retry.MaxAttempts,
+		InitialInterval: s.cfg.Retry.InitialInterval,
+		MaxInterval:     s.cfg.Retry.MaxInterval,
+		Multiplier:      s.cfg.Retry.Multiplier,
+		Jitter:          s.cfg.Retry.Jitter,
+	}
+}
+
+// deadLetterEvent records an event that could not be processed, and reports whether it was recorded
+func (s *WebhookService) deadLetterEvent(ctx context.Context, event map[string]interface{}, attempts int, err error) bool {
+	s.log().ErrorContext(ctx, "Giving up on event", "attempts", attempts, "error", err)
+	if s.deadLetters == nil {
+		return false
+	}
+
+	entry, entryErr := deadletter.NewEntry(event, attempts, retry.IsRetryable(err), err)
+	if entryErr == nil {
+		entryErr = s.deadLetters.Add(entry)
+	}
+	if entryErr != nil {
+		s.log().ErrorContext(ctx, "Failed to dead-letter event", "error", entryErr)
+		return false
+	}
+	return true
+}
+
+// requestIDHeader carries the ID that correlates the logs of a request, and of its queued event
+const requestIDHeader = "X-Request-ID"
+
+// loggingMiddleware logs all HTTP requests. Each request is tagged with the ID of its X-Request-ID
+// header, or a generated one, which is returned in the response and added to every log of the request.
 func (s *WebhookService) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
+		requestID := r.Header.Get(requestIDHeader)
+		if requestID == "" {
+			requestID = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, requestID)
+		ctx := logging.WithAttrs(r.Context(), slog.String(logging.KeyRequestID, requestID))
+
 		// Create a response writer wrapper to capture status code
 		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 
-		next.ServeHTTP(wrapped, r)
+		next.ServeHTTP(wrapped, r.WithContext(ctx))
+
+		s.log().InfoContext(ctx, "HTTP request", "method", r.Method, "path", r.URL.Path, "status", wrapped.statusCode, "duration", time.Since(start))
+	})
+}
+
+// newRequestID returns a random 128-bit request ID
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+// queueHeaders returns the headers an event is queued with: the trace context and request ID of ctx
+func queueHeaders(ctx context.Context) map[string]string {
+	headers := tracing.Inject(ctx)
+	for _, attr := range logging.Attrs(ctx) {
+		if attr.Key == logging.KeyRequestID {
+			if headers == nil {
+				headers = map[string]string{}
+			}
+			headers[requestIDHeader] = attr.Value.String()
+		}
+	}
+	return headers
+}
+
+// tracingHandler traces requests to a handler as server spans named name, continuing the W3C trace
+// context of the request headers. Responses with a 5xx status mark the span as failed.
+func (s *WebhookService) tracingHandler(name string, handler http.HandlerFunc) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, name, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", r.Method),
+			attribute.String("url.path", r.URL.Path),
+		))
+		defer span.End()
 
-		log.Printf("%s %s %d %v", r.Method, r.URL.Path, wrapped.statusCode, time.Since(start))
+		wrapped := &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
+		handler(wrapped, r.WithContext(ctx))
+
+		span.SetAttributes(attribute.Int("http.response.status_code", wrapped.statusCode))
+		if wrapped.statusCode >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(wrapped.statusCode))
+		}
 	})
 }
 
@@ -314,7 +781,7 @@ func (s *WebhookService) recoveryMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		defer func() {
 			if err := recover(); err != nil {
-				log.Printf("Panic recovered: %v", err)
+				s.log().ErrorContext(r.Context(), "Panic recovered", "panic", err)
 				http.Error(w, "Internal Server Error", http.StatusInternalServerError)
 			}
 		}()
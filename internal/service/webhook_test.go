@@ -2,9 +2,15 @@ package service
 
 import (
 	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -13,6 +19,9 @@ import (
 	"github.com/stretchr/testify/require"
 
 	"mapping-engine/internal/config"
+	"mapping-engine/internal/logging"
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/types"
 )
 
 func TestWebhookService_Health(t *testing.T) {
@@ -35,10 +44,7 @@ func TestWebhookService_Health(t *testing.T) {
 			VerifySignature: false, // Disable signature verification for tests
 		},
 		Mappings: config.MappingsConfig{
-			UserMappings:      "../../configs/user-mappings.yaml",
-			OrgMappings:       "../../configs/organization-mappings.yaml",
-			OrgMemberMappings: "../../configs/organization-member-mappings.yaml",
-			OrgRoleMappings:   "../../configs/organization-role-mappings.yaml",
+			Paths: []string{"../../configs/*-mappings.yaml"},
 		},
 	}
 
@@ -215,3 +221,306 @@ func TestWebhookService_Auth0Webhook_UnknownEventType(t *testing.T) {
 	assert.Equal(t, "processed", response["status"])
 	assert.Equal(t, "unknown.event.type", response["event_type"])
 }
+
+func TestWebhookService_Auth0Webhook_WritesTuples(t *testing.T) {
+	// Create test configuration
+	cfg := &config.ServiceConfig{
+		OpenFGA: config.OpenFGAConfig{
+			StoreID:   "test-store",
+			ModelFile: "../../configs/model.json",
+		},
+		Auth0: config.Auth0Config{
+			VerifySignature: false,
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+	}
+
+	// Create service backed by an in-memory tuple store
+	memoryStore := store.NewMemoryStore()
+	svc, err := NewWebhookServiceWithStore(cfg, memoryStore, nil)
+	require.NoError(t, err)
+
+	event := map[string]interface{}{
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|test-user"},
+				"organization": map[string]interface{}{"id": "org_123"},
+			},
+		},
+	}
+
+	eventJSON, _ := json.Marshal(event)
+	req, err := http.NewRequest("POST", "/webhook/auth0", bytes.NewBuffer(eventJSON))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	// Create response recorder
+	rr := httptest.NewRecorder()
+
+	// Call the handler
+	svc.router.ServeHTTP(rr, req)
+
+	// Check the response and the stored tuples
+	assert.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, []types.ProcessedTuple{
+		{User: "user:auth0|test-user", Relation: "member", Object: "organization:org_123"},
+	}, memoryStore.Tuples())
+}
+
+func TestWebhookService_RejectsMappingsOutsideModel(t *testing.T) {
+	mappingFile := filepath.Join(t.TempDir(), "team-mappings.yaml")
+	require.NoError(t, os.WriteFile(mappingFile, []byte(`events:
+  - type: team.member.added
+mappings:
+  - tuple:
+      user: "user:{{ .data.object.user_id }}"
+      relation: "member"
+      object: "team:{{ .data.object.team_id }}"
+`), 0o644))
+
+	cfg := &config.ServiceConfig{
+		OpenFGA: config.OpenFGAConfig{
+			ModelFile: "../../configs/model.json",
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{mappingFile},
+		},
+	}
+
+	_, err := NewWebhookServiceWithStore(cfg, store.NewMemoryStore(), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `team-mappings.yaml:7: mappings[0].tuple.object: type "team" is not defined in the authorization model`)
+
+	// Without a model file the mappings are not checked
+	cfg.OpenFGA.ModelFile = ""
+	_, err = NewWebhookServiceWithStore(cfg, store.NewMemoryStore(), nil)
+	assert.NoError(t, err)
+}
+
+func TestWebhookService_Auth0Webhook_SkipsRedeliveredEvents(t *testing.T) {
+	cfg := &config.ServiceConfig{
+		Auth0: config.Auth0Config{
+			VerifySignature: false,
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+		Dedup: config.DedupConfig{
+			TTL: time.Hour,
+		},
+	}
+
+	memoryStore := store.NewMemoryStore()
+	svc, err := NewWebhookServiceWithStore(cfg, memoryStore, nil)
+	require.NoError(t, err)
+
+	added := map[string]interface{}{
+		"id":   "evt_added",
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|test-user"},
+				"organization": map[string]interface{}{"id": "org_123"},
+			},
+		},
+	}
+	removed := map[string]interface{}{
+		"id":   "evt_removed",
+		"type": "organization.member.removed",
+		"data": added["data"],
+	}
+
+	assert.Equal(t, http.StatusOK, postEvent(t, svc, added).Code)
+	assert.Equal(t, http.StatusOK, postEvent(t, svc, removed).Code)
+
+	// A late redelivery of the first event must not bring the membership back
+	rr := postEvent(t, svc, added)
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "duplicate", response["status"])
+	assert.Empty(t, memoryStore.Tuples())
+}
+
+func TestWebhookService_Auth0Webhook_DropsStaleEvents(t *testing.T) {
+	cfg := &config.ServiceConfig{
+		Auth0: config.Auth0Config{
+			VerifySignature: false,
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+		Ordering: config.OrderingConfig{
+			TTL: time.Hour,
+		},
+	}
+
+	memoryStore := store.NewMemoryStore()
+	svc, err := NewWebhookServiceWithStore(cfg, memoryStore, nil)
+	require.NoError(t, err)
+
+	userEvent := func(eventType, eventTime string) map[string]interface{} {
+		return map[string]interface{}{
+			"type": eventType,
+			"time": eventTime,
+			"data": map[string]interface{}{
+				"object": map[string]interface{}{
+					"user_id":        "auth0|test-user",
+					"email_verified": true,
+				},
+			},
+		}
+	}
+
+	assert.Equal(t, http.StatusOK, postEvent(t, svc, userEvent("user.created", "2024-01-01T10:00:00Z")).Code)
+	require.NotEmpty(t, memoryStore.Tuples())
+	assert.Equal(t, http.StatusOK, postEvent(t, svc, userEvent("user.deleted", "2024-01-01T12:00:00Z")).Code)
+	require.Empty(t, memoryStore.Tuples())
+
+	// An update from before the deletion arrives late and must not resurrect the user's tuples
+	rr := postEvent(t, svc, userEvent("user.updated", "2024-01-01T11:00:00Z"))
+	assert.Equal(t, http.StatusOK, rr.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "stale", response["status"])
+	assert.Empty(t, memoryStore.Tuples())
+
+	// Events for other mapping configurations are ordered separately
+	memberAdded := map[string]interface{}{
+		"type": "organization.member.added",
+		"time": "2024-01-01T11:30:00Z",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|test-user"},
+				"organization": map[string]interface{}{"id": "org_123"},
+			},
+		},
+	}
+	assert.Equal(t, http.StatusOK, postEvent(t, svc, memberAdded).Code)
+	assert.Len(t, memoryStore.Tuples(), 1)
+}
+
+func TestWebhookService_Metrics(t *testing.T) {
+	cfg := &config.ServiceConfig{
+		Auth0: config.Auth0Config{
+			WebhookSecret:   "secret",
+			VerifySignature: true,
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+		Queue: config.QueueConfig{
+			Dir: t.TempDir(),
+		},
+	}
+
+	svc, err := NewWebhookServiceWithStore(cfg, store.NewMemoryStore(), nil)
+	require.NoError(t, err)
+
+	// The queue is not drained, so the event stays queued and unsigned requests are rejected
+	event, _ := json.Marshal(map[string]interface{}{"type": "organization.member.added"})
+	signed, err := http.NewRequest("POST", "/webhook/auth0", bytes.NewBuffer(event))
+	require.NoError(t, err)
+	mac := hmac.New(sha256.New, []byte("secret"))
+	mac.Write(event)
+	signed.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	svc.router.ServeHTTP(httptest.NewRecorder(), signed)
+
+	unsigned, err := http.NewRequest("POST", "/webhook/auth0", bytes.NewBuffer(event))
+	require.NoError(t, err)
+	svc.router.ServeHTTP(httptest.NewRecorder(), unsigned)
+
+	// Process events directly to record their outcomes
+	ctx := context.Background()
+	require.NoError(t, svc.processEventWithRetry(ctx, map[string]interface{}{
+		"type": "organization.member.added",
+		"data": map[string]interface{}{"object": map[string]interface{}{
+			"user":         map[string]interface{}{"user_id": "auth0|test-user"},
+			"organization": map[string]interface{}{"id": "org_123"},
+		}},
+	}))
+	require.NoError(t, svc.processEventWithRetry(ctx, map[string]interface{}{"type": "user.password.changed"}))
+
+	req, err := http.NewRequest("GET", "/metrics", nil)
+	require.NoError(t, err)
+	rr := httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+
+	body := rr.Body.String()
+	for _, line := range []string{
+		`mapping_engine_events_received_total{type="organization.member.added"} 1`,
+		`mapping_engine_events_processed_total{type="organization.member.added"} 1`,
+		`mapping_engine_events_ignored_total{reason="unmapped",type="other"} 1`,
+		`mapping_engine_tuples_written_total{relation="member"} 1`,
+		`mapping_engine_webhook_signature_failures_total 1`,
+		`mapping_engine_queue_depth 1`,
+		`mapping_engine_openfga_request_duration_seconds_count{operation="write",result="success"} 1`,
+	} {
+		assert.Contains(t, body, line)
+	}
+}
+
+func TestWebhookService_LogsCorrelationIDs(t *testing.T) {
+	cfg := &config.ServiceConfig{
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+	}
+
+	var output bytes.Buffer
+	logger, err := logging.New(&output, "debug", logging.FormatJSON)
+	require.NoError(t, err)
+
+	svc, err := NewWebhookServiceWithStore(cfg, store.NewMemoryStore(), logger)
+	require.NoError(t, err)
+
+	eventJSON, _ := json.Marshal(map[string]interface{}{
+		"id":   "evt_logged",
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|logged"},
+				"organization": map[string]interface{}{"id": "org_1"},
+			},
+		},
+	})
+	req, err := http.NewRequest("POST", "/webhook/auth0", bytes.NewBuffer(eventJSON))
+	require.NoError(t, err)
+	req.Header.Set("X-Request-ID", "req-123")
+	rr := httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusOK, rr.Code)
+	assert.Equal(t, "req-123", rr.Header().Get("X-Request-ID"))
+
+	records := map[string]map[string]interface{}{}
+	for _, line := range bytes.Split(bytes.TrimSpace(output.Bytes()), []byte("\n")) {
+		var record map[string]interface{}
+		require.NoError(t, json.Unmarshal(line, &record), string(line))
+		records[record["msg"].(string)] = record
+	}
+
+	// Service and engine logs of the event carry its IDs; the request log carries the request ID
+	for _, msg := range []string{"Processing event", "Processed event"} {
+		require.Contains(t, records, msg)
+		assert.Equal(t, "req-123", records[msg]["request_id"], msg)
+		assert.Equal(t, "evt_logged", records[msg]["event_id"], msg)
+		assert.Equal(t, "organization.member.added", records[msg]["event_type"], msg)
+	}
+	assert.Equal(t, float64(1), records["Processed event"]["tuples_added"])
+	require.Contains(t, records, "HTTP request")
+	assert.Equal(t, "req-123", records["HTTP request"]["request_id"])
+	assert.Equal(t, float64(http.StatusOK), records["HTTP request"]["status"])
+
+	// A request without an ID is given one
+	req, err = http.NewRequest("GET", "/health", nil)
+	require.NoError(t, err)
+	rr = httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, req)
+	assert.Len(t, rr.Header().Get("X-Request-ID"), 32)
+}
@@ -0,0 +1,115 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"mapping-engine/internal/logging"
+	"mapping-engine/internal/queue"
+	"mapping-engine/internal/tracing"
+)
+
+// startWorkers starts the worker pool that drains the event queue into the mapping engine
+func (s *WebhookService) startWorkers() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.stopWorkers = cancel
+
+	workers := s.cfg.Queue.Workers
+	if workers < 1 {
+		workers = 1
+	}
+
+	s.log().Info("Starting queue workers", "workers", workers, "queued", s.queue.Depth())
+	for i := 0; i < workers; i++ {
+		s.workers.Add(1)
+		go s.runWorker(ctx)
+	}
+}
+
+// drainWorkers stops the worker pool and waits for in-progress events to finish or the context to expire
+func (s *WebhookService) drainWorkers(ctx context.Context) error {
+	if s.stopWorkers == nil {
+		return nil
+	}
+	s.stopWorkers()
+
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// runWorker processes queued events until the context is cancelled or the queue is closed
+func (s *WebhookService) runWorker(ctx context.Context) {
+	defer s.workers.Done()
+
+	for {
+		msg, err := s.queue.Dequeue(ctx)
+		if err != nil {
+			return
+		}
+		s.handleQueuedEvent(ctx, msg)
+	}
+}
+
+// handleQueuedEvent processes a queued event under the retry policy and then acknowledges it,
+// dead-lettering it if it could not be processed. Duplicates of processed events are acknowledged
+// without processing them. If the worker is stopped first, the event
+// stays queued and is delivered again on restart. Processing continues the trace of the request
+// that accepted the event.
+func (s *WebhookService) handleQueuedEvent(ctx context.Context, msg *queue.Message) {
+	ctx, span := tracing.Tracer().Start(tracing.Extract(ctx, msg.Headers), "queue.handleQueuedEvent", trace.WithSpanKind(trace.SpanKindConsumer), trace.WithAttributes(
+		attribute.Int64("queue.message_id", int64(msg.ID)),
+	))
+	defer span.End()
+	ctx = logging.WithAttrs(ctx, slog.Uint64("queue_message_id", msg.ID))
+	if requestID, ok := msg.Headers[requestIDHeader]; ok {
+		ctx = logging.WithAttrs(ctx, slog.String(logging.KeyRequestID, requestID))
+	}
+
+	var event map[string]interface{}
+	if err := json.Unmarshal(msg.Payload, &event); err != nil {
+		s.log().ErrorContext(ctx, "Dropping unreadable queued event", "error", err)
+		tracing.Fail(span, err)
+		s.ackQueuedEvent(ctx, msg)
+		return
+	}
+	span.SetAttributes(eventAttributes(event)...)
+	ctx = logging.WithAttrs(ctx, logging.EventAttrs(event)...)
+
+	if s.isDuplicateEvent(ctx, event) {
+		s.ackQueuedEvent(ctx, msg)
+		return
+	}
+
+	if err := s.processEventWithRetry(ctx, event); err != nil {
+		if !errors.Is(err, errStaleEvent) {
+			tracing.Fail(span, err)
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+
+	s.ackQueuedEvent(ctx, msg)
+}
+
+// ackQueuedEvent removes a handled event from the queue
+func (s *WebhookService) ackQueuedEvent(ctx context.Context, msg *queue.Message) {
+	if err := s.queue.Ack(msg); err != nil {
+		s.log().ErrorContext(ctx, "Failed to acknowledge queued event", "error", err)
+	}
+}
@@ -0,0 +1,318 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"mapping-engine/internal/config"
+	"mapping-engine/internal/deadletter"
+	"mapping-engine/internal/retry"
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/tracing"
+	"mapping-engine/internal/types"
+)
+
+// flakyStore fails the first writes, as OpenFGA would during an outage
+type flakyStore struct {
+	*store.MemoryStore
+	failures atomic.Int32
+}
+
+func (fs *flakyStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	if fs.failures.Add(-1) >= 0 {
+		return retry.Retryable(errors.New("openfga unavailable"))
+	}
+	return fs.MemoryStore.Write(ctx, writes, deletes)
+}
+
+// rejectingStore rejects every write, as OpenFGA does for tuples the model does not allow
+type rejectingStore struct {
+	*store.MemoryStore
+}
+
+func (rs rejectingStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	return errors.New("invalid tuple")
+}
+
+func newQueuedTestService(t *testing.T, tupleStore store.TupleStore) *WebhookService {
+	cfg := &config.ServiceConfig{
+		Auth0: config.Auth0Config{
+			VerifySignature: false,
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+		Queue: config.QueueConfig{
+			Dir:     t.TempDir(),
+			Workers: 2,
+		},
+		Retry: config.RetryConfig{
+			MaxAttempts:     5,
+			InitialInterval: 10 * time.Millisecond,
+			MaxInterval:     50 * time.Millisecond,
+			Multiplier:      2,
+		},
+		DeadLetter: config.DeadLetterConfig{
+			File: filepath.Join(t.TempDir(), "dead-letter.jsonl"),
+		},
+	}
+
+	svc, err := NewWebhookServiceWithStore(cfg, tupleStore, nil)
+	require.NoError(t, err)
+	return svc
+}
+
+func postEvent(t *testing.T, svc *WebhookService, event map[string]interface{}) *httptest.ResponseRecorder {
+	eventJSON, _ := json.Marshal(event)
+	req, err := http.NewRequest("POST", "/webhook/auth0", bytes.NewBuffer(eventJSON))
+	require.NoError(t, err)
+	req.Header.Set("Content-Type", "application/json")
+
+	rr := httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, req)
+	return rr
+}
+
+func TestWebhookService_QueuedEventsRetryUntilWritten(t *testing.T) {
+	tupleStore := &flakyStore{MemoryStore: store.NewMemoryStore()}
+	tupleStore.failures.Store(3)
+
+	svc := newQueuedTestService(t, tupleStore)
+	svc.startWorkers()
+
+	rr := postEvent(t, svc, map[string]interface{}{
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|queued"},
+				"organization": map[string]interface{}{"id": "org_1"},
+			},
+		},
+	})
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+	assert.Equal(t, "accepted", response["status"])
+
+	assert.Eventually(t, func() bool {
+		return len(tupleStore.Tuples()) == 1 && svc.queue.Depth() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, svc.drainWorkers(context.Background()))
+	require.NoError(t, svc.queue.Close())
+}
+
+func TestWebhookService_QueuedEventDeadLettersPermanentFailure(t *testing.T) {
+	svc := newQueuedTestService(t, rejectingStore{MemoryStore: store.NewMemoryStore()})
+	svc.startWorkers()
+
+	rr := postEvent(t, svc, map[string]interface{}{
+		"id":   "evt_rejected",
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|rejected"},
+				"organization": map[string]interface{}{"id": "org_1"},
+			},
+		},
+	})
+	assert.Equal(t, http.StatusAccepted, rr.Code)
+
+	assert.Eventually(t, func() bool {
+		return svc.queue.Depth() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	require.NoError(t, svc.drainWorkers(context.Background()))
+	require.NoError(t, svc.queue.Close())
+
+	entries, err := deadletter.NewFile(svc.cfg.DeadLetter.File).List()
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, "evt_rejected", entries[0].EventID)
+	assert.Equal(t, "organization.member.added", entries[0].EventType)
+	assert.Equal(t, 1, entries[0].Attempts)
+	assert.False(t, entries[0].Retryable)
+	assert.Contains(t, entries[0].Error, "invalid tuple")
+}
+
+func TestWebhookService_QueuedEventWithoutType(t *testing.T) {
+	svc := newQueuedTestService(t, store.NewMemoryStore())
+	defer svc.queue.Close()
+
+	rr := postEvent(t, svc, map[string]interface{}{"data": map[string]interface{}{}})
+	assert.Equal(t, http.StatusBadRequest, rr.Code)
+	assert.Equal(t, 0, svc.queue.Depth())
+}
+
+func TestWebhookService_SynchronousRetriesStopBeforeWriteTimeout(t *testing.T) {
+	tupleStore := &flakyStore{MemoryStore: store.NewMemoryStore()}
+	tupleStore.failures.Store(1000)
+
+	// No retry section: the defaults apply instead of retrying without delay
+	cfg := &config.ServiceConfig{
+		Server: config.ServerConfig{
+			WriteTimeout: 200 * time.Millisecond,
+		},
+		Mappings: config.MappingsConfig{
+			Paths: []string{"../../configs/*-mappings.yaml"},
+		},
+	}
+	svc, err := NewWebhookServiceWithStore(cfg, tupleStore, nil)
+	require.NoError(t, err)
+
+	start := time.Now()
+	rr := postEvent(t, svc, map[string]interface{}{
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|sync"},
+				"organization": map[string]interface{}{"id": "org_1"},
+			},
+		},
+	})
+
+	assert.Equal(t, http.StatusInternalServerError, rr.Code)
+	assert.Less(t, time.Since(start), cfg.Server.WriteTimeout)
+	assert.Equal(t, int32(999), tupleStore.failures.Load()) // the default 500ms backoff leaves room for one attempt
+}
+
+func TestWebhookService_SynchronousDeadLetteredEventIsAcknowledged(t *testing.T) {
+	event := map[string]interface{}{
+		"id":   "evt_rejected",
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|rejected"},
+				"organization": map[string]interface{}{"id": "org_1"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name           string
+		deadLetterFile string
+		expectedCode   int
+		expectedStatus string
+	}{
+		{
+			name:           "dead-lettered events are not redelivered",
+			deadLetterFile: filepath.Join(t.TempDir(), "dead-letter.jsonl"),
+			expectedCode:   http.StatusOK,
+			expectedStatus: "dead_lettered",
+		},
+		{
+			name:         "without a dead-letter file Auth0 redelivers the event",
+			expectedCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ServiceConfig{
+				Mappings: config.MappingsConfig{
+					Paths: []string{"../../configs/*-mappings.yaml"},
+				},
+				DeadLetter: config.DeadLetterConfig{
+					File: tt.deadLetterFile,
+				},
+			}
+			svc, err := NewWebhookServiceWithStore(cfg, rejectingStore{MemoryStore: store.NewMemoryStore()}, nil)
+			require.NoError(t, err)
+
+			rr := postEvent(t, svc, event)
+			require.Equal(t, tt.expectedCode, rr.Code)
+			if tt.deadLetterFile == "" {
+				return
+			}
+
+			var response map[string]interface{}
+			require.NoError(t, json.Unmarshal(rr.Body.Bytes(), &response))
+			assert.Equal(t, tt.expectedStatus, response["status"])
+
+			entries, err := deadletter.NewFile(tt.deadLetterFile).List()
+			require.NoError(t, err)
+			require.Len(t, entries, 1)
+			assert.Equal(t, "evt_rejected", entries[0].EventID)
+			assert.False(t, entries[0].Retryable)
+		})
+	}
+}
+
+func TestWebhookService_TracesQueuedEvent(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+
+	svc := newQueuedTestService(t, store.NewMemoryStore())
+	defer svc.queue.Close()
+
+	eventJSON, _ := json.Marshal(map[string]interface{}{
+		"id":   "evt_traced",
+		"type": "organization.member.added",
+		"data": map[string]interface{}{
+			"object": map[string]interface{}{
+				"user":         map[string]interface{}{"user_id": "auth0|traced"},
+				"organization": map[string]interface{}{"id": "org_1"},
+			},
+		},
+	})
+	req, err := http.NewRequest("POST", "/webhook/auth0", bytes.NewBuffer(eventJSON))
+	require.NoError(t, err)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	rr := httptest.NewRecorder()
+	svc.router.ServeHTTP(rr, req)
+	require.Equal(t, http.StatusAccepted, rr.Code)
+
+	// Process the queued event on this goroutine, as a worker would
+	ctx := context.Background()
+	msg, err := svc.queue.Dequeue(ctx)
+	require.NoError(t, err)
+	svc.handleQueuedEvent(ctx, msg)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		assert.Equal(t, "0af7651916cd43dd8448eb211c80319c", span.SpanContext().TraceID().String(), span.Name())
+		spans[span.Name()] = span
+	}
+	require.Contains(t, spans, "webhook.handleAuth0Webhook")
+	require.Contains(t, spans, "queue.handleQueuedEvent")
+	require.Contains(t, spans, "engine.ProcessEvent")
+	assert.Contains(t, spans, "webhook.routeEvent")
+	assert.Contains(t, spans, "engine.evaluateMapping")
+	assert.Contains(t, spans, "openfga.Write")
+
+	// The request continues the caller's trace, and processing continues the request's
+	assert.Equal(t, "b7ad6b7169203331", spans["webhook.handleAuth0Webhook"].Parent().SpanID().String())
+	assert.Equal(t, spans["webhook.handleAuth0Webhook"].SpanContext().SpanID(), spans["queue.handleQueuedEvent"].Parent().SpanID())
+
+	attributes := map[attribute.Key]attribute.Value{}
+	for _, kv := range spans["engine.ProcessEvent"].Attributes() {
+		attributes[kv.Key] = kv.Value
+	}
+	assert.Equal(t, "evt_traced", attributes[tracing.AttrEventID].AsString())
+	assert.Equal(t, "organization.member.added", attributes[tracing.AttrEventType].AsString())
+	assert.Equal(t, "user:auth0|traced", attributes[tracing.AttrEntityID].AsString())
+	assert.Equal(t, int64(1), attributes[tracing.AttrTuplesWritten].AsInt64())
+}
@@ -0,0 +1,144 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"mapping-engine/internal/types"
+)
+
+// memoryPageSize is the number of tuples returned per Read page, matching the OpenFGA maximum
+const memoryPageSize = 100
+
+// MemoryStore is an in-memory TupleStore. Like OpenFGAStore, it skips writes of existing
+// tuples and deletes of missing ones.
+type MemoryStore struct {
+	mu     sync.RWMutex
+	tuples []types.ProcessedTuple
+	index  map[string]int
+}
+
+// NewMemoryStore creates an in-memory tuple store seeded with the given tuples
+func NewMemoryStore(seed ...types.ProcessedTuple) *MemoryStore {
+	ms := &MemoryStore{
+		index: make(map[string]int),
+	}
+
+	for _, tuple := range seed {
+		if _, exists := ms.index[tupleKey(tuple)]; !exists {
+			ms.add(tuple)
+		}
+	}
+
+	return ms
+}
+
+// Read returns one page of tuples matching the filter
+func (ms *MemoryStore) Read(ctx context.Context, filter Filter, continuationToken string) (*ReadPage, error) {
+	offset := 0
+	if continuationToken != "" {
+		var err error
+		offset, err = strconv.Atoi(continuationToken)
+		if err != nil || offset < 0 {
+			return nil, fmt.Errorf("invalid continuation token: %s", continuationToken)
+		}
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	var matched []types.ProcessedTuple
+	for _, tuple := range ms.tuples {
+		if matchesFilter(tuple, filter) {
+			matched = append(matched, tuple)
+		}
+	}
+
+	page := &ReadPage{}
+	if offset >= len(matched) {
+		return page, nil
+	}
+
+	end := offset + memoryPageSize
+	if end < len(matched) {
+		page.ContinuationToken = strconv.Itoa(end)
+	} else {
+		end = len(matched)
+	}
+	page.Tuples = matched[offset:end]
+
+	return page, nil
+}
+
+// Write applies the writes and deletes as a single change
+func (ms *MemoryStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	for _, tuple := range deletes {
+		ms.remove(tuple)
+	}
+	for _, tuple := range writes {
+		if _, exists := ms.index[tupleKey(tuple)]; !exists {
+			ms.add(tuple)
+		}
+	}
+
+	return nil
+}
+
+// Tuples returns a copy of every tuple in the store
+func (ms *MemoryStore) Tuples() []types.ProcessedTuple {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+
+	tuples := make([]types.ProcessedTuple, len(ms.tuples))
+	copy(tuples, ms.tuples)
+	return tuples
+}
+
+// add appends a tuple; the caller must hold the write lock
+func (ms *MemoryStore) add(tuple types.ProcessedTuple) {
+	ms.index[tupleKey(tuple)] = len(ms.tuples)
+	ms.tuples = append(ms.tuples, tuple)
+}
+
+// remove deletes a tuple, preserving the order of the rest; the caller must hold the write lock
+func (ms *MemoryStore) remove(tuple types.ProcessedTuple) {
+	key := tupleKey(tuple)
+	idx, exists := ms.index[key]
+	if !exists {
+		return
+	}
+
+	ms.tuples = append(ms.tuples[:idx], ms.tuples[idx+1:]...)
+	delete(ms.index, key)
+	for i := idx; i < len(ms.tuples); i++ {
+		ms.index[tupleKey(ms.tuples[i])] = i
+	}
+}
+
+// matchesFilter reports whether a tuple matches a read filter
+func matchesFilter(tuple types.ProcessedTuple, filter Filter) bool {
+	if filter.User != "" && tuple.User != filter.User {
+		return false
+	}
+	if filter.Relation != "" && tuple.Relation != filter.Relation {
+		return false
+	}
+	if filter.Object != "" {
+		if strings.HasSuffix(filter.Object, ":") {
+			return strings.HasPrefix(tuple.Object, filter.Object)
+		}
+		return tuple.Object == filter.Object
+	}
+	return true
+}
+
+// tupleKey returns the identity of a tuple
+func tupleKey(tuple types.ProcessedTuple) string {
+	return fmt.Sprintf("%s#%s#%s", tuple.User, tuple.Relation, tuple.Object)
+}
@@ -0,0 +1,94 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mapping-engine/internal/types"
+)
+
+func TestMemoryStore_ReadFilters(t *testing.T) {
+	ctx := context.Background()
+	ms := NewMemoryStore(
+		types.ProcessedTuple{User: "user:1", Relation: "email_verified", Object: "user:1"},
+		types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:a"},
+		types.ProcessedTuple{User: "organization:a", Relation: "has_tier", Object: "tier:gold"},
+	)
+
+	tests := []struct {
+		name     string
+		filter   Filter
+		expected int
+	}{
+		{name: "all", filter: Filter{}, expected: 3},
+		{name: "user and object type", filter: Filter{User: "user:1", Object: "organization:"}, expected: 1},
+		{name: "user, relation and object type", filter: Filter{User: "user:1", Relation: "email_verified", Object: "user:"}, expected: 1},
+		{name: "object", filter: Filter{Object: "organization:a"}, expected: 1},
+		{name: "no match", filter: Filter{User: "user:2", Object: "user:"}, expected: 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := ms.Read(ctx, tt.filter, "")
+			require.NoError(t, err)
+			assert.Len(t, page.Tuples, tt.expected)
+			assert.Empty(t, page.ContinuationToken)
+		})
+	}
+}
+
+func TestMemoryStore_ReadPagination(t *testing.T) {
+	ctx := context.Background()
+
+	var seed []types.ProcessedTuple
+	for i := 0; i < 250; i++ {
+		seed = append(seed, types.ProcessedTuple{User: fmt.Sprintf("user:%d", i), Relation: "member", Object: "organization:a"})
+	}
+	ms := NewMemoryStore(seed...)
+
+	var read []types.ProcessedTuple
+	token := ""
+	pages := 0
+	for {
+		page, err := ms.Read(ctx, Filter{Object: "organization:a"}, token)
+		require.NoError(t, err)
+		read = append(read, page.Tuples...)
+		pages++
+		if page.ContinuationToken == "" {
+			break
+		}
+		token = page.ContinuationToken
+	}
+
+	assert.Equal(t, 3, pages)
+	assert.Equal(t, seed, read)
+}
+
+func TestMemoryStore_Write(t *testing.T) {
+	ctx := context.Background()
+	existing := types.ProcessedTuple{User: "user:1", Relation: "email_verified", Object: "user:1"}
+	added := types.ProcessedTuple{User: "user:1", Relation: "blocked", Object: "user:1"}
+	ms := NewMemoryStore(existing)
+
+	t.Run("skips writes of existing tuples", func(t *testing.T) {
+		err := ms.Write(ctx, []types.ProcessedTuple{existing}, nil)
+		require.NoError(t, err)
+		assert.Equal(t, []types.ProcessedTuple{existing}, ms.Tuples())
+	})
+
+	t.Run("skips deletes of missing tuples", func(t *testing.T) {
+		err := ms.Write(ctx, nil, []types.ProcessedTuple{added})
+		require.NoError(t, err)
+		assert.Equal(t, []types.ProcessedTuple{existing}, ms.Tuples())
+	})
+
+	t.Run("applies writes and deletes", func(t *testing.T) {
+		err := ms.Write(ctx, []types.ProcessedTuple{added}, []types.ProcessedTuple{existing})
+		require.NoError(t, err)
+		assert.Equal(t, []types.ProcessedTuple{added}, ms.Tuples())
+	})
+}
@@ -0,0 +1,196 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+
+	openfga "github.com/openfga/go-sdk"
+	"github.com/openfga/go-sdk/client"
+
+	"mapping-engine/internal/logging"
+)
+
+// LatestModel is the model ID that resolves to the newest authorization model of a store
+const LatestModel = "latest"
+
+// ModelResolution configures how ResolveModelID picks the authorization model writes are pinned to
+type ModelResolution struct {
+	ModelID    string // Explicit model ID; empty or LatestModel resolves it from the store
+	ModelFile  string // Local model JSON that the resolved model should match; empty accepts the latest model
+	WriteModel bool   // Write ModelFile to the store when no model in the store matches it
+
+	Logger *slog.Logger // Reports a written model or a fallback to the latest one; nil discards
+}
+
+// ResolveModelID returns the ID of the authorization model writes should be pinned to.
+// An explicit ID is checked to exist. Otherwise the newest model matching the local model file
+// is used, writing the file as a new model if WriteModel is set and no model matches. Without a
+// local model file, or when no model matches and WriteModel is not set, the latest model is used.
+func ResolveModelID(ctx context.Context, fgaClient *client.OpenFgaClient, storeID string, resolution ModelResolution) (string, error) {
+	if resolution.ModelID != "" && resolution.ModelID != LatestModel {
+		if err := CheckModel(ctx, fgaClient, storeID, resolution.ModelID); err != nil {
+			return "", err
+		}
+		return resolution.ModelID, nil
+	}
+
+	var local *openfga.WriteAuthorizationModelRequest
+	if resolution.ModelFile != "" {
+		data, err := os.ReadFile(resolution.ModelFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read authorization model: %w", err)
+		}
+		local = &openfga.WriteAuthorizationModelRequest{}
+		if err := json.Unmarshal(data, local); err != nil {
+			return "", fmt.Errorf("failed to parse authorization model %s: %w", resolution.ModelFile, err)
+		}
+	}
+
+	// Models are listed newest first
+	var latest string
+	options := client.ClientReadAuthorizationModelsOptions{StoreId: &storeID}
+	for {
+		response, err := fgaClient.ReadAuthorizationModels(ctx).Options(options).Execute()
+		if err != nil {
+			return "", fmt.Errorf("failed to read authorization models: %w", err)
+		}
+
+		for _, m := range response.AuthorizationModels {
+			if latest == "" {
+				latest = m.Id
+			}
+			if local == nil {
+				return latest, nil
+			}
+			if sameModel(*local, openfga.WriteAuthorizationModelRequest{
+				SchemaVersion:   m.SchemaVersion,
+				TypeDefinitions: m.TypeDefinitions,
+				Conditions:      m.Conditions,
+			}) {
+				return m.Id, nil
+			}
+		}
+
+		if response.ContinuationToken == nil || *response.ContinuationToken == "" {
+			break
+		}
+		options.ContinuationToken = response.ContinuationToken
+	}
+
+	if local != nil && resolution.WriteModel {
+		response, err := fgaClient.WriteAuthorizationModel(ctx).Body(*local).Options(client.ClientWriteAuthorizationModelOptions{
+			StoreId: &storeID,
+		}).Execute()
+		if err != nil {
+			return "", fmt.Errorf("failed to write authorization model %s: %w", resolution.ModelFile, err)
+		}
+		logging.OrDiscard(resolution.Logger).InfoContext(ctx, "Wrote authorization model", "model_file", resolution.ModelFile, "model_id", response.AuthorizationModelId)
+		return response.AuthorizationModelId, nil
+	}
+
+	if latest == "" {
+		return "", fmt.Errorf("store %s has no authorization model", storeID)
+	}
+	if local != nil {
+		logging.OrDiscard(resolution.Logger).WarnContext(ctx, "No authorization model in the store matches the model file; using the latest model", "store_id", storeID, "model_file", resolution.ModelFile, "model_id", latest)
+	}
+	return latest, nil
+}
+
+// ModelResolver resolves the authorization model writes are pinned to on first use. A failed
+// resolution is retried on the next use, so an OpenFGA server that is down when the caller starts
+// only delays its writes.
+type ModelResolver struct {
+	fgaClient  *client.OpenFgaClient
+	storeID    string
+	resolution ModelResolution
+
+	resolveMu sync.Mutex // Serializes resolutions
+	mu        sync.RWMutex
+	modelID   string // Empty until a resolution succeeds
+}
+
+// NewModelResolver creates a resolver of the model ID with the given resolution; see ResolveModelID
+func NewModelResolver(fgaClient *client.OpenFgaClient, storeID string, resolution ModelResolution) *ModelResolver {
+	return &ModelResolver{
+		fgaClient:  fgaClient,
+		storeID:    storeID,
+		resolution: resolution,
+	}
+}
+
+// ModelID returns the resolved model ID, resolving it first if no resolution has succeeded yet
+func (r *ModelResolver) ModelID(ctx context.Context) (string, error) {
+	if modelID := r.resolved(); modelID != "" {
+		return modelID, nil
+	}
+
+	r.resolveMu.Lock()
+	defer r.resolveMu.Unlock()
+	if modelID := r.resolved(); modelID != "" {
+		return modelID, nil
+	}
+
+	modelID, err := ResolveModelID(ctx, r.fgaClient, r.storeID, r.resolution)
+	if err != nil {
+		return "", fmt.Errorf("authorization model is not resolved: %w", err)
+	}
+
+	r.mu.Lock()
+	r.modelID = modelID
+	r.mu.Unlock()
+	logging.OrDiscard(r.resolution.Logger).InfoContext(ctx, "Pinning OpenFGA writes to authorization model", "model_id", modelID)
+	return modelID, nil
+}
+
+// resolved returns the resolved model ID, or "" if no resolution has succeeded yet
+func (r *ModelResolver) resolved() string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.modelID
+}
+
+// CheckStore verifies that the OpenFGA store exists and is reachable
+func CheckStore(ctx context.Context, fgaClient *client.OpenFgaClient, storeID string) error {
+	if storeID == "" {
+		return fmt.Errorf("store ID is not configured")
+	}
+	_, err := fgaClient.GetStore(ctx).Options(client.ClientGetStoreOptions{StoreId: &storeID}).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to read store %s: %w", storeID, err)
+	}
+	return nil
+}
+
+// CheckModel verifies that the authorization model exists in the OpenFGA store
+func CheckModel(ctx context.Context, fgaClient *client.OpenFgaClient, storeID, modelID string) error {
+	if modelID == "" {
+		return fmt.Errorf("authorization model ID is not resolved")
+	}
+	_, err := fgaClient.ReadAuthorizationModel(ctx).Options(client.ClientReadAuthorizationModelOptions{
+		StoreId:              &storeID,
+		AuthorizationModelId: &modelID,
+	}).Execute()
+	if err != nil {
+		return fmt.Errorf("failed to read authorization model %s: %w", modelID, err)
+	}
+	return nil
+}
+
+// sameModel reports whether two authorization models declare the same types, relations and conditions
+func sameModel(a, b openfga.WriteAuthorizationModelRequest) bool {
+	for _, m := range []*openfga.WriteAuthorizationModelRequest{&a, &b} {
+		if m.Conditions != nil && len(*m.Conditions) == 0 {
+			m.Conditions = nil
+		}
+	}
+
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(aJSON, bJSON)
+}
@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	openfga "github.com/openfga/go-sdk"
+	"github.com/openfga/go-sdk/client"
+
+	"mapping-engine/internal/retry"
+	"mapping-engine/internal/types"
+)
+
+// openFGAPageSize is the number of tuples requested per OpenFGA Read page (the server maximum)
+const openFGAPageSize int32 = 100
+
+// OpenFGAStore is a TupleStore backed by an OpenFGA server through the SDK client
+type OpenFGAStore struct {
+	fgaClient *client.OpenFgaClient
+	storeID   string
+	modelID   string // Authorization model writes are validated against; empty uses the latest model
+
+	// Resolves modelID on the first write instead; nil when modelID is fixed
+	resolver *ModelResolver
+}
+
+// NewOpenFGAStore creates a tuple store that reads from and writes to the given OpenFGA store.
+// Writes are pinned to modelID unless it is empty; see ResolveModelID.
+func NewOpenFGAStore(fgaClient *client.OpenFgaClient, storeID, modelID string) *OpenFGAStore {
+	return &OpenFGAStore{
+		fgaClient: fgaClient,
+		storeID:   storeID,
+		modelID:   modelID,
+	}
+}
+
+// NewResolvingOpenFGAStore creates a tuple store like NewOpenFGAStore whose writes are pinned to the
+// model resolved by resolver. Writes fail with a retryable error while the model cannot be resolved.
+func NewResolvingOpenFGAStore(fgaClient *client.OpenFgaClient, storeID string, resolver *ModelResolver) *OpenFGAStore {
+	return &OpenFGAStore{
+		fgaClient: fgaClient,
+		storeID:   storeID,
+		resolver:  resolver,
+	}
+}
+
+// Read returns one page of tuples matching the filter.
+// OpenFGA reads tuples independently of any authorization model, so reads are not pinned.
+func (s *OpenFGAStore) Read(ctx context.Context, filter Filter, continuationToken string) (*ReadPage, error) {
+	body := client.ClientReadRequest{}
+	if filter.User != "" {
+		body.User = &filter.User
+	}
+	if filter.Relation != "" {
+		body.Relation = &filter.Relation
+	}
+	if filter.Object != "" {
+		body.Object = &filter.Object
+	}
+
+	options := client.ClientReadOptions{
+		StoreId:  &s.storeID,
+		PageSize: openfga.PtrInt32(openFGAPageSize),
+	}
+	if continuationToken != "" {
+		options.ContinuationToken = &continuationToken
+	}
+
+	response, err := s.fgaClient.Read(ctx).Body(body).Options(options).Execute()
+	if err != nil {
+		return nil, err
+	}
+
+	page := &ReadPage{
+		Tuples:            make([]types.ProcessedTuple, 0, len(response.Tuples)),
+		ContinuationToken: response.ContinuationToken,
+	}
+	for _, tuple := range response.Tuples {
+		processed := types.ProcessedTuple{
+			User:     tuple.Key.User,
+			Relation: tuple.Key.Relation,
+			Object:   tuple.Key.Object,
+		}
+		if condition := tuple.Key.Condition; condition != nil {
+			processed.Condition = &types.TupleCondition{Name: condition.Name}
+			if condition.Context != nil && len(*condition.Context) > 0 {
+				processed.Condition.Context = *condition.Context
+			}
+		}
+		page.Tuples = append(page.Tuples, processed)
+	}
+
+	return page, nil
+}
+
+// Write applies the writes and deletes in a single OpenFGA Write request. If OpenFGA rejects it
+// because some tuples already exist or were already deleted, e.g. when an event is delivered twice,
+// the change is re-applied one tuple at a time and those tuples are skipped.
+// OpenFGA rejects a request that deletes and writes the same tuple, so when a tuple is rewritten
+// with a new condition the deletes are applied in a request of their own first.
+func (s *OpenFGAStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	if rewritesTuple(writes, deletes) {
+		if err := s.write(ctx, nil, deletes); err != nil {
+			return err
+		}
+		return s.write(ctx, writes, nil)
+	}
+	return s.write(ctx, writes, deletes)
+}
+
+// write applies the writes and deletes in a single OpenFGA Write request; see Write
+func (s *OpenFGAStore) write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	body := client.ClientWriteRequest{}
+
+	if len(writes) > 0 {
+		fgaTuples := make([]client.ClientTupleKey, len(writes))
+		for i, tuple := range writes {
+			fgaTuples[i] = client.ClientTupleKey{
+				User:     tuple.User,
+				Relation: tuple.Relation,
+				Object:   tuple.Object,
+			}
+			if tuple.Condition != nil {
+				fgaTuples[i].Condition = &openfga.RelationshipCondition{Name: tuple.Condition.Name}
+				if len(tuple.Condition.Context) > 0 {
+					fgaTuples[i].Condition.Context = &tuple.Condition.Context
+				}
+			}
+		}
+		body.Writes = fgaTuples
+	}
+
+	if len(deletes) > 0 {
+		fgaTuples := make([]client.ClientTupleKeyWithoutCondition, len(deletes))
+		for i, tuple := range deletes {
+			fgaTuples[i] = client.ClientTupleKeyWithoutCondition{
+				User:     tuple.User,
+				Relation: tuple.Relation,
+				Object:   tuple.Object,
+			}
+		}
+		body.Deletes = fgaTuples
+	}
+
+	modelID := s.modelID
+	if s.resolver != nil {
+		var err error
+		if modelID, err = s.resolver.ModelID(ctx); err != nil {
+			return retry.Retryable(err)
+		}
+	}
+
+	options := client.ClientWriteOptions{
+		StoreId: &s.storeID,
+	}
+	if modelID != "" {
+		options.AuthorizationModelId = &modelID
+	}
+
+	_, err := s.fgaClient.Write(ctx).Body(body).Options(options).Execute()
+	if err == nil || !isAlreadyAppliedError(err) {
+		return err
+	}
+
+	options.Transaction = &client.TransactionOptions{
+		Disable:             true,
+		MaxPerChunk:         1,
+		MaxParallelRequests: 10,
+	}
+
+	response, err := s.fgaClient.Write(ctx).Body(body).Options(options).Execute()
+	if err != nil {
+		return err
+	}
+
+	for _, write := range response.Writes {
+		if write.Error != nil && !isAlreadyAppliedError(write.Error) {
+			return write.Error
+		}
+	}
+	for _, deletion := range response.Deletes {
+		if deletion.Error != nil && !isAlreadyAppliedError(deletion.Error) {
+			return deletion.Error
+		}
+	}
+
+	return nil
+}
+
+// rewritesTuple reports whether a tuple is both deleted and written, i.e. its condition changes
+func rewritesTuple(writes, deletes []types.ProcessedTuple) bool {
+	if len(writes) == 0 || len(deletes) == 0 {
+		return false
+	}
+
+	deleted := make(map[string]bool, len(deletes))
+	for _, tuple := range deletes {
+		deleted[tupleKey(tuple)] = true
+	}
+	for _, tuple := range writes {
+		if deleted[tupleKey(tuple)] {
+			return true
+		}
+	}
+	return false
+}
+
+// isAlreadyAppliedError reports whether OpenFGA rejected a write because a tuple already exists
+// or a delete because a tuple does not exist
+func isAlreadyAppliedError(err error) bool {
+	var validationErr openfga.FgaApiValidationError
+	if !errors.As(err, &validationErr) || validationErr.ResponseCode() != openfga.ERRORCODE_WRITE_FAILED_DUE_TO_INVALID_INPUT {
+		return false
+	}
+
+	message := string(validationErr.Body())
+	return strings.Contains(message, "which already exists") || strings.Contains(message, "which does not exist")
+}
@@ -0,0 +1,232 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openfga/go-sdk/client"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"mapping-engine/internal/retry"
+	"mapping-engine/internal/types"
+)
+
+const (
+	testStoreID = "01HXDB9MNPQR1234567890ABCD"
+	olderModel  = "01HXDB9MNPQR1234567890AAAA"
+	newerModel  = "01HXDB9MNPQR1234567890BBBB"
+	writtenID   = "01HXDB9MNPQR1234567890CCCC"
+)
+
+const localModel = `{"schema_version": "1.1", "type_definitions": [{"type": "user"}]}`
+
+// fakeOpenFGA serves the store, authorization model and write endpoints of a single OpenFGA store
+type fakeOpenFGA struct {
+	models       []map[string]interface{} // Newest first
+	writtenModel map[string]interface{}
+	writeBodies  []map[string]interface{}
+}
+
+func (f *fakeOpenFGA) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	prefix := "/stores/" + testStoreID
+	path := strings.TrimPrefix(r.URL.Path, prefix)
+	w.Header().Set("Content-Type", "application/json")
+
+	switch {
+	case r.Method == http.MethodGet && r.URL.Path == prefix:
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": testStoreID, "name": "test"})
+	case r.Method == http.MethodGet && path == "/authorization-models":
+		json.NewEncoder(w).Encode(map[string]interface{}{"authorization_models": f.models})
+	case r.Method == http.MethodPost && path == "/authorization-models":
+		json.NewDecoder(r.Body).Decode(&f.writtenModel)
+		json.NewEncoder(w).Encode(map[string]interface{}{"authorization_model_id": writtenID})
+	case r.Method == http.MethodGet && strings.HasPrefix(path, "/authorization-models/"):
+		id := strings.TrimPrefix(path, "/authorization-models/")
+		for _, m := range f.models {
+			if m["id"] == id {
+				json.NewEncoder(w).Encode(map[string]interface{}{"authorization_model": m})
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]interface{}{"code": "authorization_model_not_found", "message": "not found"})
+	case r.Method == http.MethodPost && path == "/write":
+		var body map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&body)
+		f.writeBodies = append(f.writeBodies, body)
+		json.NewEncoder(w).Encode(map[string]interface{}{})
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func newFakeOpenFGA(t *testing.T, fake *fakeOpenFGA) *client.OpenFgaClient {
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+
+	fgaClient, err := client.NewSdkClient(&client.ClientConfiguration{ApiUrl: server.URL})
+	require.NoError(t, err)
+	return fgaClient
+}
+
+func storedModel(id, types string) map[string]interface{} {
+	return map[string]interface{}{
+		"id":               id,
+		"schema_version":   "1.1",
+		"type_definitions": json.RawMessage(types),
+	}
+}
+
+func writeModelFile(t *testing.T) string {
+	path := filepath.Join(t.TempDir(), "model.json")
+	require.NoError(t, os.WriteFile(path, []byte(localModel), 0o644))
+	return path
+}
+
+func TestResolveModelID(t *testing.T) {
+	ctx := context.Background()
+	matching := `[{"type": "user"}]`
+	different := `[{"type": "user"}, {"type": "group"}]`
+
+	t.Run("latest without a model file", func(t *testing.T) {
+		fake := &fakeOpenFGA{models: []map[string]interface{}{storedModel(newerModel, different), storedModel(olderModel, matching)}}
+		modelID, err := ResolveModelID(ctx, newFakeOpenFGA(t, fake), testStoreID, ModelResolution{ModelID: LatestModel})
+		require.NoError(t, err)
+		assert.Equal(t, newerModel, modelID)
+	})
+
+	t.Run("newest model matching the model file", func(t *testing.T) {
+		fake := &fakeOpenFGA{models: []map[string]interface{}{storedModel(newerModel, different), storedModel(olderModel, matching)}}
+		modelID, err := ResolveModelID(ctx, newFakeOpenFGA(t, fake), testStoreID, ModelResolution{ModelFile: writeModelFile(t)})
+		require.NoError(t, err)
+		assert.Equal(t, olderModel, modelID)
+	})
+
+	t.Run("no matching model falls back to latest", func(t *testing.T) {
+		fake := &fakeOpenFGA{models: []map[string]interface{}{storedModel(newerModel, different)}}
+		modelID, err := ResolveModelID(ctx, newFakeOpenFGA(t, fake), testStoreID, ModelResolution{ModelFile: writeModelFile(t)})
+		require.NoError(t, err)
+		assert.Equal(t, newerModel, modelID)
+		assert.Nil(t, fake.writtenModel)
+	})
+
+	t.Run("no matching model writes the model file", func(t *testing.T) {
+		fake := &fakeOpenFGA{models: []map[string]interface{}{storedModel(newerModel, different)}}
+		modelID, err := ResolveModelID(ctx, newFakeOpenFGA(t, fake), testStoreID, ModelResolution{ModelFile: writeModelFile(t), WriteModel: true})
+		require.NoError(t, err)
+		assert.Equal(t, writtenID, modelID)
+		assert.Equal(t, "1.1", fake.writtenModel["schema_version"])
+	})
+
+	t.Run("empty store", func(t *testing.T) {
+		_, err := ResolveModelID(ctx, newFakeOpenFGA(t, &fakeOpenFGA{}), testStoreID, ModelResolution{})
+		assert.ErrorContains(t, err, "has no authorization model")
+	})
+
+	t.Run("explicit model ID must exist", func(t *testing.T) {
+		fake := &fakeOpenFGA{models: []map[string]interface{}{storedModel(olderModel, matching)}}
+		fgaClient := newFakeOpenFGA(t, fake)
+
+		modelID, err := ResolveModelID(ctx, fgaClient, testStoreID, ModelResolution{ModelID: olderModel})
+		require.NoError(t, err)
+		assert.Equal(t, olderModel, modelID)
+
+		_, err = ResolveModelID(ctx, fgaClient, testStoreID, ModelResolution{ModelID: newerModel})
+		assert.Error(t, err)
+	})
+}
+
+func TestCheckStore(t *testing.T) {
+	ctx := context.Background()
+	fgaClient := newFakeOpenFGA(t, &fakeOpenFGA{})
+
+	assert.NoError(t, CheckStore(ctx, fgaClient, testStoreID))
+	assert.ErrorContains(t, CheckStore(ctx, fgaClient, olderModel), "failed to read store "+olderModel)
+	assert.ErrorContains(t, CheckStore(ctx, fgaClient, ""), "store ID is not configured")
+}
+
+func TestCheckModel(t *testing.T) {
+	ctx := context.Background()
+	fgaClient := newFakeOpenFGA(t, &fakeOpenFGA{models: []map[string]interface{}{storedModel(olderModel, `[{"type": "user"}]`)}})
+
+	assert.NoError(t, CheckModel(ctx, fgaClient, testStoreID, olderModel))
+	assert.ErrorContains(t, CheckModel(ctx, fgaClient, testStoreID, newerModel), "failed to read authorization model "+newerModel)
+	assert.ErrorContains(t, CheckModel(ctx, fgaClient, testStoreID, ""), "not resolved")
+}
+
+func TestOpenFGAStore_WritePinsModel(t *testing.T) {
+	fake := &fakeOpenFGA{}
+	fgaClient := newFakeOpenFGA(t, fake)
+	tuple := types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:1"}
+
+	require.NoError(t, NewOpenFGAStore(fgaClient, testStoreID, olderModel).Write(context.Background(), []types.ProcessedTuple{tuple}, nil))
+	require.NoError(t, NewOpenFGAStore(fgaClient, testStoreID, "").Write(context.Background(), []types.ProcessedTuple{tuple}, nil))
+
+	require.Len(t, fake.writeBodies, 2)
+	assert.Equal(t, olderModel, fake.writeBodies[0]["authorization_model_id"])
+	assert.Empty(t, fake.writeBodies[1]["authorization_model_id"])
+}
+
+func TestModelResolver_RetriesUntilResolved(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeOpenFGA{}
+	fgaClient := newFakeOpenFGA(t, fake)
+	resolver := NewModelResolver(fgaClient, testStoreID, ModelResolution{ModelID: LatestModel})
+	openFGAStore := NewResolvingOpenFGAStore(fgaClient, testStoreID, resolver)
+	tuple := types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:1"}
+
+	// Writes fail, and may be retried, while the store has no model to pin them to
+	_, err := resolver.ModelID(ctx)
+	assert.ErrorContains(t, err, "authorization model is not resolved")
+	err = openFGAStore.Write(ctx, []types.ProcessedTuple{tuple}, nil)
+	assert.True(t, retry.IsRetryable(err))
+	assert.Empty(t, fake.writeBodies)
+
+	// The next use resolves the model, which then stays pinned
+	fake.models = []map[string]interface{}{storedModel(olderModel, `[{"type": "user"}]`)}
+	require.NoError(t, openFGAStore.Write(ctx, []types.ProcessedTuple{tuple}, nil))
+	fake.models = []map[string]interface{}{storedModel(newerModel, `[{"type": "user"}]`)}
+	modelID, err := resolver.ModelID(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, olderModel, modelID)
+
+	require.Len(t, fake.writeBodies, 1)
+	assert.Equal(t, olderModel, fake.writeBodies[0]["authorization_model_id"])
+}
+
+func TestOpenFGAStore_WriteConditions(t *testing.T) {
+	fake := &fakeOpenFGA{}
+	openFGAStore := NewOpenFGAStore(newFakeOpenFGA(t, fake), testStoreID, "")
+
+	plain := types.ProcessedTuple{User: "user:1", Relation: "member", Object: "organization:1"}
+	conditional := plain
+	conditional.Condition = &types.TupleCondition{
+		Name:    "not_expired",
+		Context: map[string]interface{}{"expires_at": "2027-01-01T00:00:00Z"},
+	}
+
+	// Replacing a tuple's condition deletes it in a request of its own before writing it again
+	require.NoError(t, openFGAStore.Write(context.Background(), []types.ProcessedTuple{conditional}, []types.ProcessedTuple{plain}))
+
+	require.Len(t, fake.writeBodies, 2)
+	assert.Contains(t, fake.writeBodies[0], "deletes")
+	assert.NotContains(t, fake.writeBodies[0], "writes")
+	assert.Equal(t, map[string]interface{}{
+		"tuple_keys": []interface{}{map[string]interface{}{
+			"user":     "user:1",
+			"relation": "member",
+			"object":   "organization:1",
+			"condition": map[string]interface{}{
+				"name":    "not_expired",
+				"context": map[string]interface{}{"expires_at": "2027-01-01T00:00:00Z"},
+			},
+		}},
+	}, fake.writeBodies[1]["writes"])
+}
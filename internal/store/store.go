@@ -0,0 +1,51 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"mapping-engine/internal/types"
+)
+
+// Filter restricts which tuples a Read returns, following OpenFGA Read semantics.
+// Empty fields match anything; an Object of just "type:" matches every object of that type.
+type Filter struct {
+	User     string
+	Relation string
+	Object   string
+}
+
+// ReadPage is a single page of Read results
+type ReadPage struct {
+	Tuples            []types.ProcessedTuple
+	ContinuationToken string // Empty when there are no more pages
+}
+
+// TupleStore is the storage backend the mapping engine reads tuples from and writes tuples to
+type TupleStore interface {
+	// Read returns one page of tuples matching the filter, starting at the continuation token
+	Read(ctx context.Context, filter Filter, continuationToken string) (*ReadPage, error)
+
+	// Write applies the writes and deletes as a single change. Writing a tuple that already exists
+	// or deleting one that does not is not an error, so a change can safely be applied twice.
+	// A tuple may be both deleted and written to replace its condition; deletes are applied first.
+	Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error
+}
+
+// LoadTuplesFile loads tuples from a JSON file containing an array of {"user", "relation", "object"} objects,
+// each with an optional {"name", "context"} condition
+func LoadTuplesFile(path string) ([]types.ProcessedTuple, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tuples file: %w", err)
+	}
+
+	var tuples []types.ProcessedTuple
+	if err := json.Unmarshal(data, &tuples); err != nil {
+		return nil, fmt.Errorf("failed to parse tuples file: %w", err)
+	}
+
+	return tuples, nil
+}
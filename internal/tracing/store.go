@@ -0,0 +1,48 @@
+package tracing
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/types"
+)
+
+// InstrumentStore returns a tuple store that records a client span for every Read and Write of tupleStore
+func InstrumentStore(tupleStore store.TupleStore) store.TupleStore {
+	return &tracedStore{TupleStore: tupleStore}
+}
+
+// tracedStore traces the requests of a tuple store
+type tracedStore struct {
+	store.TupleStore
+}
+
+func (s *tracedStore) Read(ctx context.Context, filter store.Filter, continuationToken string) (*store.ReadPage, error) {
+	ctx, span := Tracer().Start(ctx, "openfga.Read", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		attribute.String("openfga.filter.user", filter.User),
+		attribute.String("openfga.filter.relation", filter.Relation),
+		attribute.String("openfga.filter.object", filter.Object),
+		attribute.Bool("openfga.continuation", continuationToken != ""),
+	))
+
+	page, err := s.TupleStore.Read(ctx, filter, continuationToken)
+	if err == nil {
+		span.SetAttributes(AttrTuplesRead.Int(len(page.Tuples)))
+	}
+	End(span, err)
+	return page, err
+}
+
+func (s *tracedStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	ctx, span := Tracer().Start(ctx, "openfga.Write", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		AttrTuplesWritten.Int(len(writes)),
+		AttrTuplesDeleted.Int(len(deletes)),
+	))
+
+	err := s.TupleStore.Write(ctx, writes, deletes)
+	End(span, err)
+	return err
+}
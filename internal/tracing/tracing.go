@@ -0,0 +1,133 @@
+// Package tracing sets up OpenTelemetry tracing for the webhook service and instruments its
+// OpenFGA requests. Spans are created with Tracer, which records nothing until Setup installs
+// an exporter.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "mapping-engine"
+
+// Exporters accepted by Options.Exporter
+const (
+	ExporterNone   = "none"   // Tracing disabled
+	ExporterOTLP   = "otlp"   // OTLP over HTTP, e.g. to an OpenTelemetry Collector, Jaeger or Tempo
+	ExporterStdout = "stdout" // One JSON document per span, for local debugging and tests
+)
+
+// Span attributes set by the service and the mapping engine
+const (
+	AttrEventID        = attribute.Key("event.id")
+	AttrEventType      = attribute.Key("event.type")
+	AttrEntityID       = attribute.Key("entity.id")
+	AttrMappingConfig  = attribute.Key("mapping.config")   // Name of the mapping configuration
+	AttrMapping        = attribute.Key("mapping.position") // e.g. "configs/user-mappings.yaml:12: mappings[2]"
+	AttrMappingVersion = attribute.Key("mapping.version")  // Version of the active set of mapping configurations
+	AttrTuplesMatched  = attribute.Key("tuples.matched")   // Tuples produced by mappings
+	AttrTuplesWritten  = attribute.Key("tuples.written")
+	AttrTuplesDeleted  = attribute.Key("tuples.deleted")
+	AttrTuplesRead     = attribute.Key("tuples.read")
+)
+
+// Options configures the exporter installed by Setup
+type Options struct {
+	Exporter    string  // One of the Exporter constants; empty disables tracing
+	Endpoint    string  // OTLP endpoint URL, e.g. "http://localhost:4318"; empty uses OTEL_EXPORTER_OTLP_ENDPOINT
+	ServiceName string  // service.name resource attribute
+	SampleRatio float64 // Fraction of new traces recorded; traces continued from a caller follow its sampling decision
+
+	Writer io.Writer // Destination of the stdout exporter; defaults to os.Stdout
+}
+
+// Setup installs the global tracer provider and the W3C trace context propagator, and returns a
+// function that flushes and stops the exporter. With ExporterNone, only the propagator is installed.
+func Setup(ctx context.Context, options Options) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch options.Exporter {
+	case ExporterNone, "":
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		var exporterOptions []otlptracehttp.Option
+		if options.Endpoint != "" {
+			exporterOptions = append(exporterOptions, otlptracehttp.WithEndpointURL(options.Endpoint))
+		}
+		exporter, err = otlptracehttp.New(ctx, exporterOptions...)
+	case ExporterStdout:
+		writer := options.Writer
+		if writer == nil {
+			writer = os.Stdout
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(writer))
+	default:
+		return nil, fmt.Errorf("unknown trace exporter %q", options.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s trace exporter: %w", options.Exporter, err)
+	}
+
+	serviceResource, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceName(options.ServiceName)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create trace resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(serviceResource),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(options.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+
+	return provider.Shutdown, nil
+}
+
+// Tracer returns the tracer of the mapping engine, backed by the global tracer provider
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Fail records err on the span and marks the span as failed
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End records err, if any, as the span's status and ends the span
+func End(span trace.Span, err error) {
+	if err != nil {
+		Fail(span, err)
+	}
+	span.End()
+}
+
+// Inject returns the trace context of ctx as string headers, to carry it through the event queue
+func Inject(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// Extract returns ctx with the trace context carried by headers returned from Inject
+func Extract(ctx context.Context, headers map[string]string) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(headers))
+}
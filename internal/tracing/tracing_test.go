@@ -0,0 +1,113 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
+
+	"mapping-engine/internal/store"
+	"mapping-engine/internal/types"
+)
+
+// resetGlobals restores the no-op tracer provider and propagator after a test
+func resetGlobals(t *testing.T) {
+	t.Cleanup(func() {
+		otel.SetTracerProvider(noop.NewTracerProvider())
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator())
+	})
+}
+
+// failingStore rejects every write
+type failingStore struct {
+	*store.MemoryStore
+}
+
+func (fs failingStore) Write(ctx context.Context, writes, deletes []types.ProcessedTuple) error {
+	return errors.New("openfga unavailable")
+}
+
+func TestSetup_StdoutExporter(t *testing.T) {
+	resetGlobals(t)
+	ctx := context.Background()
+	var output bytes.Buffer
+
+	shutdown, err := Setup(ctx, Options{Exporter: ExporterStdout, ServiceName: "mapping-engine-test", SampleRatio: 1, Writer: &output})
+	require.NoError(t, err)
+
+	_, span := Tracer().Start(ctx, "test.span")
+	span.SetAttributes(AttrEventID.String("evt_1"))
+	span.End()
+	require.NoError(t, shutdown(ctx))
+
+	assert.Contains(t, output.String(), `"Name":"test.span"`)
+	assert.Contains(t, output.String(), `"evt_1"`)
+	assert.Contains(t, output.String(), `"mapping-engine-test"`)
+}
+
+func TestSetup_Errors(t *testing.T) {
+	resetGlobals(t)
+
+	shutdown, err := Setup(context.Background(), Options{Exporter: ExporterNone})
+	require.NoError(t, err)
+	assert.NoError(t, shutdown(context.Background()))
+
+	_, err = Setup(context.Background(), Options{Exporter: "jaeger"})
+	assert.ErrorContains(t, err, `unknown trace exporter "jaeger"`)
+}
+
+func TestInjectExtract(t *testing.T) {
+	resetGlobals(t)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	ctx, span := Tracer().Start(context.Background(), "request")
+	defer span.End()
+
+	headers := Inject(ctx)
+	require.Contains(t, headers, "traceparent")
+
+	_, child := Tracer().Start(Extract(context.Background(), headers), "queued")
+	defer child.End()
+	assert.Equal(t, span.SpanContext().TraceID(), child.SpanContext().TraceID())
+
+	assert.Nil(t, Inject(context.Background()))
+}
+
+func TestInstrumentStore(t *testing.T) {
+	resetGlobals(t)
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	ctx := context.Background()
+	tuple := types.ProcessedTuple{User: "user:1", Relation: "member", Object: "group:1"}
+
+	memoryStore := InstrumentStore(store.NewMemoryStore())
+	require.NoError(t, memoryStore.Write(ctx, []types.ProcessedTuple{tuple}, nil))
+	_, err := memoryStore.Read(ctx, store.Filter{User: tuple.User}, "")
+	require.NoError(t, err)
+	assert.Error(t, InstrumentStore(failingStore{store.NewMemoryStore()}).Write(ctx, nil, []types.ProcessedTuple{tuple}))
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 3)
+
+	assert.Equal(t, "openfga.Write", spans[0].Name())
+	assert.Contains(t, spans[0].Attributes(), AttrTuplesWritten.Int(1))
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "openfga.Read", spans[1].Name())
+	assert.Contains(t, spans[1].Attributes(), AttrTuplesRead.Int(1))
+
+	assert.Equal(t, "openfga.Write", spans[2].Name())
+	assert.Contains(t, spans[2].Attributes(), AttrTuplesDeleted.Int(1))
+	assert.Equal(t, codes.Error, spans[2].Status().Code)
+}
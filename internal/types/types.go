@@ -1,5 +1,10 @@
 package types
 
+import (
+	"fmt"
+	"strings"
+)
+
 // EventMapping defines which Auth0 events map to which actions
 type EventMapping struct {
 	Type   string `yaml:"type" json:"type"`
@@ -8,6 +13,23 @@ type EventMapping struct {
 
 // TupleDefinition defines the structure of an OpenFGA tuple
 type TupleDefinition struct {
+	User      string          `yaml:"user" json:"user"`
+	Relation  string          `yaml:"relation" json:"relation"`
+	Object    string          `yaml:"object" json:"object"`
+	Condition *TupleCondition `yaml:"condition,omitempty" json:"condition,omitempty"` // Written as an OpenFGA conditional tuple when set
+}
+
+// TupleCondition is the OpenFGA condition a tuple is written with.
+// In a tuple definition, string context values are templates; other values are passed through as-is.
+type TupleCondition struct {
+	Name    string                 `yaml:"name" json:"name"`
+	Context map[string]interface{} `yaml:"context,omitempty" json:"context,omitempty"`
+}
+
+// TupleScope defines the set of tuples a mapping owns, which the update diff reads and reconciles.
+// Fields are templates; a value of just "type:" matches any ID of that type.
+// Either the user or the object must resolve to a single entity.
+type TupleScope struct {
 	User     string `yaml:"user" json:"user"`
 	Relation string `yaml:"relation" json:"relation"`
 	Object   string `yaml:"object" json:"object"`
@@ -15,21 +37,63 @@ type TupleDefinition struct {
 
 // TupleMapping defines conditional mappings from Auth0 events to OpenFGA tuples
 type TupleMapping struct {
+	ForEach   string          `yaml:"for_each,omitempty" json:"for_each,omitempty"` // Expression yielding an array or map; the mapping is applied once per element, bound as item and key
 	Condition string          `yaml:"condition" json:"condition"`
 	Tuple     TupleDefinition `yaml:"tuple" json:"tuple"`
+	Owns      *TupleScope     `yaml:"owns,omitempty" json:"owns,omitempty"`         // Inferred from the tuple when omitted
+	OnError   string          `yaml:"on_error,omitempty" json:"on_error,omitempty"` // OnErrorFail or OnErrorSkip; defaults to the configuration's on_error
+}
+
+// Policies for a mapping whose for_each, condition or templates fail to evaluate
+const (
+	OnErrorFail = "fail" // Fail the event, so it is retried and eventually dead-lettered
+	OnErrorSkip = "skip" // Skip the mapping, report it, and apply the event's other mappings
+)
+
+// EntityConfig declares how to identify the entity an event is about
+type EntityConfig struct {
+	Type string `yaml:"type" json:"type"` // OpenFGA type of the entity, e.g. "user"
+	ID   string `yaml:"id" json:"id"`     // Template rendering the entity ID, e.g. "{{ .data.object.user_id }}"
 }
 
 // MappingConfig contains the complete configuration for mapping Auth0 events
 type MappingConfig struct {
+	Name     string         `yaml:"name,omitempty" json:"name,omitempty"`     // Defaults to the file name without extension when loaded from a file
+	Entity   *EntityConfig  `yaml:"entity,omitempty" json:"entity,omitempty"` // Falls back to user_id, id, then user.user_id when omitted
 	Events   []EventMapping `yaml:"events" json:"events"`
 	Mappings []TupleMapping `yaml:"mappings" json:"mappings"`
+	OnError  string         `yaml:"on_error,omitempty" json:"on_error,omitempty"` // Default on_error of the mappings; OnErrorFail when omitted
+
+	Source string         `yaml:"-" json:"-"` // File the configuration was loaded from, if any
+	Lines  map[string]int `yaml:"-" json:"-"` // Line of each YAML field in Source, keyed by path such as "mappings[0].condition"
+}
+
+// Position returns the "file:line" of a field path such as "mappings[0].tuple.user", falling back to
+// the closest enclosing field with a known line, and to the configuration name when not loaded from a file
+func (c *MappingConfig) Position(path string) string {
+	if c.Source == "" {
+		return c.Name
+	}
+
+	for p := path; p != ""; {
+		if line, ok := c.Lines[p]; ok {
+			return fmt.Sprintf("%s:%d", c.Source, line)
+		}
+		idx := strings.LastIndexAny(p, ".[")
+		if idx < 0 {
+			break
+		}
+		p = p[:idx]
+	}
+	return c.Source
 }
 
 // ProcessedTuple represents a tuple that has been processed with templates
 type ProcessedTuple struct {
-	User     string
-	Relation string
-	Object   string
+	User      string          `json:"user"`
+	Relation  string          `json:"relation"`
+	Object    string          `json:"object"`
+	Condition *TupleCondition `json:"condition,omitempty"`
 }
 
 // Auth0Event represents the structure of an Auth0 event